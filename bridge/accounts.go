@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"fmt"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+// accountTrack updates the services account of the sender of m, from extended-join, account-notify and account-tag.
+func (b *Bridge) accountTrack(m *irc.Message) {
+	if m.Prefix == nil || m.Name == "" {
+		return
+	}
+	nick := strings.ToLower(m.Name)
+	b.accountLock.Lock()
+	defer b.accountLock.Unlock()
+	if account, ok := m.Tags["account"]; ok {
+		b.accountSet(nick, string(account))
+	}
+	switch m.Command {
+	case "JOIN":
+		// with extended-join: JOIN <channel> <account> <realname>
+		if len(m.Params) > 2 {
+			b.accountSet(nick, m.Params[1])
+		}
+	case "ACCOUNT":
+		if len(m.Params) > 0 {
+			b.accountSet(nick, m.Params[0])
+		}
+	case "NICK":
+		if len(m.Params) > 0 {
+			if account, ok := b.ircAccounts[nick]; ok {
+				delete(b.ircAccounts, nick)
+				b.ircAccounts[strings.ToLower(m.Params[0])] = account
+			}
+		}
+	case "QUIT":
+		delete(b.ircAccounts, nick)
+	}
+}
+
+// accountSet records the account of nick, or that it is not logged in if account is *.
+// accountLock must be held.
+func (b *Bridge) accountSet(nick string, account string) {
+	if account == "*" || account == "" {
+		delete(b.ircAccounts, nick)
+	} else {
+		b.ircAccounts[nick] = account
+	}
+}
+
+// ircAccount returns the services account of IRC user nick, or an empty string if they are not logged in.
+func (b *Bridge) ircAccount(nick string) string {
+	b.accountLock.Lock()
+	defer b.accountLock.Unlock()
+	return b.ircAccounts[strings.ToLower(nick)]
+}
+
+// ircIdentity returns the key identifying IRC user nick, e.g. for rate limits: their account if they are
+// logged in, which is stable across nick changes, or their lowercase nick.
+func (b *Bridge) ircIdentity(nick string) string {
+	if account := b.ircAccount(nick); account != "" {
+		return "account " + strings.ToLower(account)
+	}
+	return strings.ToLower(nick)
+}
+
+// ircName returns the name of IRC user nick shown on Discord, with their account when configured.
+func (b *Bridge) ircName(nick string, account string) string {
+	if b.cfg.ShowAccounts && account != "" && !strings.EqualFold(account, nick) {
+		return fmt.Sprintf("%s (%s)", nick, account)
+	}
+	return nick
+}
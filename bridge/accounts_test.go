@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestAccountTracking(t *testing.T) {
+	h := newHarness(t, "showAccounts: true\n")
+	h.fromIRC(":carol!c@host JOIN #test carolacc :Carol")
+	h.fromIRC(":dave!d@host JOIN #test * :Dave")
+	sent := h.discord.take()
+	want := []string{"\u200b*carol (carolacc)*\u200b has joined the channel", "\u200b*dave*\u200b has joined the channel"}
+	if len(sent) != len(want) {
+		t.Fatalf("got discord messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if m.Content != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, m.Content, want[i])
+		}
+	}
+
+	h.fromIRC(":dave!d@host ACCOUNT daveacc")
+	h.fromIRC(":carol!c@host NICK carol2")
+	for nick, want := range map[string]string{"carol": "", "carol2": "carolacc", "dave": "daveacc"} {
+		if got := h.b.ircAccount(nick); got != want {
+			t.Errorf("account of %s: got %q, want %q", nick, got, want)
+		}
+	}
+	if got := h.b.ircIdentity("CAROL2"); got != "account carolacc" {
+		t.Errorf("got identity %q, want the account", got)
+	}
+
+	h.discord.take()
+	h.fromIRC("@msgid=i1 :carol2!c@host PRIVMSG #test :hi")
+	if sent := h.discord.take(); len(sent) != 1 || sent[0].Content != "\u200b**<carol2 (carolacc)>**\u200b hi" {
+		t.Errorf("got discord messages %v, want the account after the nick", sent)
+	}
+	h.fromIRC(":dave!d@host ACCOUNT *")
+	h.fromIRC(":carol2!c@host QUIT :bye")
+	if h.b.ircAccount("dave") != "" || h.b.ircAccount("carol2") != "" {
+		t.Errorf("accounts kept after logout and quit")
+	}
+}
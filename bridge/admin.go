@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"fmt"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+// Admin commands are sent to the bridge in IRC private messages by the services accounts of cfg.Admins,
+// to debug the correlation of IRC and Discord messages, on which replies, edits and redactions rely,
+// and the latency of the relay:
+//
+//	!ids            show the sizes of the ID maps
+//	!ids <id>       show what an IRC or Discord message ID maps to
+//	!ids purge      clear the ID maps
+//	!ids rebuild    clear the ID maps, then fill them from the IRC history of the bridged channels
+//	!stats          show the average latencies of the relay
+
+// adminCommand handles the admin command in the private message m, returning false if m is not an admin command.
+func (b *Bridge) adminCommand(c ircConn, m *irc.Message) bool {
+	args := strings.Fields(m.Params[1])
+	if len(args) == 0 || (args[0] != "!ids" && args[0] != "!stats") {
+		return false
+	}
+	if !b.adminAllowed(m) {
+		adminReply(c, m.Name, "permission denied")
+		return true
+	}
+	if args[0] == "!stats" {
+		b.statsReply(c, m.Name)
+		return true
+	}
+	switch {
+	case len(args) == 1:
+		adminReply(c, m.Name, b.idStats())
+	case args[1] == "purge":
+		adminReply(c, m.Name, fmt.Sprintf("purged the ID maps (%s)", b.idPurge()))
+	case args[1] == "rebuild":
+		b.idPurge()
+		seen := make(map[string]bool)
+		for _, chs := range b.mappings() {
+			for _, ch := range chs {
+				if seen[ch.IRC] {
+					continue
+				}
+				seen[ch.IRC] = true
+				if !b.historyRebuild(ch.IRC) {
+					adminReply(c, m.Name, "purged the ID maps, but the server does not support history to rebuild them")
+					return true
+				}
+			}
+		}
+		adminReply(c, m.Name, fmt.Sprintf("purged the ID maps, rebuilding them from the history of %d channels", len(seen)))
+	default:
+		for _, line := range b.idLookup(args[1]) {
+			adminReply(c, m.Name, line)
+		}
+	}
+	return true
+}
+
+// adminAllowed returns whether the sender of m is logged in to an admin account.
+func (b *Bridge) adminAllowed(m *irc.Message) bool {
+	account := b.ircSenderAccount(m)
+	if account == "" {
+		return false
+	}
+	for _, a := range b.cfg.Admins {
+		if strings.EqualFold(a, account) {
+			return true
+		}
+	}
+	return false
+}
+
+func adminReply(c ircConn, nick string, text string) {
+	c.WriteMessage(&irc.Message{
+		Command: "NOTICE",
+		Params:  []string{nick, text},
+	})
+}
+
+// idStats returns the sizes of the ID maps.
+func (b *Bridge) idStats() string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	return fmt.Sprintf("%d IRC messages, %d Discord messages, %d Discord channels of messages, %d IRC channels of messages",
+		len(b.idIRCDiscord), len(b.idDiscordIRC), len(b.idDiscordChannel), len(b.idIRCChannel))
+}
+
+// idPurge clears the ID maps, returning their sizes before.
+func (b *Bridge) idPurge() string {
+	stats := b.idStats()
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	b.idIRCDiscord = make(map[string][]string)
+	b.idDiscordIRC = make(map[string][]string)
+	b.idDiscordChannel = make(map[string]string)
+	b.idIRCChannel = make(map[string]string)
+	b.idOrder = nil
+	b.idIRCText = make(map[string]string)
+	b.idEvicted = make(map[string][]string)
+	b.idCoalesced = make(map[string][]string)
+	return stats
+}
+
+// idLookup returns what the IRC or Discord message id maps to.
+func (b *Bridge) idLookup(id string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	var lines []string
+	if ircIDs, ok := b.idDiscordIRC[id]; ok {
+		line := fmt.Sprintf("Discord message %s", id)
+		if dc, ok := b.idDiscordChannel[id]; ok {
+			line += fmt.Sprintf(" (channel %s)", dc)
+		}
+		ids := make([]string, 0, len(ircIDs))
+		for _, ircID := range ircIDs {
+			if ic, ok := b.idIRCChannel[ircID]; ok {
+				ircID += fmt.Sprintf(" (channel %s)", ic)
+			}
+			ids = append(ids, ircID)
+		}
+		lines = append(lines, fmt.Sprintf("%s: IRC %s", line, strings.Join(ids, ", ")))
+	}
+	if discordIDs, ok := b.idIRCDiscord[id]; ok {
+		ids := make([]string, 0, len(discordIDs))
+		for _, discordID := range discordIDs {
+			if dc, ok := b.idDiscordChannel[discordID]; ok {
+				discordID += fmt.Sprintf(" (channel %s)", dc)
+			}
+			ids = append(ids, discordID)
+		}
+		lines = append(lines, fmt.Sprintf("IRC message %s: Discord %s", id, strings.Join(ids, ", ")))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("no message %s in the ID maps", id))
+	}
+	return lines
+}
@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestAdminIDs(t *testing.T) {
+	h := newHarness(t, "admins: [\"root\"]\n")
+	h.b.ircCaps["batch"] = true
+	h.b.ircCaps["draft/chathistory"] = true
+	h.b.correlate("i1", "900")
+	h.b.correlateChannel("900", testChannel)
+	h.b.correlateIRCChannel("i1", "#test")
+
+	notices := func(line string) []string {
+		h.irc.take()
+		h.fromIRC(line)
+		var texts []string
+		for _, m := range h.irc.take() {
+			if m.Command == "NOTICE" && m.Params[0] == "carol" {
+				texts = append(texts, m.Params[1])
+			}
+		}
+		return texts
+	}
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{":carol!c@host PRIVMSG bridge :!ids", "permission denied"},
+		{"@account=mallory :carol!c@host PRIVMSG bridge :!ids purge", "permission denied"},
+		{"@account=root :carol!c@host PRIVMSG bridge :!ids", "1 IRC messages, 1 Discord messages, 1 Discord channels of messages, 1 IRC channels of messages"},
+		{"@account=root :carol!c@host PRIVMSG bridge :!ids 900", "Discord message 900 (channel " + testChannel + "): IRC i1 (channel #test)"},
+		{"@account=root :carol!c@host PRIVMSG bridge :!ids i1", "IRC message i1: Discord 900 (channel " + testChannel + ")"},
+		{"@account=root :carol!c@host PRIVMSG bridge :!ids 901", "no message 901 in the ID maps"},
+		{"@account=root :carol!c@host PRIVMSG bridge :!ids purge", "purged the ID maps (1 IRC messages, 1 Discord messages, 1 Discord channels of messages, 1 IRC channels of messages)"},
+	} {
+		if got := notices(tc.line); len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%q: got notices %q, want %q", tc.line, got, tc.want)
+		}
+	}
+	if ids := h.b.ircIDs("900"); len(ids) != 0 {
+		t.Errorf("got irc ids %v after purge, want none", ids)
+	}
+
+	h.irc.take()
+	h.fromIRC("@account=root :carol!c@host PRIVMSG bridge :!ids rebuild")
+	sent := h.irc.take()
+	if len(sent) != 2 || sent[0].String() != "CHATHISTORY LATEST #test * 100" || sent[1].Command != "NOTICE" {
+		t.Fatalf("got irc messages %v, want a history lookup and a notice", sent)
+	}
+	h.fromIRC(":irc.example.com BATCH +h chathistory #test")
+	h.fromIRC("@batch=h;msgid=h1;+discord=899 :bridge!b@host PRIVMSG #test :<alice> hello")
+	h.fromIRC("@batch=h;msgid=h2;+discord=800 :mallory!m@host PRIVMSG #test :spoofed")
+	h.fromIRC(":irc.example.com BATCH -h")
+	if ids := h.b.discordChannelIDs("h1", testChannel); len(ids) != 1 || ids[0] != "899" {
+		t.Errorf("got discord ids %v after rebuild, want [899]", ids)
+	}
+	if ids := h.b.ircIDs("800"); len(ids) != 0 {
+		t.Errorf("correlated messages of other users: %v", ids)
+	}
+}
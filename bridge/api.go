@@ -0,0 +1,210 @@
+package bridge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type apiMessage struct {
+	Text string `json:"text"`
+}
+
+// apiDelivery is the status of the delivery of a message to each side, "sent" or "failed",
+// returned when it failed on any side.
+type apiDelivery struct {
+	IRC     string            `json:"irc,omitempty"`
+	Discord map[string]string `json:"discord,omitempty"` // by Discord channel ID
+}
+
+func apiStatus(sent bool) string {
+	if sent {
+		return "sent"
+	}
+	return "failed"
+}
+
+func (b *Bridge) apiServe(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels/", b.apiChannelMessage)
+	mux.HandleFunc("/webhooks/", b.apiWebhook)
+	if b.cfg.API.Metrics {
+		mux.HandleFunc("/metrics", b.metricsServe)
+	}
+	srv := &http.Server{
+		Addr:    b.cfg.API.Listen,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logErr.Printf("serving api: %v", err)
+	}
+}
+
+func (b *Bridge) apiAuthorized(r *http.Request) bool {
+	if b.cfg.API.Token == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(b.cfg.API.Token)) == 1
+}
+
+// apiChannelMessage handles POST /channels/{irc}/message
+func (b *Bridge) apiChannelMessage(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/channels/")
+	ic := strings.TrimSuffix(path, "/message")
+	if ic == path || ic == "" || strings.Contains(ic, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !b.apiAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	chs := b.ircChannels(ic)
+	if len(chs) == 0 {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	var m apiMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&m); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if m.Text == "" {
+		http.Error(w, "empty text", http.StatusBadRequest)
+		return
+	}
+
+	// the message is sent to each side as the mappings relay messages to it
+	var d apiDelivery
+	sent, failed := 0, 0
+	count := func(ok bool) string {
+		if ok {
+			sent++
+		} else {
+			failed++
+		}
+		return apiStatus(ok)
+	}
+	for _, ch := range chs {
+		if ch.relayToIRC() {
+			d.IRC = count(b.ircWrite(&irc.Message{
+				Command: "PRIVMSG",
+				Params:  []string{ic, replacerNewline.Replace(m.Text)},
+			}))
+			break
+		}
+	}
+	for _, ch := range chs {
+		if !ch.relayToDiscord() {
+			continue
+		}
+		if d.Discord == nil {
+			d.Discord = make(map[string]string)
+		}
+		d.Discord[ch.Discord] = count(b.discordSend("", ch.Discord, m.Text, "") != nil)
+	}
+	switch {
+	case sent+failed == 0:
+		http.Error(w, "channel not relayed in any direction", http.StatusConflict)
+	case failed == 0:
+		w.WriteHeader(http.StatusNoContent)
+	case sent == 0:
+		apiWriteDelivery(w, http.StatusBadGateway, &d)
+	default:
+		apiWriteDelivery(w, http.StatusMultiStatus, &d)
+	}
+}
+
+// apiWriteDelivery responds with the delivery status d of a message that failed to be delivered to some side.
+func apiWriteDelivery(w http.ResponseWriter, code int, d *apiDelivery) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(d)
+}
+
+// apiWebhook handles POST /webhooks/{irc}/{token}, accepting Discord execute-webhook bodies
+// so that tools posting to Discord webhooks can post to IRC through the bridge as well.
+func (b *Bridge) apiWebhook(w http.ResponseWriter, r *http.Request) {
+	ic, token, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/")
+	if !ok || ic == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// the token is part of the URL, like for Discord webhooks
+	if b.cfg.API.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(b.cfg.API.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	chs := b.ircChannels(ic)
+	if len(chs) == 0 {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if !relayToIRC(chs) {
+		http.Error(w, "channel not relayed to irc", http.StatusConflict)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, 1024*1024)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// messages with files carry their JSON in a form field
+		r.Body = body.(io.ReadCloser)
+		body = strings.NewReader(r.FormValue("payload_json"))
+	}
+	var m discordgo.WebhookParams
+	if err := json.NewDecoder(body).Decode(&m); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	guildID := ""
+	if c, err := b.discord.state().Channel(chs[0].Discord); err == nil {
+		guildID = c.GuildID
+	}
+	var lines []string
+	if m.Content != "" {
+		lines = append(lines, replacerNewline.Replace(b.discordIRCFormat(b.discord, guildID, m.Content)))
+	}
+	for _, embed := range m.Embeds {
+		if line := b.discordEmbed(b.discord, guildID, embed); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		http.Error(w, "empty message", http.StatusBadRequest)
+		return
+	}
+	for _, line := range lines {
+		if m.Username != "" {
+			line = fmt.Sprintf("%c<%s>%c %s", fBold, b.antiPing(replacerNewline.Replace(m.Username)), fReset, line)
+		}
+		if !b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, line},
+		}) {
+			apiWriteDelivery(w, http.StatusBadGateway, &apiDelivery{IRC: apiStatus(false)})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
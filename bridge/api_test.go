@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIChannelMessage(t *testing.T) {
+	h := newHarness(t, "api:\n  token: \"secret\"\n")
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/channels/%23test/message", strings.NewReader(`{"text": "build passed"}`))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.b.apiChannelMessage(w, r)
+		return w
+	}
+
+	if w := post(); w.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if sent, dsent := h.irc.take(), h.discord.take(); len(sent) != 1 || len(dsent) != 1 {
+		t.Errorf("got irc messages %v and discord messages %v, want one each", sent, dsent)
+	}
+
+	h.b.ircClientLock.Lock()
+	h.b.ircClient = nil
+	h.b.ircClientLock.Unlock()
+	w := post()
+	if want := `{"irc":"failed","discord":{"100":"sent"}}`; w.Code != http.StatusMultiStatus || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("got status %d and body %q with irc down, want %d and %q", w.Code, w.Body.String(), http.StatusMultiStatus, want)
+	}
+	h.discord.take()
+
+	h.b.cfg.Channels[testChannel][0].Direction = directionIRCToDiscord
+	if w := post(); w.Code != http.StatusNoContent {
+		t.Errorf("got status %d for a channel only relayed to discord, want %d", w.Code, http.StatusNoContent)
+	}
+	if dsent := h.discord.take(); len(dsent) != 1 {
+		t.Errorf("got discord messages %v, want 1", dsent)
+	}
+}
+
+func TestAPIWebhook(t *testing.T) {
+	h := newHarness(t, "api:\n  token: \"secret\"\n")
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/%23test/secret", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.b.apiWebhook(w, r)
+		return w
+	}
+
+	w := post(`{"username": "ci\r\nQUIT :bye", "content": "build passed", "embeds": [{"title": "run", "url": "https://example.com/\r\nQUIT"}]}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	sent := h.irc.take()
+	if len(sent) != 2 {
+		t.Fatalf("got irc messages %v, want 2", sent)
+	}
+	for _, m := range sent {
+		if strings.ContainsAny(m.Params[1], "\r\n") {
+			t.Errorf("got irc line %q with a newline", m.Params[1])
+		}
+	}
+
+	h.b.cfg.Channels[testChannel][0].Direction = directionIRCToDiscord
+	if w := post(`{"content": "build passed"}`); w.Code != http.StatusConflict {
+		t.Errorf("got status %d for a channel only relayed to discord, want %d", w.Code, http.StatusConflict)
+	}
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v for a channel only relayed to discord, want none", sent)
+	}
+}
@@ -0,0 +1,163 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+	"time"
+)
+
+// artDelay is how long heavily colored lines are buffered, waiting for the next lines of the same art.
+var artDelay = 3 * time.Second
+
+// artMinColors is the count of color codes from which a line is considered art.
+const artMinColors = 4
+
+// codeMaxLength is the maximum length of a code block, below the 2000 characters limit of Discord messages.
+const codeMaxLength = 1900
+
+// ansiColors are the ANSI color offsets supported by Discord closest to the 16 standard IRC colors.
+var ansiColors = [16]int{7, 0, 4, 2, 1, 1, 5, 3, 3, 2, 6, 6, 4, 5, 0, 7}
+
+// artColorful returns whether line has enough color codes to be relayed as art.
+func artColorful(line string) bool {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == fColor && isDigit(line, i+1) || line[i] == fColorHex && isHexColor(line, i+1) {
+			n++
+		}
+	}
+	return n >= artMinColors
+}
+
+// artRelay buffers the colored line of nick, relaying consecutive art lines to Discord channel dc
+// as a single ANSI code block.
+func (b *Bridge) artRelay(dc string, nick string, name string, msgID string, line string, posted func(dm *discordgo.Message, id string, content string)) {
+	header := discordFormat(fmt.Sprintf("%c<%s>%c", fBold, name, fReset))
+	b.coalesceDelay("discord "+dc, "art "+strings.ToLower(nick), msgID, line, artDelay, func(ids []string, lines []string) {
+		ansi := make([]string, len(lines))
+		for i, line := range lines {
+			ansi[i] = ansiFormat(line)
+		}
+		for i, dm := range b.discordPostCode(dc, header, "ansi", ids, ansi) {
+			posted(dm, ids[i], lines[i])
+		}
+	})
+}
+
+// discordPostCode posts lines to Discord channel dc in a code block of language lang after header,
+// split into several messages if needed, each posted as relayed from the ID of its first line.
+// It returns the message in which each line was posted.
+func (b *Bridge) discordPostCode(dc string, header string, lang string, ids []string, lines []string) []*discordgo.Message {
+	dms := make([]*discordgo.Message, len(lines))
+	for start := 0; start < len(lines); {
+		end := start + 1
+		length := len(lines[start])
+		for end < len(lines) && length+1+len(lines[end]) <= codeMaxLength {
+			length += 1 + len(lines[end])
+			end++
+		}
+		content := fmt.Sprintf("%s\n```%s\n%s\n```", header, lang, strings.Join(lines[start:end], "\n"))
+		// mentions do not ping in code blocks
+		dm := b.discordPost(ids[start], dc, content, "", true)
+		for i := start; i < end; i++ {
+			if dm != nil && i > start && ids[i] != "" {
+				b.correlate(ids[i], dm.ID)
+			}
+			dms[i] = dm
+		}
+		start = end
+	}
+	return dms
+}
+
+// ansiStyle is the state of the ANSI formatting of a line.
+type ansiStyle struct {
+	bold       bool
+	underline  bool
+	foreground int // IRC color, or -1 for the default color
+	background int
+}
+
+// sgr returns the ANSI escape sequence setting style s.
+func (s ansiStyle) sgr() string {
+	var sb strings.Builder
+	sb.WriteString("\x1b[0")
+	if s.bold {
+		sb.WriteString(";1")
+	}
+	if s.underline {
+		sb.WriteString(";4")
+	}
+	if s.foreground >= 0 && s.foreground < len(ansiColors) {
+		fmt.Fprintf(&sb, ";%d", 30+ansiColors[s.foreground])
+	}
+	if s.background >= 0 && s.background < len(ansiColors) {
+		fmt.Fprintf(&sb, ";%d", 40+ansiColors[s.background])
+	}
+	sb.WriteByte('m')
+	return sb.String()
+}
+
+// ansiFormat converts the IRC formatting of line to ANSI escape sequences, as rendered by Discord
+// in ansi code blocks. Hex colors are downgraded to the nearest standard colors, and styles
+// unsupported by Discord are dropped.
+func ansiFormat(line string) string {
+	line = downgradeColors(line)
+	var sb strings.Builder
+	sb.Grow(len(line) * 2)
+	style := ansiStyle{foreground: -1, background: -1}
+	reset := style.sgr()
+	prev := reset
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch c {
+		case fBold:
+			style.bold = !style.bold
+			continue
+		case fUnderline:
+			style.underline = !style.underline
+			continue
+		case fReset:
+			style = ansiStyle{foreground: -1, background: -1}
+			continue
+		case fItalics, fStrikethrough, fMonospace, fReverse:
+			continue
+		case fColor:
+			if !isDigit(line, i+1) {
+				style.foreground = -1
+				style.background = -1
+				continue
+			}
+			i++
+			style.foreground = int(line[i] - '0')
+			if isDigit(line, i+1) {
+				i++
+				style.foreground = style.foreground*10 + int(line[i]-'0')
+			}
+			if isDigit(line, i+2) && line[i+1] == ',' {
+				i += 2
+				style.background = int(line[i] - '0')
+				if isDigit(line, i+1) {
+					i++
+					style.background = style.background*10 + int(line[i]-'0')
+				}
+			}
+			continue
+		}
+		if sgr := style.sgr(); sgr != prev {
+			sb.WriteString(sgr)
+			prev = sgr
+		}
+		if c == '`' && strings.HasPrefix(line[i:], "```") {
+			// do not end the code block
+			sb.WriteString("`\u200b")
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if prev != reset {
+		sb.WriteString(reset)
+	}
+	return sb.String()
+}
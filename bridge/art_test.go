@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestANSIFormat(t *testing.T) {
+	cases := map[string]string{
+		"plain":                         "plain",
+		"\x0304red\x03 default":         "\x1b[0;31mred\x1b[0m default",
+		"\x0300,01white on black\x0f":   "\x1b[0;37;40mwhite on black\x1b[0m",
+		"\x02bold \x1funder\x0f plain":  "\x1b[0;1mbold \x1b[0;1;4munder\x1b[0m plain",
+		"\x04FFFF00yellow \x0399extend": "\x1b[0;33myellow \x1b[0mextend",
+		"\x1ditalics``` dropped":        "italics`\u200b`` dropped",
+	}
+	for in, want := range cases {
+		if got := ansiFormat(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRelayArt(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].Art = true
+	art := "\x0301,01  \x0304,04  \x0308,08  \x0309,09  \x03"
+	h.fromIRC("@msgid=a1 :weather!w@host PRIVMSG #test :" + art)
+	h.fromIRC("@msgid=a2 :weather!w@host PRIVMSG #test :" + art)
+	h.fromIRC("@msgid=a3 :weather!w@host PRIVMSG #test :\x0304only\x03 red")
+	h.fromIRC("@msgid=a4 :weather!w@host PRIVMSG #test :" + art)
+
+	sent := h.discord.take()
+	line := ansiFormat(art)
+	want := []string{
+		"\u200b**<weather>**\n```ansi\n" + line + "\n" + line + "\n```",
+		"\u200b**<weather>**\u200b only red",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d discord messages, want %d", len(sent), len(want))
+	}
+	for i, m := range sent {
+		if m.Content != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, m.Content, want[i])
+		}
+	}
+	if ids := h.b.discordIDs("a2"); len(ids) != 1 || ids[0] != sent[0].ID {
+		t.Errorf("got discord IDs %v for a2, want [%v]", ids, sent[0].ID)
+	}
+	h.b.coalesceFlush("discord " + testChannel)
+	if sent := h.discord.take(); len(sent) != 1 || !strings.Contains(sent[0].Content, "```ansi") {
+		t.Errorf("got discord messages %v, want the last art line", sent)
+	}
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+type AuditConfig struct {
+	Channel string   `yaml:"channel"` // IRC channel receiving Discord moderation events, empty to disable
+	Events  []string `yaml:"events"`  // relayed events among auditEvents, defaults to all
+}
+
+// auditEvents maps the audit log actions relayed to IRC to their event name, also used as message key.
+var auditEvents = map[discordgo.AuditLogAction]string{
+	discordgo.AuditLogActionMemberBanAdd:     "ban",
+	discordgo.AuditLogActionMemberBanRemove:  "unban",
+	discordgo.AuditLogActionMemberKick:       "kick",
+	discordgo.AuditLogActionMemberRoleUpdate: "roles",
+	discordgo.AuditLogActionChannelCreate:    "channelCreate",
+	discordgo.AuditLogActionChannelDelete:    "channelDelete",
+	discordgo.AuditLogActionRoleCreate:       "roleCreate",
+	discordgo.AuditLogActionRoleDelete:       "roleDelete",
+}
+
+func (b *Bridge) discordAudit(s discordSession, m *discordgo.GuildAuditLogEntryCreate) {
+	if b.cfg.Audit.Channel == "" || m.ActionType == nil {
+		return
+	}
+	event, ok := auditEvents[*m.ActionType]
+	if !ok {
+		return
+	}
+	if len(b.cfg.Audit.Events) > 0 {
+		enabled := false
+		for _, e := range b.cfg.Audit.Events {
+			if e == event {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			return
+		}
+	}
+
+	actor := b.discordUserName(s, m.GuildID, m.UserID)
+	var text string
+	switch *m.ActionType {
+	case discordgo.AuditLogActionMemberBanAdd, discordgo.AuditLogActionMemberBanRemove, discordgo.AuditLogActionMemberKick:
+		text = b.localize("audit."+event, actor, b.discordUserName(s, m.GuildID, m.TargetID))
+	case discordgo.AuditLogActionMemberRoleUpdate:
+		var roles []string
+		for _, change := range m.Changes {
+			if change.Key == nil {
+				continue
+			}
+			sign := ""
+			switch *change.Key {
+			case discordgo.AuditLogChangeKeyRoleAdd:
+				sign = "+"
+			case discordgo.AuditLogChangeKeyRoleRemove:
+				sign = "-"
+			default:
+				continue
+			}
+			values, _ := change.NewValue.([]interface{})
+			for _, v := range values {
+				if role, ok := v.(map[string]interface{}); ok {
+					roles = append(roles, fmt.Sprintf("%s%v", sign, role["name"]))
+				}
+			}
+		}
+		text = b.localize("audit."+event, actor, b.discordUserName(s, m.GuildID, m.TargetID), strings.Join(roles, " "))
+	default:
+		// created and deleted channels and roles are named in the changes
+		name := m.TargetID
+		for _, change := range m.Changes {
+			if change.Key == nil || *change.Key != discordgo.AuditLogChangeKeyName {
+				continue
+			}
+			if v, ok := change.NewValue.(string); ok {
+				name = v
+			} else if v, ok := change.OldValue.(string); ok {
+				name = v
+			}
+		}
+		text = b.localize("audit."+event, actor, name)
+	}
+	if m.Reason != "" {
+		text = fmt.Sprintf("%s (%s)", text, m.Reason)
+	}
+	b.ircWrite(&irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{b.cfg.Audit.Channel, fmt.Sprintf("%c%s%c", fItalics, replacerNewline.Replace(text), fReset)},
+	})
+}
+
+// discordUserName returns the anti-pinged display name of a Discord user in a guild, or its ID if unknown.
+func (b *Bridge) discordUserName(s discordSession, guildID string, userID string) string {
+	member, err := s.state().Member(guildID, userID)
+	if err != nil {
+		member, err = s.GuildMember(guildID, userID)
+	}
+	if err == nil && member.User != nil {
+		return b.antiPing(b.displayName(member, member.User))
+	}
+	if u, err := s.User(userID); err == nil {
+		return b.antiPing(b.displayName(nil, u))
+	}
+	return userID
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"path"
+)
+
+type AutoMapConfig struct {
+	Enabled bool     `yaml:"-"`       // set by autoMap: true, or by the settings
+	Guilds  []string `yaml:"guilds"`  // IDs of the guilds whose channels are mapped, empty for all the guilds of the bot
+	Prefix  string   `yaml:"prefix"`  // prepended to channel names to get IRC channel names, defaults to #
+	Include []string `yaml:"include"` // globs of the names of the channels to map (e.g. "dev-*"), empty for all
+	Exclude []string `yaml:"exclude"` // globs of the names of the channels not to map
+	Private bool     `yaml:"private"` // also map the channels hidden from @everyone
+}
+
+// UnmarshalYAML accepts either a boolean or the auto-mapping settings.
+func (c *AutoMapConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Enabled); err == nil {
+		return nil
+	}
+	type autoMap AutoMapConfig
+	if err := unmarshal((*autoMap)(c)); err != nil {
+		return err
+	}
+	c.Enabled = true
+	return nil
+}
+
+// validate checks the globs of the settings, and fills in defaults.
+func (c *AutoMapConfig) validate() error {
+	if c.Prefix == "" {
+		c.Prefix = "#"
+	}
+	for _, globs := range [][]string{c.Include, c.Exclude} {
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("invalid autoMap glob %q: %v", glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns whether the Discord channel named name is auto-mapped.
+func (c *AutoMapConfig) match(name string) bool {
+	for _, glob := range c.Exclude {
+		if ok, _ := path.Match(glob, name); ok {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, glob := range c.Include {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// autoMapping returns the auto-mapping of Discord channel c, or nil.
+func (b *Bridge) autoMapping(s discordSession, c *discordgo.Channel) *Channel {
+	if c.Type != discordgo.ChannelTypeGuildText && c.Type != discordgo.ChannelTypeGuildNews {
+		return nil
+	}
+	if !b.cfg.AutoMap.match(c.Name) || (!b.cfg.AutoMap.Private && !discordPublic(s, c)) {
+		return nil
+	}
+	if len(b.cfg.AutoMap.Guilds) > 0 && !containsFold(b.cfg.AutoMap.Guilds, c.GuildID) || !b.gatewayShard(c.GuildID) {
+		return nil
+	}
+	return &Channel{IRC: b.cfg.AutoMap.Prefix + c.Name}
+}
+
+// autoMap maps the text channels of the auto-mapped guilds not mapped explicitly to the IRC channels
+// of the same name. It runs at startup, before any channel is bridged; channels created later are
+// mapped by autoMapAdd.
+func (b *Bridge) autoMap(s discordSession) error {
+	guilds, err := discordGuilds(s, b.cfg.AutoMap.Guilds)
+	if err != nil {
+		return err
+	}
+	for _, guildID := range guilds {
+		if !b.gatewayShard(guildID) {
+			continue
+		}
+		channels, err := s.GuildChannels(guildID)
+		if err != nil {
+			return fmt.Errorf("listing channels of discord guild %v: %v", guildID, err)
+		}
+		for _, c := range channels {
+			if _, ok := b.cfg.Channels[c.ID]; ok {
+				continue
+			}
+			ch := b.autoMapping(s, c)
+			if ch == nil {
+				continue
+			}
+			if err := ch.validate(c.ID); err != nil {
+				return err
+			}
+			if b.cfg.Channels == nil {
+				b.cfg.Channels = make(map[string]Channels)
+			}
+			b.cfg.Channels[c.ID] = Channels{ch}
+		}
+	}
+	return nil
+}
+
+// autoMapAdd maps Discord channel c, created while the bridge runs, if it is auto-mapped.
+func (b *Bridge) autoMapAdd(s discordSession, c *discordgo.Channel) {
+	if !b.cfg.AutoMap.Enabled {
+		return
+	}
+	if _, ok := b.mappings()[c.ID]; ok {
+		return
+	}
+	ch := b.autoMapping(s, c)
+	if ch == nil {
+		return
+	}
+	if err := ch.validate(c.ID); err != nil {
+		logErr.Printf("not mapping discord channel %v (#%v): %v", c.ID, c.Name, err)
+		return
+	}
+	b.mappingsUpdate(func(chs map[string]Channels) {
+		chs[c.ID] = Channels{ch}
+	})
+	logErr.Printf("mapped discord channel %v (#%v) to %v", c.ID, c.Name, ch.IRC)
+	b.ircWrite(b.ircJoin(ch.IRC))
+}
+
+// discordGuilds returns guilds, or if empty, the IDs of all the guilds of the bot.
+func discordGuilds(s discordSession, guilds []string) ([]string, error) {
+	if len(guilds) > 0 {
+		return guilds, nil
+	}
+	after := ""
+	for {
+		page, err := s.UserGuilds(200, "", after, false)
+		if err != nil {
+			return nil, fmt.Errorf("listing discord guilds: %v", err)
+		}
+		for _, g := range page {
+			guilds = append(guilds, g.ID)
+		}
+		if len(page) < 200 {
+			return guilds, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestAutoMap(t *testing.T) {
+	h := newHarness(t, "autoMap:\n  exclude: [\"mod-*\"]\n")
+	if err := h.b.cfg.AutoMap.validate(); err != nil {
+		t.Fatalf("validating autoMap: %v", err)
+	}
+	for _, c := range []*discordgo.Channel{
+		{ID: "101", Name: "general", Type: discordgo.ChannelTypeGuildText},
+		{ID: "102", Name: "mod-chat", Type: discordgo.ChannelTypeGuildText},
+		{ID: "103", Name: "voice", Type: discordgo.ChannelTypeGuildVoice},
+		{ID: "104", Name: "staff", Type: discordgo.ChannelTypeGuildText, PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			{ID: testGuild, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+		}},
+	} {
+		c.GuildID = testGuild
+		if err := h.discord.st.ChannelAdd(c); err != nil {
+			t.Fatalf("adding channel: %v", err)
+		}
+	}
+	if err := h.b.autoMap(h.discord); err != nil {
+		t.Fatalf("auto-mapping: %v", err)
+	}
+	want := map[string]string{
+		testChannel: "#test",
+		"101":       "#general",
+	}
+	if len(h.b.cfg.Channels) != len(want) {
+		t.Errorf("got %d mappings, want %d", len(h.b.cfg.Channels), len(want))
+	}
+	for dc, ic := range want {
+		if chs := h.b.cfg.Channels[dc]; len(chs) != 1 || chs[0].IRC != ic || chs[0].Discord != dc {
+			t.Errorf("channel %v: got mappings %+v, want %v", dc, chs, ic)
+		}
+	}
+
+	random := &discordgo.Channel{ID: "105", GuildID: testGuild, Name: "random", Type: discordgo.ChannelTypeGuildText}
+	h.b.discordChannelCreate(h.discord, &discordgo.ChannelCreate{Channel: random})
+	if sent := h.irc.take(); len(sent) != 1 || sent[0].String() != "JOIN #random" {
+		t.Errorf("got irc messages %v, want a join of the created channel", sent)
+	}
+	if chs := h.b.mappings()["105"]; len(chs) != 1 || chs[0].IRC != "#random" {
+		t.Errorf("got mappings %+v for the created channel, want #random", chs)
+	}
+}
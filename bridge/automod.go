@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"strings"
+	"time"
+)
+
+type AutoModConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Channel string `yaml:"channel"` // IRC channel notified, joined by the bridge, defaults to the channels the Discord channel of the message is relayed to
+}
+
+func (b *Bridge) discordAutoMod(s discordSession, m *discordgo.AutoModerationActionExecution) {
+	if !b.cfg.AutoMod.Enabled {
+		return
+	}
+	var key string
+	switch m.Action.Type {
+	case discordgo.AutoModerationRuleActionBlockMessage:
+		key = "autoModBlocked"
+	case discordgo.AutoModerationRuleActionSendAlertMessage:
+		key = "autoModFlagged"
+	default:
+		return
+	}
+	var ics []string
+	if b.cfg.AutoMod.Channel != "" {
+		ics = append(ics, b.cfg.AutoMod.Channel)
+	} else if !b.discordChannelDeleted(m.ChannelID) {
+		// the notice quotes the message, so only the IRC channels it would be relayed to are notified
+		for _, ch := range b.mappings()[m.ChannelID] {
+			if ch.relayToIRC() {
+				ics = append(ics, ch.IRC)
+			}
+		}
+	}
+	if len(ics) == 0 {
+		return
+	}
+
+	b.autoModLock.Lock()
+	last := strings.Join([]string{m.RuleID, m.UserID, m.ChannelID, m.Content}, "\x00")
+	duplicate := last == b.autoModLast && time.Since(b.autoModLastTime) < 10*time.Second
+	b.autoModLast = last
+	b.autoModLastTime = time.Now()
+	b.autoModLock.Unlock()
+	if duplicate {
+		return
+	}
+
+	user := b.discordUserName(s, m.GuildID, m.UserID)
+	channel := m.ChannelID
+	if c, err := s.state().Channel(m.ChannelID); err == nil {
+		channel = c.Name
+	}
+	rule := m.RuleID
+	if r, err := s.AutoModerationRule(m.GuildID, m.RuleID); err == nil {
+		rule = r.Name
+	}
+
+	line := fmt.Sprintf("%c🛡 %s%c", fItalics, b.localize(key, user, channel, rule), fReset)
+	if excerpt := autoModExcerpt(m.Content, m.MatchedContent); excerpt != "" {
+		line += ": " + excerpt
+	}
+	for _, ic := range ics {
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, line},
+		})
+	}
+}
+
+// autoModExcerpt returns a short excerpt of a message caught by AutoMod, masking the content it matched
+// and stripping formatting characters.
+func autoModExcerpt(content string, matched string) string {
+	if matched != "" {
+		content = strings.ReplaceAll(content, matched, "***")
+	}
+	content = strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, replacerNewline.Replace(content))
+	return truncate(strings.TrimSpace(content), 100)
+}
@@ -0,0 +1,3823 @@
+// Package bridge implements a Discord to IRC bridge.
+//
+// Programs embedding the bridge load or build a Config, create a Bridge with New,
+// optionally add channel mappings and event hooks, then call Run.
+package bridge
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	formatting "github.com/delthas/discord-formatting"
+	"gopkg.in/irc.v3"
+	"gopkg.in/yaml.v2"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	fBold          byte = '\x02'
+	fItalics       byte = '\x1D'
+	fUnderline     byte = '\x1F'
+	fStrikethrough byte = '\x1E'
+	fMonospace     byte = '\x11'
+	fColor         byte = '\x03'
+	fColorHex      byte = '\x04'
+	fReverse       byte = '\x16'
+	fReset         byte = '\x0F'
+)
+
+type Config struct {
+	DiscordToken   string              `yaml:"discordToken"`
+	TokenFile      string              `yaml:"discordTokenFile"`    // read the Discord token from this file instead
+	TokenCommand   string              `yaml:"discordTokenCommand"` // read the Discord token from the output of this shell command instead
+	Server         string              `yaml:"server"`
+	Servers        []string            `yaml:"servers"` // fallback servers, tried in order after server
+	Nick           string              `yaml:"nickname"`
+	Channels       map[string]Channels `yaml:"channels"` // Discord ID to IRC channels
+	Webhooks       []string            `yaml:"webhooks"` // URLs receiving bridge events
+	API            APIConfig           `yaml:"api"`
+	Timestamps     TimestampConfig     `yaml:"timestamps"`
+	MarkStatusMsg  bool                `yaml:"markStatusMsg"` // mark messages sent to channel ops only (e.g. to @#channel)
+	RejoinDelay    time.Duration       `yaml:"rejoinDelay"`   // delay before rejoining a channel after a kick or failed join
+	ChannelKeys    map[string]string   `yaml:"channelKeys"`   // IRC channel to its key, for the channels joined without being mapped
+	ChanServ       ChanServConfig      `yaml:"chanserv"`
+	AntiPing       string              `yaml:"antiPing"` // zwsp (default), suffix, swap or none
+	Colors         ColorConfig         `yaml:"colors"`
+	RolePrefixes   map[string]string   `yaml:"rolePrefixes"`   // Discord role ID or name to IRC status prefix, e.g. "@"
+	ReplyExcerpts  string              `yaml:"replyExcerpts"`  // quote the parent of Discord replies: never, unbridged (default) or always
+	NickReplies    bool                `yaml:"nickReplies"`    // relay IRC messages starting with "nick: " as replies to that Discord user
+	PlaybackMaxAge time.Duration       `yaml:"playbackMaxAge"` // relay messages played back by bouncers up to this age, defaults to none
+	EventChannels  map[string][]string `yaml:"eventChannels"`  // Discord guild ID to IRC channels announcing its scheduled events
+	Voice          VoiceConfig         `yaml:"voice"`          // announce the occupancy of voice channels on IRC
+	MaxLineLength  int                 `yaml:"maxLineLength"`  // in bytes, longer Discord messages are truncated with a link to them
+	MessageTags    string              `yaml:"messageTags"`    // tags identifying relayed Discord messages: id (default), url or both
+	NameOrder      []string            `yaml:"nameOrder"`      // precedence of Discord names: nick, global, username
+	Bind           string              `yaml:"bind"`           // local IP address of outgoing IRC connections
+	PreferIP       string              `yaml:"preferIP"`       // IP family to try first when connecting to IRC: ipv4 or ipv6
+	DialTimeout    time.Duration       `yaml:"dialTimeout"`    // timeout for connecting to IRC, defaults to 30s
+	KeepAlive      time.Duration       `yaml:"keepAlive"`      // TCP keepalive interval of IRC connections, negative to disable
+	StallTimeout   time.Duration       `yaml:"stallTimeout"`   // drop IRC connections not receiving any data for this long, defaults to never
+	STSPath        string              `yaml:"stsPath"`        // file persisting IRC STS policies, defaults to sts.json next to the config
+	Media          MediaConfig         `yaml:"media"`          // detection of media links, sent to Discord apart from the nick to be embedded
+	ShowAccounts   bool                `yaml:"showAccounts"`   // append the services account of IRC senders to their nick on Discord, when different
+	Flood          FloodConfig         `yaml:"flood"`          // per-user rate limit of relayed messages, in both directions
+	Coalesce       time.Duration       `yaml:"coalesce"`       // merge consecutive messages of a user sent within this delay, defaults to none
+	JoinDigest     time.Duration       `yaml:"joinDigest"`     // relay the IRC joins, parts and quits of this window as a single digest, defaults to none
+	Locale         string              `yaml:"locale"`         // language of the messages of the bridge: en (default) or fr
+	Messages       map[string]string   `yaml:"messages"`       // overrides of messages of the bridge, by key
+	AutoMod        AutoModConfig       `yaml:"autoMod"`        // notify IRC of messages blocked or flagged by Discord AutoMod
+	Audit          AuditConfig         `yaml:"audit"`          // relay Discord moderation events to an IRC channel
+	Health         HealthConfig        `yaml:"health"`         // notify outages of one side of the bridge on the other side
+	Sentry         SentryConfig        `yaml:"sentry"`         // report panics and repeated errors to Sentry
+	Gateway        GatewayConfig       `yaml:"gateway"`        // Discord gateway connection options
+	State          StateConfig         `yaml:"state"`          // Discord state cache options
+	ServerNotices  string              `yaml:"serverNotices"`  // Discord channel ID receiving IRC server notices and WALLOPS
+	Queue          string              `yaml:"queue"`          // directory persisting the messages being relayed, relayed again after a restart
+	CTCP           []string            `yaml:"ctcp"`           // CTCP verbs other than ACTION relayed to Discord, or "*" for all
+	SASL           SASLConfig          `yaml:"sasl"`           // authenticate to IRC services on connection
+	Oper           OperConfig          `yaml:"oper"`           // IRC operator credentials, e.g. to redact the messages of other users
+	Tracing        TracingConfig       `yaml:"tracing"`        // export OpenTelemetry traces of the relay pipeline
+	AutoMap        AutoMapConfig       `yaml:"autoMap"`        // bridge the Discord channels not mapped explicitly to the IRC channels of the same name
+	Categories     map[string]*Channel `yaml:"categories"`     // Discord category ID or name to the mapping of its channels, * in irc is replaced by the channel name
+	Emojis         string              `yaml:"emojis"`         // directory of images uploaded as application emojis, named after their files
+	Admins         []string            `yaml:"admins"`         // IRC services accounts allowed to send the admin commands of the bridge
+	Identities     []*Identity         `yaml:"identities"`     // links of Discord users to their IRC nicks and accounts
+	RequireAccount *bool               `yaml:"requireAccount"` // only let IRC users identified to services mention roles and delete Discord messages, defaults to true
+	Debug          bool                `yaml:"debug"`          // log raw IRC traffic
+}
+
+// ipNetworks maps preferIP values to dial networks.
+var ipNetworks = map[string]string{
+	"ipv4": "tcp4",
+	"ipv6": "tcp6",
+}
+
+const (
+	antiPingZWSP   = "zwsp"
+	antiPingSuffix = "suffix"
+	antiPingSwap   = "swap"
+	antiPingNone   = "none"
+)
+
+const (
+	messageTagsID   = "id"
+	messageTagsURL  = "url"
+	messageTagsBoth = "both"
+)
+
+const (
+	replyExcerptsNever     = "never"
+	replyExcerptsUnbridged = "unbridged"
+	replyExcerptsAlways    = "always"
+)
+
+const (
+	directionBoth         = "both"
+	directionDiscordToIRC = "discord-to-irc"
+	directionIRCToDiscord = "irc-to-discord"
+)
+
+const (
+	roleMentionsAllow     = "allow"
+	roleMentionsWhitelist = "whitelist"
+	roleMentionsText      = "text"
+)
+
+const (
+	botsRelay = "relay"
+	botsMark  = "mark"
+	botsSkip  = "skip"
+)
+
+type Channel struct {
+	Discord      string           `yaml:"-"`         // set from the channels key
+	IRC          string           `yaml:"irc"`       // may be followed by the channel key, e.g. "#private secretkey"
+	Key          string           `yaml:"key"`       // channel key used to join the IRC channel
+	Direction    string           `yaml:"direction"` // both (default), discord-to-irc or irc-to-discord
+	Crosspost    bool             `yaml:"crosspost"` // publish bridge messages in announcement channels
+	Label        string           `yaml:"label"`     // origin of messages when the IRC channel is bridged to several Discord channels, defaults to the guild name
+	Attachments  AttachmentConfig `yaml:"attachments"`
+	Art          bool             `yaml:"art"`          // relay heavily colored IRC lines (ASCII art, weather bots) as ANSI code blocks
+	RoleMentions string           `yaml:"roleMentions"` // role mentions from IRC: allow (default, mentionable roles), whitelist (mentionable roles of mentionRoles) or text
+	MentionRoles []string         `yaml:"mentionRoles"` // IDs or names of the roles IRC users can mention, with roleMentions: whitelist
+	Threads      bool             `yaml:"threads"`      // relay the messages of the public threads of the channel to IRC, labeled with the thread name
+	Bots         string           `yaml:"bots"`         // messages of Discord bots and webhooks: relay (default), mark (with a [bot] marker) or skip
+	AllowBots    []string         `yaml:"allowBots"`    // IDs of the bots and webhooks relayed unmarked, whatever bots and relayRoles are
+	RelayRoles   []string         `yaml:"relayRoles"`   // IDs or names of the roles, one of which is required for messages to be relayed to IRC, empty to relay all
+	RoleMarker   string           `yaml:"roleMarker"`   // marker of the messages of authors without relayRoles, e.g. "[unverified]", instead of dropping them
+	Plain        string           `yaml:"plain"`        // strip formatting and zero-width characters: both, discord-to-irc or irc-to-discord, empty for none
+	Delay        time.Duration    `yaml:"delay"`        // hold Discord messages this long before relaying them to IRC, to drop those deleted and apply the edits meanwhile
+	EditGrace    time.Duration    `yaml:"editGrace"`    // hold Discord messages up to this long for an edit, to relay only their edited version
+	Private      bool             `yaml:"private"`      // for categories: also map the channels hidden from @everyone
+
+	thread   string // name of the thread, for mappings of a parent channel used for its threads
+	category string // key of the category mapping the channel was mapped by, if any
+	deleted  bool   // whether the Discord channel was deleted
+}
+
+type AttachmentConfig struct {
+	MaxSize int      `yaml:"maxSize"` // in bytes, 0 for no limit
+	Types   []string `yaml:"types"`   // allowed content types, e.g. "image/*", empty to allow all
+	Note    bool     `yaml:"note"`    // replace omitted attachments with a note
+	Inline  bool     `yaml:"inline"`  // append attachments to the line of the message text when they fit
+}
+
+// UnmarshalYAML accepts either a plain IRC channel name or a full channel mapping.
+func (c *Channel) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.IRC); err == nil {
+		return nil
+	}
+	type channel Channel
+	return unmarshal((*channel)(c))
+}
+
+// Channels are the mappings of a Discord channel, one per IRC channel it is bridged to.
+type Channels []*Channel
+
+// UnmarshalYAML accepts either a single channel mapping or a list of them.
+func (c *Channels) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []*Channel
+	if err := unmarshal(&list); err == nil {
+		*c = list
+		return nil
+	}
+	var ch Channel
+	if err := unmarshal(&ch); err != nil {
+		return err
+	}
+	*c = Channels{&ch}
+	return nil
+}
+
+// channelAttachment returns the text relayed to the IRC channel of mapping c for attachment a,
+// or an empty string to skip it.
+func (b *Bridge) channelAttachment(c *Channel, a *discordgo.MessageAttachment) string {
+	omitted := ""
+	if c.Attachments.MaxSize > 0 && a.Size > c.Attachments.MaxSize {
+		omitted = "attachmentSize"
+	} else if len(c.Attachments.Types) > 0 {
+		contentType, _, _ := strings.Cut(a.ContentType, ";")
+		allowed := false
+		for _, t := range c.Attachments.Types {
+			if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			omitted = "attachment"
+		}
+	}
+	if omitted == "" {
+		if a.Waveform != "" {
+			// only voice messages have a waveform
+			d := int(a.DurationSecs + 0.5)
+			return fmt.Sprintf("%c[%s]%c <%s>", fItalics, b.localize("voiceMessage", d/60, d%60), fReset, a.URL)
+		}
+		return a.URL
+	}
+	if !c.Attachments.Note {
+		return ""
+	}
+	return fmt.Sprintf("%c[%s]%c", fItalics, b.localize(omitted, a.Filename), fReset)
+}
+
+// validate checks the settings of a channel bridged to the Discord channel dc, and fills in defaults.
+func (c *Channel) validate(dc string) error {
+	c.Discord = dc
+	if ic, key, ok := strings.Cut(c.IRC, " "); ok {
+		if c.Key != "" {
+			return fmt.Errorf("two keys for channel %v", dc)
+		}
+		c.IRC = ic
+		c.Key = strings.TrimSpace(key)
+	}
+	switch c.Direction {
+	case "":
+		c.Direction = directionBoth
+	case directionBoth, directionDiscordToIRC, directionIRCToDiscord:
+	default:
+		return fmt.Errorf("invalid direction for channel %v: %q", dc, c.Direction)
+	}
+	switch c.RoleMentions {
+	case "":
+		c.RoleMentions = roleMentionsAllow
+	case roleMentionsAllow, roleMentionsWhitelist, roleMentionsText:
+	default:
+		return fmt.Errorf("invalid roleMentions for channel %v: %q", dc, c.RoleMentions)
+	}
+	switch c.Bots {
+	case "":
+		c.Bots = botsRelay
+	case botsRelay, botsMark, botsSkip:
+	default:
+		return fmt.Errorf("invalid bots for channel %v: %q", dc, c.Bots)
+	}
+	switch c.Plain {
+	case "", directionBoth, directionDiscordToIRC, directionIRCToDiscord:
+	default:
+		return fmt.Errorf("invalid plain for channel %v: %q", dc, c.Plain)
+	}
+	if c.Delay < 0 {
+		return fmt.Errorf("invalid delay for channel %v: %v", dc, c.Delay)
+	}
+	if c.EditGrace < 0 {
+		return fmt.Errorf("invalid editGrace for channel %v: %v", dc, c.EditGrace)
+	}
+	return nil
+}
+
+// roleMention returns whether IRC users can mention role r in the Discord channel of the mapping.
+func (c *Channel) roleMention(r *discordgo.Role) bool {
+	switch c.RoleMentions {
+	case roleMentionsText:
+		return false
+	case roleMentionsWhitelist:
+		for _, name := range c.MentionRoles {
+			if name == r.ID || strings.EqualFold(name, r.Name) {
+				return r.Mentionable
+			}
+		}
+		return false
+	}
+	return r.Mentionable
+}
+
+// roleMentionable returns whether IRC users can mention role r in Discord channel dc,
+// which requires all the mappings of the channel to allow it.
+func (b *Bridge) roleMentionable(dc string, r *discordgo.Role) bool {
+	for _, ch := range b.mappings()[dc] {
+		if !ch.roleMention(r) {
+			return false
+		}
+	}
+	return r.Mentionable
+}
+
+func (c *Channel) relayToIRC() bool {
+	return c.Direction != directionIRCToDiscord
+}
+
+// bots returns the policy of the mapping for the Discord message m: relay, mark, or skip for messages of bots
+// and webhooks not allowed explicitly.
+func (c *Channel) bots(m *discordgo.Message) string {
+	if m.Author == nil || !m.Author.Bot && m.WebhookID == "" {
+		return botsRelay
+	}
+	if c.allowedBot(m) {
+		return botsRelay
+	}
+	return c.Bots
+}
+
+// allowedBot returns whether the Discord message m is from a bot or webhook of AllowBots.
+func (c *Channel) allowedBot(m *discordgo.Message) bool {
+	for _, id := range c.AllowBots {
+		if m.Author != nil && id == m.Author.ID || m.WebhookID != "" && id == m.WebhookID {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllowed returns whether the author of the Discord message m has one of the roles required by RelayRoles.
+func (c *Channel) roleAllowed(s discordSession, m *discordgo.Message) bool {
+	if len(c.RelayRoles) == 0 || c.allowedBot(m) {
+		return true
+	}
+	if m.Member == nil {
+		return false
+	}
+	for _, id := range m.Member.Roles {
+		for _, name := range c.RelayRoles {
+			if name == id {
+				return true
+			}
+			if role, err := s.state().Role(m.GuildID, id); err == nil && strings.EqualFold(name, role.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relayMessageToIRC returns whether the Discord message m is relayed to the IRC channel of the mapping.
+func (c *Channel) relayMessageToIRC(s discordSession, m *discordgo.Message) bool {
+	return c.relayToIRC() && c.bots(m) != botsSkip && (c.RoleMarker != "" || c.roleAllowed(s, m))
+}
+
+func (c *Channel) relayToDiscord() bool {
+	return c.Direction != directionDiscordToIRC && !c.deleted
+}
+
+func (c *Channel) plainToIRC() bool {
+	return c.Plain == directionBoth || c.Plain == directionDiscordToIRC
+}
+
+func (c *Channel) plainToDiscord() bool {
+	return c.Plain == directionBoth || c.Plain == directionIRCToDiscord
+}
+
+// ircPlain returns whether the messages sent to IRC channel ic are plain text.
+func (b *Bridge) ircPlain(ic string) bool {
+	for _, ch := range b.ircChannels(ic) {
+		if ch.plainToIRC() {
+			return true
+		}
+	}
+	return false
+}
+
+// discordPlain returns whether the messages sent to Discord channel dc are plain text.
+func (b *Bridge) discordPlain(dc string) bool {
+	for _, ch := range b.mappings()[dc] {
+		if ch.plainToDiscord() {
+			return true
+		}
+	}
+	return false
+}
+
+var replacerZeroWidth = strings.NewReplacer("\u200B", "", "\u200C", "", "\u200D", "", "\u2060", "", "\uFEFF", "")
+
+// plainText returns the text of an IRC message, without formatting codes nor zero-width characters,
+// for screen readers and minimal clients.
+func plainText(s string) string {
+	return replacerZeroWidth.Replace(stripFormatting(s))
+}
+
+// label returns the origin label of messages from the Discord channel of the mapping,
+// for IRC channels bridged to several Discord channels.
+func (c *Channel) label(s discordSession, guildID string) string {
+	if c.Label != "" {
+		return c.Label
+	}
+	if g, err := s.state().Guild(guildID); err == nil {
+		return g.Name
+	}
+	return c.Discord
+}
+
+// relayToDiscord returns whether any of the mappings relays to Discord.
+func relayToDiscord(chs []*Channel) bool {
+	for _, ch := range chs {
+		if ch.relayToDiscord() {
+			return true
+		}
+	}
+	return false
+}
+
+// relayToIRC returns whether any of the mappings relays to IRC.
+func relayToIRC(chs []*Channel) bool {
+	for _, ch := range chs {
+		if ch.relayToIRC() {
+			return true
+		}
+	}
+	return false
+}
+
+type TimestampConfig struct {
+	Timezone string            `yaml:"timezone"` // e.g. "UTC" or "Europe/Paris", defaults to the local timezone
+	Formats  map[string]string `yaml:"formats"`  // Discord timestamp style (t, T, d, D, f, F) to Go time layout
+}
+
+type ColorConfig struct {
+	Disabled bool              `yaml:"disabled"` // do not color relayed nicks
+	Extended bool              `yaml:"extended"` // pick nick colors from the extended 16-98 palette
+	Users    map[string]string `yaml:"users"`    // Discord user ID to color: "#RRGGBB" or a color number
+}
+
+// SASLConfig holds the credentials the bridge authenticates to services with during registration.
+type SASLConfig struct {
+	Mechanism string `yaml:"mechanism"` // PLAIN (default) or SCRAM-SHA-256
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+type OperConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// ChanServConfig holds the commands sent to ChanServ, with {channel} and {nick} replaced.
+// Empty commands are not sent.
+type ChanServConfig struct {
+	Nick   string `yaml:"nick"`   // defaults to ChanServ
+	Invite string `yaml:"invite"` // e.g. "INVITE {channel}", sent when failing to join a channel
+	Unban  string `yaml:"unban"`  // e.g. "UNBAN {channel}", sent when banned from a channel
+	Op     string `yaml:"op"`     // e.g. "OP {channel}", sent when lacking ops in a channel
+}
+
+type APIConfig struct {
+	Listen  string `yaml:"listen"`  // e.g. "localhost:8080", empty to disable
+	Token   string `yaml:"token"`   // required as "Authorization: Bearer <token>"
+	Metrics bool   `yaml:"metrics"` // serve Prometheus metrics at /metrics, without authentication
+}
+
+var logErr = log.New(os.Stderr, "err:", log.LstdFlags)
+
+var ircCapsRequested = []string{
+	"cap-notify",
+	"message-tags",
+	"echo-message",
+	"draft/message-redaction",
+	"server-time",
+	"account-tag",
+	"extended-join",
+	"account-notify",
+	"invite-notify",
+	"batch",
+	"draft/multiline",
+	"draft/read-marker",
+	"draft/chathistory",
+}
+
+// ircCapsDepend are the caps only usable along with another cap, to the cap they depend on.
+var ircCapsDepend = map[string]string{
+	"draft/multiline":   "batch",
+	"draft/chathistory": "batch",
+}
+
+// LoadConfig reads a bridge configuration from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var c Config
+	if err := yaml.NewDecoder(f).Decode(&c); err != nil {
+		return nil, fmt.Errorf("decoding config %v: %v", path, err)
+	}
+	if c.STSPath == "" {
+		c.STSPath = filepath.Join(filepath.Dir(path), "sts.json")
+	}
+	return &c, nil
+}
+
+// Bridge relays messages between Discord and IRC.
+type Bridge struct {
+	cfg     Config
+	debug   bool
+	running bool
+	discord discordSession
+
+	ircClientLock   sync.Mutex
+	ircClient       ircConn
+	ircReady        bool
+	ircCaps         map[string]bool // enabled caps, protected by ircClientLock
+	ircJoined       map[string]bool // casefolded mapped and notice channels the bridge is in, protected by ircClientLock
+	ircJoinAttempts map[string]int  // by casefolded channel
+	ircCasemapping  string          // CASEMAPPING of the server, only used from the IRC handler
+	ircStatusMsg    string
+	ircConnected    time.Time
+	ircRegistered   bool
+	ircServerIndex  int
+	ircAddr         string
+	ircSecure       bool
+	ircUpgrading    bool // disconnecting to upgrade to TLS per the server STS policy
+
+	timestampLocation *time.Location
+	timestampLayouts  map[string]string // Discord timestamp style to its layout
+	validColors       []int             // IRC colors assigned to nicks
+
+	// recentMessages maps Discord channel IDs to lowercase author names to their last relayed message ID
+	recentMessagesLock sync.Mutex
+	recentMessages     map[string]map[string]string
+	idLock             sync.Mutex
+	idIRCDiscord       map[string][]string
+	idDiscordIRC       map[string][]string
+	idDiscordChannel   map[string]string
+	idIRCChannel       map[string]string
+	idOrder            []string            // Discord messages of the ID maps, oldest first
+	idIRCText          map[string]string   // IRC message ID to its text, for the messages relayed from Discord
+	idEvicted          map[string][]string // IRC channel and Discord message ID to the texts relayed, for the messages evicted from the ID maps
+	idCoalesced        map[string][]string // IRC channel and Discord message ID to the other Discord messages merged with it, until its echo
+	redactRetries      map[string]bool     // channel and message ID of the redactions retried, only used from the IRC handler
+
+	// invites caches the descriptions of resolved invite codes, empty for invalid invites
+	invitesLock sync.Mutex
+	invites     map[string]string
+
+	channelsLock sync.RWMutex // protects the cfg.Channels map value, not its contents
+
+	accountLock sync.Mutex
+	ircAccounts map[string]string // lowercase IRC nick to services account, protected by accountLock
+
+	autoModLock     sync.Mutex
+	autoModLast     string // last notified execution, as a rule may trigger several actions for a message
+	autoModLastTime time.Time
+
+	coalesceLock    sync.Mutex
+	coalesceBuffers map[string]*coalesceBuffer // by destination, protected by coalesceLock
+
+	digestLock sync.Mutex
+	digests    map[string]*digest // Discord channel ID to its pending digest, protected by digestLock
+
+	ircDisconnected  *ircDisconnect // cause of the current IRC disconnection, set by the IRC handler and read once the connection ends
+	ircFatalFailures int            // count of consecutive fatal IRC disconnections
+
+	editLock  sync.Mutex
+	editTexts map[string]string // IRC channel and Discord message ID to the text relayed, protected by editLock
+
+	emojiLock         sync.RWMutex
+	applicationEmojis []*discordgo.Emoji // emojis owned by the application, usable in every guild, protected by emojiLock
+
+	floodLock    sync.Mutex
+	floodBuckets map[string]*floodBucket // protected by floodLock
+
+	discordLastLock sync.Mutex
+	discordLast     map[string]string // Discord channel ID to last relayed message ID, protected by discordLastLock
+	discordSessions int
+
+	ircHealth     *healthLink
+	discordHealth *healthLink
+
+	historyLock     sync.Mutex
+	historyLookups  map[string]map[string][]string // IRC channel to the IDs of deleted Discord messages looked up to their texts, protected by historyLock
+	historyRebuilds map[string]bool                // IRC channels whose history is searched to rebuild the ID maps, protected by historyLock
+
+	holdLock  sync.Mutex
+	holds     map[string]*held        // Discord message ID to held message, protected by holdLock
+	holdOrder map[string][]*holdTimer // IRC channel to its held messages in order, protected by holdLock
+	// holdRelayLock is held while relaying held messages, so that they are not reordered by concurrent releases.
+	holdRelayLock sync.Mutex
+
+	membersLock        sync.Mutex
+	membersSeen        map[string]map[string]time.Time // guild ID to user ID to last seen time
+	memberMisses       map[string]time.Time            // guild ID and lowercase name to the time it matched no member
+	memberQueries      map[string]chan struct{}        // nonce to the channel closed when its chunk is received
+	memberNonce        uint64
+	memberSearchTokens float64   // protected by membersLock
+	memberSearchTime   time.Time // protected by membersLock
+
+	latencyLock sync.Mutex
+	latencies   map[string]*latencyHistogram // by span name, protected by latencyLock
+
+	netsplitLock sync.Mutex
+	netsplits    map[string]*netsplit // reason to netsplit, protected by netsplitLock
+
+	ircBatches map[string]*ircBatch // by reference tag, only used from the IRC handler
+
+	discordQueue *outQueue // messages from and to Discord
+	ircQueue     *outQueue // messages from and to IRC
+
+	readMarkersLock sync.Mutex
+	readMarkers     map[string]time.Time // IRC channel to the time of its last message, protected by readMarkersLock
+	readMarkersSet  map[string]bool      // IRC channels whose read marker moved since the last flush, protected by readMarkersLock
+	readMarkerTimer *time.Timer          // protected by readMarkersLock
+
+	ircSASL    *saslConn     // connection of the current authentication, if any
+	saslClient saslMechanism // mechanism of the current authentication
+	saslBuffer []byte        // AUTHENTICATE chunks received so far
+
+	sentryEndpoint   string
+	sentryAuth       string
+	sentryErrorsLock sync.Mutex
+	sentryErrors     map[string]*sentryErrorCount // by operation, protected by sentryErrorsLock
+
+	stageLock   sync.Mutex
+	stageTopics map[string]string // stage channel ID to the topic of its live stage, protected by stageLock
+
+	stsLock     sync.Mutex
+	stsPolicies map[string]stsPolicy // server host to policy, protected by stsLock
+	stsPath     string
+
+	tokenLock     sync.Mutex
+	tokenRejected chan struct{} // signaled when the token of the open session is rejected
+	tokenInvalid  bool          // whether the current token was rejected, protected by tokenLock
+	tokenCurrent  string        // current token, protected by tokenLock
+
+	traceLock   sync.Mutex
+	traceBuffer []*span          // finished spans to export, protected by traceLock
+	traceRelays map[string]*span // source message ID to the root span of its relay, protected by traceLock
+	traceEchoes map[string]*span // Discord message ID to the span waiting for the echo of its relay to IRC, protected by traceLock
+
+	voiceLock      sync.Mutex
+	voiceTimers    map[string]*time.Timer // voice channel ID to the pending announcement of its occupancy, protected by voiceLock
+	voiceAnnounced map[string]int         // voice channel ID to its last announced occupancy, protected by voiceLock
+
+	// eventHooks are the functions registered with Bridge.OnEvent
+	eventHooks []func(e *Event)
+}
+
+// copy returns a copy of c, with copies of the maps and mappings the bridge modifies.
+func (c *Config) copy() Config {
+	cc := *c
+	cc.Channels = make(map[string]Channels, len(c.Channels))
+	for dc, chs := range c.Channels {
+		cc.Channels[dc] = make(Channels, len(chs))
+		for i, ch := range chs {
+			if ch != nil {
+				ch := *ch
+				cc.Channels[dc][i] = &ch
+			}
+		}
+	}
+	if c.Categories != nil {
+		cc.Categories = make(map[string]*Channel, len(c.Categories))
+		for category, ch := range c.Categories {
+			if ch != nil {
+				ch := *ch
+				cc.Categories[category] = &ch
+			} else {
+				cc.Categories[category] = nil
+			}
+		}
+	}
+	if c.Colors.Users != nil {
+		cc.Colors.Users = make(map[string]string, len(c.Colors.Users))
+		for id, color := range c.Colors.Users {
+			cc.Colors.Users[id] = color
+		}
+	}
+	return cc
+}
+
+// New validates the configuration and creates a bridge from it.
+// The bridge uses a copy of the configuration: c is not modified.
+func New(c *Config) (*Bridge, error) {
+	b := &Bridge{
+		cfg:               c.copy(),
+		ircAccounts:       make(map[string]string),
+		timestampLocation: time.Local,
+		timestampLayouts: map[string]string{
+			"t": "15:04 MST",
+			"T": "15:04:05 MST",
+			"d": "2006/01/02 MST",
+			"D": "January 02, 2006 MST",
+			"f": "January 02, 2006 at 15:04 MST",
+			"F": "Monday, January 02, 2006 at 15:04 MST",
+		},
+		recentMessages:     make(map[string]map[string]string),
+		idIRCDiscord:       make(map[string][]string),
+		idDiscordIRC:       make(map[string][]string),
+		idDiscordChannel:   make(map[string]string),
+		idIRCChannel:       make(map[string]string),
+		idIRCText:          make(map[string]string),
+		idEvicted:          make(map[string][]string),
+		idCoalesced:        make(map[string][]string),
+		redactRetries:      make(map[string]bool),
+		validColors:        []int{2, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13},
+		invites:            make(map[string]string),
+		coalesceBuffers:    make(map[string]*coalesceBuffer),
+		digests:            make(map[string]*digest),
+		editTexts:          make(map[string]string),
+		floodBuckets:       make(map[string]*floodBucket),
+		discordLast:        make(map[string]string),
+		historyLookups:     make(map[string]map[string][]string),
+		historyRebuilds:    make(map[string]bool),
+		holds:              make(map[string]*held),
+		holdOrder:          make(map[string][]*holdTimer),
+		membersSeen:        make(map[string]map[string]time.Time),
+		memberMisses:       make(map[string]time.Time),
+		memberQueries:      make(map[string]chan struct{}),
+		memberSearchTokens: memberSearchBurst,
+		latencies:          make(map[string]*latencyHistogram),
+		netsplits:          make(map[string]*netsplit),
+		discordQueue:       &outQueue{name: "discord"},
+		ircQueue:           &outQueue{name: "irc"},
+		readMarkers:        make(map[string]time.Time),
+		readMarkersSet:     make(map[string]bool),
+		sentryErrors:       make(map[string]*sentryErrorCount),
+		stageTopics:        make(map[string]string),
+		stsPolicies:        make(map[string]stsPolicy),
+		tokenRejected:      make(chan struct{}, 1),
+		traceRelays:        make(map[string]*span),
+		traceEchoes:        make(map[string]*span),
+		voiceTimers:        make(map[string]*time.Timer),
+		voiceAnnounced:     make(map[string]int),
+	}
+	b.ircHealth = &healthLink{b: b, name: "IRC", notify: b.healthNotifyDiscord}
+	b.discordHealth = &healthLink{b: b, name: "Discord", notify: b.healthNotifyIRC}
+	b.debug = b.cfg.Debug
+	for dc, chs := range b.cfg.Channels {
+		for _, ch := range chs {
+			if err := ch.validate(dc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := b.cfg.AutoMap.validate(); err != nil {
+		return nil, err
+	}
+	if err := b.validateCategories(); err != nil {
+		return nil, err
+	}
+	if err := b.cfg.Media.validate(); err != nil {
+		return nil, err
+	}
+	if err := b.validateIdentities(); err != nil {
+		return nil, err
+	}
+	switch b.cfg.AntiPing {
+	case "":
+		b.cfg.AntiPing = antiPingZWSP
+	case antiPingZWSP, antiPingSuffix, antiPingSwap, antiPingNone:
+	default:
+		return nil, fmt.Errorf("invalid antiPing: %q", b.cfg.AntiPing)
+	}
+	if b.cfg.Bind != "" && net.ParseIP(b.cfg.Bind) == nil {
+		return nil, fmt.Errorf("invalid bind address: %q", b.cfg.Bind)
+	}
+	if _, ok := ipNetworks[b.cfg.PreferIP]; b.cfg.PreferIP != "" && !ok {
+		return nil, fmt.Errorf("invalid preferIP: %q", b.cfg.PreferIP)
+	}
+	if len(b.cfg.NameOrder) == 0 {
+		b.cfg.NameOrder = []string{"nick", "global", "username"}
+	}
+	for _, name := range b.cfg.NameOrder {
+		if name != "nick" && name != "global" && name != "username" {
+			return nil, fmt.Errorf("invalid nameOrder name: %q", name)
+		}
+	}
+	switch b.cfg.MessageTags {
+	case "":
+		b.cfg.MessageTags = messageTagsID
+	case messageTagsID, messageTagsURL, messageTagsBoth:
+	default:
+		return nil, fmt.Errorf("invalid messageTags: %q", b.cfg.MessageTags)
+	}
+	switch b.cfg.ReplyExcerpts {
+	case "":
+		b.cfg.ReplyExcerpts = replyExcerptsUnbridged
+	case replyExcerptsNever, replyExcerptsUnbridged, replyExcerptsAlways:
+	default:
+		return nil, fmt.Errorf("invalid replyExcerpts: %q", b.cfg.ReplyExcerpts)
+	}
+	for id, color := range b.cfg.Colors.Users {
+		if c, err := ircColor(color); err != nil {
+			return nil, fmt.Errorf("invalid color for user %v: %v", id, err)
+		} else {
+			b.cfg.Colors.Users[id] = c
+		}
+	}
+	if b.cfg.Colors.Extended {
+		for i := 16; i <= 98; i++ {
+			b.validColors = append(b.validColors, i)
+		}
+	}
+	if b.cfg.ChanServ.Nick == "" {
+		b.cfg.ChanServ.Nick = "ChanServ"
+	}
+	switch b.cfg.SASL.Mechanism {
+	case "":
+		b.cfg.SASL.Mechanism = saslPlain
+	case saslPlain, saslSCRAMSHA256:
+	default:
+		return nil, fmt.Errorf("invalid sasl mechanism: %q", b.cfg.SASL.Mechanism)
+	}
+	if b.cfg.Server == "" && len(b.cfg.Servers) == 0 {
+		return nil, fmt.Errorf("no irc server configured")
+	}
+	if b.cfg.MaxLineLength <= 0 {
+		b.cfg.MaxLineLength = 400
+	}
+	if b.cfg.STSPath == "" {
+		b.cfg.STSPath = "sts.json"
+	}
+	b.stsLoad(b.cfg.STSPath)
+	if b.cfg.Queue != "" {
+		if err := b.queueOpen(b.cfg.Queue); err != nil {
+			return nil, fmt.Errorf("opening relay queue: %v", err)
+		}
+	}
+	if b.cfg.Locale == "" {
+		b.cfg.Locale = "en"
+	} else if _, ok := locales[b.cfg.Locale]; !ok {
+		return nil, fmt.Errorf("invalid locale: %q", b.cfg.Locale)
+	}
+	for key := range b.cfg.Messages {
+		if _, ok := locales["en"][key]; !ok {
+			return nil, fmt.Errorf("invalid message key: %q", key)
+		}
+	}
+	for _, event := range b.cfg.Audit.Events {
+		valid := false
+		for _, e := range auditEvents {
+			valid = valid || e == event
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid audit event: %q", event)
+		}
+	}
+	if b.cfg.Gateway.ShardCount < 0 || b.cfg.Gateway.ShardID < 0 || b.cfg.Gateway.ShardID >= b.cfg.Gateway.ShardCount && b.cfg.Gateway.ShardID != 0 {
+		return nil, fmt.Errorf("invalid gateway shard %d of %d", b.cfg.Gateway.ShardID, b.cfg.Gateway.ShardCount)
+	}
+	if b.cfg.Sentry.DSN != "" {
+		if err := b.sentryInit(b.cfg.Sentry.DSN); err != nil {
+			return nil, fmt.Errorf("invalid sentry dsn: %v", err)
+		}
+	}
+	if b.cfg.State.Members <= 0 {
+		b.cfg.State.Members = 1000
+	}
+	if b.cfg.Health.Delay <= 0 {
+		b.cfg.Health.Delay = time.Minute
+	}
+	if b.cfg.Flood.Interval <= 0 {
+		b.cfg.Flood.Interval = 2 * time.Second
+	}
+	if b.cfg.DialTimeout <= 0 {
+		b.cfg.DialTimeout = 30 * time.Second
+	}
+	if b.cfg.RejoinDelay <= 0 {
+		b.cfg.RejoinDelay = 15 * time.Second
+	}
+	if b.cfg.Timestamps.Timezone != "" {
+		var err error
+		b.timestampLocation, err = time.LoadLocation(b.cfg.Timestamps.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamps timezone: %v", err)
+		}
+	}
+	for style, layout := range b.cfg.Timestamps.Formats {
+		if _, ok := b.timestampLayouts[style]; !ok {
+			return nil, fmt.Errorf("invalid timestamps format style: %q", style)
+		}
+		b.timestampLayouts[style] = layout
+	}
+
+	return b, nil
+}
+
+// AddMapping bridges a Discord channel to an IRC channel, in addition to the configured channels.
+// It must be called before Run.
+func (b *Bridge) AddMapping(discordID string, ch *Channel) error {
+	if b.running {
+		return fmt.Errorf("bridge already running")
+	}
+	if ch.IRC == "" {
+		return fmt.Errorf("no irc channel for channel %v", discordID)
+	}
+	c := *ch
+	if err := c.validate(discordID); err != nil {
+		return err
+	}
+	b.cfg.Channels[discordID] = append(b.cfg.Channels[discordID], &c)
+	return nil
+}
+
+// OnEvent registers a function called on each bridge event (message, delete, connect, disconnect),
+// in addition to the configured webhooks. It must be called before Run.
+func (b *Bridge) OnEvent(f func(e *Event)) {
+	b.eventHooks = append(b.eventHooks, f)
+}
+
+// Run connects to Discord and IRC and relays messages until ctx is done,
+// then waits for the connections to end.
+func (b *Bridge) Run(ctx context.Context) error {
+	b.running = true
+	token, err := b.tokenRead()
+	if err != nil {
+		return err
+	}
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return err
+	}
+	b.tokenUse(session, token)
+	session.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsGuildMembers | discordgo.IntentMessageContent
+	b.gatewayConfigure(session)
+	b.discord = discordgoSession{session}
+	session.AddHandler(discordHandler(b.discordReady))
+	session.AddHandler(discordHandler(b.discordMessage))
+	session.AddHandler(discordHandler(b.discordDelete))
+	session.AddHandler(discordHandler(b.discordMessageUpdate))
+	session.AddHandler(discordHandler(b.discordEvent))
+	session.AddHandler(discordHandler(b.discordTyping))
+	session.AddHandler(discordHandler(b.discordConnect))
+	session.AddHandler(discordHandler(b.discordEventCreate))
+	session.AddHandler(discordHandler(b.discordEventUpdate))
+	session.AddHandler(discordHandler(b.discordDisconnect))
+	session.AddHandler(discordHandler(b.discordAutoMod))
+	session.AddHandler(discordHandler(b.discordAudit))
+	session.AddHandler(discordHandler(b.discordMembersChunk))
+	session.AddHandler(discordHandler(b.discordMemberAdd))
+	session.AddHandler(discordHandler(b.discordMemberUpdate))
+	session.AddHandler(discordHandler(b.discordMemberRemove))
+	session.AddHandler(discordHandler(b.discordThreadCreate))
+	session.AddHandler(discordHandler(b.discordThreadUpdate))
+	session.AddHandler(discordHandler(b.discordChannelCreate))
+	session.AddHandler(discordHandler(b.discordChannelUpdate))
+	session.AddHandler(discordHandler(b.discordChannelDelete))
+	session.AddHandler(discordHandler(b.discordGuildCreate))
+	session.AddHandler(discordHandler(b.discordVoiceStateUpdate))
+	session.AddHandler(discordHandler(b.discordStageStart))
+	session.AddHandler(discordHandler(b.discordStageUpdate))
+	session.AddHandler(discordHandler(b.discordStageEnd))
+	session.AddHandler(discordHandler(b.discordStageSpeaker))
+
+	if err := b.gatewayShardCheck(b.discord); err != nil {
+		return err
+	}
+	if len(b.cfg.Categories) > 0 {
+		if err := b.categoryMap(b.discord); err != nil {
+			return err
+		}
+	}
+	if b.cfg.AutoMap.Enabled {
+		if err := b.autoMap(b.discord); err != nil {
+			return err
+		}
+	}
+
+	// the goroutines all return once ctx is done
+	var wg sync.WaitGroup
+	run := func(f func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(ctx)
+		}()
+	}
+
+	run(func(ctx context.Context) {
+		b.discordOpen(ctx, session)
+	})
+	if b.cfg.API.Listen != "" {
+		run(b.apiServe)
+	}
+	if b.cfg.Tracing.Endpoint != "" {
+		run(b.traceExport)
+	}
+	run(func(ctx context.Context) {
+		for {
+			err := b.ircLoop(ctx)
+			b.ircClientLock.Lock()
+			b.ircClient = nil
+			b.ircClientLock.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			delay := b.ircRetry(err)
+			b.sentryError("irc connection", err, nil)
+			b.ircHealth.down()
+			b.webhookPost(&Event{
+				Event:  "disconnect",
+				Source: "irc",
+			})
+			if !sleepContext(ctx, delay) {
+				return
+			}
+		}
+	})
+
+	<-ctx.Done()
+	wg.Wait()
+	return session.Close()
+}
+
+// sleepContext waits for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *Bridge) ircLoop(ctx context.Context) error {
+	b.ircReady = false
+	b.ircStatusMsg = ""
+	b.ircConnected = time.Now()
+	b.ircClientLock.Lock()
+	b.ircCaps = make(map[string]bool)
+	b.ircJoined = make(map[string]bool)
+	b.ircClientLock.Unlock()
+	b.ircJoinAttempts = make(map[string]int)
+	b.ircCasemapping = ""
+	b.accountLock.Lock()
+	b.ircAccounts = make(map[string]string)
+	b.accountLock.Unlock()
+	b.ircBatches = make(map[string]*ircBatch)
+	b.historyLock.Lock()
+	b.historyLookups = make(map[string]map[string][]string)
+	b.historyRebuilds = make(map[string]bool)
+	b.historyLock.Unlock()
+	b.ircRegistered = false
+	b.ircUpgrading = false
+	servers := b.ircServers()
+	if len(servers) == 0 {
+		return fmt.Errorf("no irc server found")
+	}
+	b.ircAddr, b.ircSecure = b.stsTarget(servers[b.ircServerIndex%len(servers)])
+	tc, err := b.ircDial(b.ircAddr, b.ircSecure)
+	if err != nil {
+		b.ircServerIndex++
+		return err
+	}
+	if b.cfg.StallTimeout > 0 {
+		tc = &stallConn{Conn: tc, timeout: b.cfg.StallTimeout}
+	}
+	b.ircSASL = nil
+	if b.cfg.SASL.Username != "" {
+		b.ircSASL = &saslConn{Conn: tc, auth: true}
+		tc = b.ircSASL
+	}
+	c := irc.NewClient(tc, irc.ClientConfig{
+		Nick:          b.cfg.Nick,
+		User:          "discordircv3",
+		Name:          "discord-ircv3 bridge",
+		PingFrequency: 10 * time.Minute,
+		PingTimeout:   30 * time.Second,
+		SendLimit:     500 * time.Millisecond,
+		SendBurst:     10,
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			b.ircHandler(c, m)
+		}),
+	})
+	for _, name := range ircCapsRequested {
+		c.CapRequest(name, false)
+	}
+	if b.ircSASL != nil {
+		c.CapRequest("sasl", false)
+	}
+	if b.debug {
+		c.Writer.DebugCallback = func(line string) {
+			fmt.Printf(">>> %s\n", line)
+		}
+		c.Reader.DebugCallback = func(line string) {
+			fmt.Printf("<<< %s\n", line)
+		}
+	}
+	err = c.RunContext(ctx)
+	if !b.ircRegistered && !b.ircUpgrading {
+		// try the next server when failing to connect
+		b.ircServerIndex++
+	}
+	return err
+}
+
+// ircCapsEnabled returns whether the caps names are all enabled.
+func (b *Bridge) ircCapsEnabled(names ...string) bool {
+	b.ircClientLock.Lock()
+	defer b.ircClientLock.Unlock()
+	for _, name := range names {
+		if !b.ircCaps[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// ircCapsCheck disables the enabled caps whose dependency is not enabled. ircClientLock must be held.
+func (b *Bridge) ircCapsCheck(c ircConn) {
+	for name, dependency := range ircCapsDepend {
+		if b.ircCaps[name] && !b.ircCaps[dependency] {
+			delete(b.ircCaps, name)
+			c.WriteMessage(&irc.Message{
+				Command: "CAP",
+				Params:  []string{"REQ", "-" + name},
+			})
+		}
+	}
+}
+
+// ircDial opens a connection to an IRC server from the bind address, trying the preferred IP family first.
+func (b *Bridge) ircDial(addr string, secure bool) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout:   b.cfg.DialTimeout,
+		KeepAlive: b.cfg.KeepAlive,
+	}
+	if b.cfg.Bind != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(b.cfg.Bind)}
+	}
+	if isWebSocket(addr) {
+		return b.wsDial(addr, func(network, addr string) (net.Conn, error) {
+			if preferred, ok := ipNetworks[b.cfg.PreferIP]; ok {
+				if c, err := dialer.Dial(preferred, addr); err == nil {
+					return c, nil
+				}
+			}
+			return dialer.Dial(network, addr)
+		})
+	}
+	dial := func(network string) (net.Conn, error) {
+		if !secure {
+			return dialer.Dial(network, addr)
+		}
+		return tls.DialWithDialer(&dialer, network, addr, nil)
+	}
+	if network, ok := ipNetworks[b.cfg.PreferIP]; ok {
+		if c, err := dial(network); err == nil {
+			return c, nil
+		}
+	}
+	return dial("tcp")
+}
+
+// stallConn is a connection failing when no data is received for a given time,
+// regardless of the IRC PING logic.
+type stallConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *stallConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("connection stalled: no data received for %v", c.timeout)
+	}
+	return n, err
+}
+
+// ircServers returns the addresses of the IRC servers to connect to, in order.
+// Servers without a port are resolved with DNS SRV records, defaulting to the port 6697 (6667 for insecure servers).
+func (b *Bridge) ircServers() []string {
+	var servers []string
+	for _, server := range append([]string{b.cfg.Server}, b.cfg.Servers...) {
+		if server == "" {
+			continue
+		}
+		if isWebSocket(server) {
+			servers = append(servers, server)
+			continue
+		}
+		prefix, service, port := "", "ircs", "6697"
+		if strings.HasPrefix(server, ircInsecurePrefix) {
+			prefix, service, port = ircInsecurePrefix, "irc", "6667"
+			server = strings.TrimPrefix(server, ircInsecurePrefix)
+		}
+		if _, _, err := net.SplitHostPort(server); err == nil {
+			servers = append(servers, prefix+server)
+			continue
+		}
+		if _, srvs, err := net.LookupSRV(service, "tcp", server); err == nil && len(srvs) > 0 {
+			for _, srv := range srvs {
+				servers = append(servers, prefix+net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+			}
+			continue
+		}
+		servers = append(servers, prefix+net.JoinHostPort(server, port))
+	}
+	return servers
+}
+
+// ircWrite writes m to IRC, journaling the messages sent to channels, and returns whether it was written.
+func (b *Bridge) ircWrite(m *irc.Message) bool {
+	discordID := taggedDiscordID(m.Tags)
+	if (m.Command == "PRIVMSG" || m.Command == "NOTICE") && len(m.Params) > 1 && b.ircPlain(strings.TrimLeft(m.Params[0], b.ircStatusMsg)) {
+		m = m.Copy()
+		m.Params[1] = plainText(m.Params[1])
+	}
+	if m.Command != "PRIVMSG" {
+		return b.ircSend(m, discordID)
+	}
+	s := b.traceRelay(discordID).child("irc.journal")
+	qid := b.ircQueue.add(queueEntry{
+		Line: m.String(),
+	})
+	s.finish()
+	sent := b.ircSend(m, discordID)
+	b.ircQueue.finish(qid, sent)
+	return sent
+}
+
+// ircSend writes m, relaying the Discord message discordID if any, to IRC.
+// It returns false if m could not be written, e.g. while disconnected.
+func (b *Bridge) ircSend(m *irc.Message, discordID string) bool {
+	b.ircClientLock.Lock()
+	defer b.ircClientLock.Unlock()
+	if b.ircClient == nil {
+		if m.Command == "PRIVMSG" {
+			b.ircHealth.miss()
+		}
+		return false
+	}
+	if m.Command == "REDACT" && !b.ircCaps["draft/message-redaction"] {
+		return true
+	}
+	if len(m.Tags) > 0 && !b.ircCaps["message-tags"] {
+		m = m.Copy()
+		m.Tags = nil
+	}
+	s := b.traceRelay(discordID).child("irc.write")
+	err := b.ircClient.WriteMessage(m)
+	s.finish()
+	if err != nil {
+		logErr.Printf("failed writing to irc: %v", err)
+		return false
+	}
+	if m.Command == "PRIVMSG" && b.ircCaps["echo-message"] && b.ircCaps["message-tags"] {
+		b.traceEchoStart(discordID)
+	}
+	return true
+}
+
+// ircReplay relays the messages received from IRC before a restart, and the messages to IRC
+// queued before a restart or not sent while disconnected.
+func (b *Bridge) ircReplay(c ircConn) {
+	for _, e := range b.ircQueue.takeReplay() {
+		if e.Received != "" {
+			if m, err := irc.ParseMessage(e.Received); err == nil && len(m.Params) > 1 && m.Params[1] != "" {
+				b.ircPrivmsg(c, m)
+			}
+		} else if m, err := irc.ParseMessage(e.Line); err == nil {
+			b.ircWrite(m)
+		}
+		b.ircQueue.done(e.ID)
+	}
+}
+
+// ircTrackJoins keeps track of the mapped and notice channels the bridge is in, and rejoins them
+// after being kicked or failing to join them.
+func (b *Bridge) ircTrackJoins(c ircConn, m *irc.Message) {
+	switch m.Command {
+	case "JOIN":
+		if m.Name != c.CurrentNick() {
+			return
+		}
+		b.ircClientLock.Lock()
+		b.ircJoined[b.ircFold(m.Params[0])] = true
+		b.ircClientLock.Unlock()
+		delete(b.ircJoinAttempts, b.ircFold(m.Params[0]))
+	case "PART":
+		if m.Name != c.CurrentNick() {
+			return
+		}
+		b.ircClientLock.Lock()
+		delete(b.ircJoined, b.ircFold(m.Params[0]))
+		b.ircClientLock.Unlock()
+	case "KICK":
+		if len(m.Params) < 2 || m.Params[1] != c.CurrentNick() {
+			return
+		}
+		b.ircClientLock.Lock()
+		delete(b.ircJoined, b.ircFold(m.Params[0]))
+		b.ircClientLock.Unlock()
+		channel := b.ircTracked(m.Params[0])
+		if channel == "" {
+			return
+		}
+		logErr.Printf("kicked from irc channel %v by %v", channel, m.Prefix.Name)
+		b.ircJoinLater(c, channel)
+	case "471", "473", "474", "475": // channel full, invite only, banned, bad key
+		if len(m.Params) < 2 {
+			return
+		}
+		channel := b.ircTracked(m.Params[1])
+		if channel == "" {
+			return
+		}
+		logErr.Printf("failed joining irc channel %v: %v", channel, m.Trailing())
+		if m.Command == "474" {
+			b.chanServ(c, b.cfg.ChanServ.Unban, channel)
+		} else {
+			b.chanServ(c, b.cfg.ChanServ.Invite, channel)
+		}
+		b.ircJoinLater(c, channel)
+	case "INVITE":
+		if len(m.Params) < 2 || m.Params[0] != c.CurrentNick() {
+			return
+		}
+		channel := b.ircTracked(m.Params[1])
+		if channel == "" {
+			return
+		}
+		b.ircClientLock.Lock()
+		joined := b.ircJoined[b.ircFold(channel)]
+		b.ircClientLock.Unlock()
+		if joined {
+			return
+		}
+		// e.g. after failing to join an invite-only channel
+		logErr.Printf("invited to irc channel %v by %v, joining", channel, m.Prefix.Name)
+		b.ircWrite(b.ircJoin(channel))
+	case "482": // not channel operator
+		if len(m.Params) < 2 {
+			return
+		}
+		if channel := b.ircTracked(m.Params[1]); channel != "" {
+			b.chanServ(c, b.cfg.ChanServ.Op, channel)
+		}
+	}
+}
+
+// ircFold returns the casefolded IRC name, per the CASEMAPPING of the server.
+// Only used from the IRC handler.
+func (b *Bridge) ircFold(name string) string {
+	var r *strings.Replacer
+	switch b.ircCasemapping {
+	case "ascii":
+	case "rfc1459-strict":
+		r = ircFoldStrict
+	default:
+		r = ircFoldRFC1459
+	}
+	name = strings.Map(func(c rune) rune {
+		if c >= 'A' && c <= 'Z' {
+			return c + 'a' - 'A'
+		}
+		return c
+	}, name)
+	if r != nil {
+		name = r.Replace(name)
+	}
+	return name
+}
+
+var (
+	ircFoldRFC1459 = strings.NewReplacer("[", "{", "]", "}", "\\", "|", "~", "^")
+	ircFoldStrict  = strings.NewReplacer("[", "{", "]", "}", "\\", "|")
+)
+
+func (b *Bridge) chanServ(c ircConn, command string, channel string) {
+	if command == "" {
+		return
+	}
+	r := strings.NewReplacer("{channel}", channel, "{nick}", c.CurrentNick())
+	c.WriteMessage(&irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{b.cfg.ChanServ.Nick, r.Replace(command)},
+	})
+}
+
+// redactRetryDelay is how long a forbidden redaction is retried after requesting ops.
+var redactRetryDelay = 5 * time.Second
+
+// redactFailed handles the failure of a redaction: when forbidden, e.g. for the messages of other users
+// on servers only allowing chanops to redact them, ops are requested and the redaction is retried once.
+func (b *Bridge) redactFailed(c ircConn, m *irc.Message) {
+	if len(m.Params) < 4 || m.Params[0] != "REDACT" {
+		return
+	}
+	channel := m.Params[2]
+	if len(b.ircChannels(channel)) == 0 {
+		return
+	}
+	logErr.Printf("failed redacting irc message in %v: %v", channel, m.Trailing())
+	if m.Params[1] != "REDACT_FORBIDDEN" || len(m.Params) < 5 || b.cfg.ChanServ.Op == "" {
+		return
+	}
+	id := m.Params[3]
+	key := channel + " " + id
+	if b.redactRetries[key] {
+		delete(b.redactRetries, key)
+		return
+	}
+	if len(b.redactRetries) > 1024 {
+		b.redactRetries = make(map[string]bool)
+	}
+	b.redactRetries[key] = true
+	b.chanServ(c, b.cfg.ChanServ.Op, channel)
+	time.AfterFunc(redactRetryDelay, func() {
+		b.ircWrite(&irc.Message{
+			Command: "REDACT",
+			Params:  []string{channel, id},
+		})
+	})
+}
+
+// ircJoinLater tries joining channel again later on connection c, backing off exponentially on repeated failures.
+func (b *Bridge) ircJoinLater(c ircConn, channel string) {
+	key := b.ircFold(channel)
+	delay := b.cfg.RejoinDelay << b.ircJoinAttempts[key]
+	if delay > 30*time.Minute || delay <= 0 {
+		delay = 30 * time.Minute
+	} else {
+		b.ircJoinAttempts[key]++
+	}
+	time.AfterFunc(delay, func() {
+		b.ircClientLock.Lock()
+		// the channels are joined again on registration after a reconnection
+		skip := b.ircClient != c || b.ircJoined[key]
+		b.ircClientLock.Unlock()
+		if skip {
+			return
+		}
+		b.ircWrite(b.ircJoin(channel))
+	})
+}
+
+// ircJoin returns the message joining IRC channel ic, with its key if any.
+func (b *Bridge) ircJoin(ic string) *irc.Message {
+	params := []string{ic}
+	for _, ch := range b.ircChannels(ic) {
+		if ch.Key != "" {
+			params = append(params, ch.Key)
+			break
+		}
+	}
+	if key := b.cfg.ChannelKeys[ic]; len(params) == 1 && key != "" {
+		params = append(params, key)
+	}
+	return &irc.Message{
+		Command: "JOIN",
+		Params:  params,
+	}
+}
+
+// ircChannels returns the mappings of IRC channel ic, one per Discord channel it is bridged to.
+func (b *Bridge) ircChannels(ic string) []*Channel {
+	var chs []*Channel
+	for _, dchs := range b.mappings() {
+		for _, ch := range dchs {
+			if ch.IRC == ic {
+				chs = append(chs, ch)
+			}
+		}
+	}
+	return chs
+}
+
+// ircNoticeChannels returns the IRC channels the bridge sends notices to, joined even if they are not mapped.
+func (b *Bridge) ircNoticeChannels() []string {
+	var ics []string
+	if b.cfg.Audit.Channel != "" {
+		ics = append(ics, b.cfg.Audit.Channel)
+	}
+	if b.cfg.AutoMod.Enabled && b.cfg.AutoMod.Channel != "" {
+		ics = append(ics, b.cfg.AutoMod.Channel)
+	}
+	ics = append(ics, b.cfg.Health.IRCChannels...)
+	for _, eics := range b.cfg.EventChannels {
+		ics = append(ics, eics...)
+	}
+	return ics
+}
+
+// ircTracked returns the configured name of IRC channel ic if the bridge stays in it, as it is mapped
+// or a notice channel, or an empty string. Only used from the IRC handler.
+func (b *Bridge) ircTracked(ic string) string {
+	key := b.ircFold(ic)
+	for _, chs := range b.mappings() {
+		for _, ch := range chs {
+			if b.ircFold(ch.IRC) == key {
+				return ch.IRC
+			}
+		}
+	}
+	for _, nc := range b.ircNoticeChannels() {
+		if b.ircFold(nc) == key {
+			return nc
+		}
+	}
+	return ""
+}
+
+// idMaxCached is the count of Discord messages kept in the ID maps, above which the oldest are evicted.
+const idMaxCached = 10000
+
+func (b *Bridge) correlate(ircID string, discordID string) {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	if _, ok := b.idDiscordIRC[discordID]; !ok {
+		b.idOrder = append(b.idOrder, discordID)
+		if len(b.idOrder) > idMaxCached {
+			b.idEvict(b.idOrder[0])
+			b.idOrder = b.idOrder[1:]
+		}
+	}
+	b.idIRCDiscord[ircID] = append(b.idIRCDiscord[ircID], discordID)
+	b.idDiscordIRC[discordID] = append(b.idDiscordIRC[discordID], ircID)
+}
+
+// idEvict forgets Discord message discordID, recording the texts relayed from it to IRC to find them in the history
+// if it is deleted. idLock must be held.
+func (b *Bridge) idEvict(discordID string) {
+	if len(b.idEvicted) >= idMaxCached {
+		b.idEvicted = make(map[string][]string)
+	}
+	for _, ircID := range b.idDiscordIRC[discordID] {
+		if text, ok := b.idIRCText[ircID]; ok {
+			key := b.idIRCChannel[ircID] + " " + discordID
+			b.idEvicted[key] = append(b.idEvicted[key], text)
+		}
+		ids := b.idIRCDiscord[ircID][:0]
+		for _, id := range b.idIRCDiscord[ircID] {
+			if id != discordID {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			b.idIRCDiscord[ircID] = ids
+		} else {
+			delete(b.idIRCDiscord, ircID)
+			delete(b.idIRCChannel, ircID)
+			delete(b.idIRCText, ircID)
+		}
+	}
+	delete(b.idDiscordIRC, discordID)
+	delete(b.idDiscordChannel, discordID)
+}
+
+// evictedTexts returns the texts relayed to IRC channel ic from Discord message discordID, if it was evicted
+// from the ID maps, and forgets them.
+func (b *Bridge) evictedTexts(discordID string, ic string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	texts := b.idEvicted[ic+" "+discordID]
+	delete(b.idEvicted, ic+" "+discordID)
+	return texts
+}
+
+// correlateText records the text of an IRC message relayed from Discord.
+func (b *Bridge) correlateText(ircID string, text string) {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	b.idIRCText[ircID] = text
+}
+
+// correlated returns whether IRC message ircID is correlated with Discord message discordID.
+func (b *Bridge) correlated(ircID string, discordID string) bool {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	for _, id := range b.idIRCDiscord[ircID] {
+		if id == discordID {
+			return true
+		}
+	}
+	return false
+}
+
+// correlateChannel records the Discord channel of a Discord message.
+func (b *Bridge) correlateChannel(discordID string, channel string) {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	b.idDiscordChannel[discordID] = channel
+}
+
+// discordChannelIDs returns the Discord messages in channel dc correlated with IRC message ircID.
+func (b *Bridge) discordChannelIDs(ircID string, dc string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	var ids []string
+	for _, id := range b.idIRCDiscord[ircID] {
+		if b.idDiscordChannel[id] == dc {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// correlateIRCChannel records the IRC channel of an IRC message.
+func (b *Bridge) correlateIRCChannel(ircID string, ic string) {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	b.idIRCChannel[ircID] = ic
+}
+
+// ircChannelIDs returns the IRC messages in IRC channel ic correlated with Discord message discordID.
+func (b *Bridge) ircChannelIDs(discordID string, ic string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	var ids []string
+	for _, id := range b.idDiscordIRC[discordID] {
+		if b.idIRCChannel[id] == ic {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// correlateCoalesced records the Discord messages ids merged in the message relaying Discord message discordID
+// to IRC channel ic, to correlate them with it too on its echo.
+func (b *Bridge) correlateCoalesced(discordID string, ic string, ids []string) {
+	if !b.ircCapsEnabled("echo-message") {
+		return
+	}
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	b.idCoalesced[ic+" "+discordID] = ids
+}
+
+// coalescedIDs returns the Discord messages merged in the message relaying Discord message discordID
+// to IRC channel ic, and forgets them.
+func (b *Bridge) coalescedIDs(discordID string, ic string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	ids := b.idCoalesced[ic+" "+discordID]
+	delete(b.idCoalesced, ic+" "+discordID)
+	return ids
+}
+
+// ircReplyTo returns the ID of an IRC message of IRC channel ic relaying Discord message discordID, for replies.
+func (b *Bridge) ircReplyTo(discordID string, ic string) string {
+	if ids := b.ircChannelIDs(discordID, ic); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+func (b *Bridge) discordIDs(ircID string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	return b.idIRCDiscord[ircID]
+}
+
+func (b *Bridge) ircIDs(discordID string) []string {
+	b.idLock.Lock()
+	defer b.idLock.Unlock()
+	return b.idDiscordIRC[discordID]
+}
+
+type ircStyle struct {
+	italics       bool
+	bold          bool
+	underline     bool
+	strikethrough bool
+}
+
+func isDigit(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c >= '0' && c <= '9'
+}
+
+// isHexColor returns whether s has a RRGGBB hex color at i.
+func isHexColor(s string, i int) bool {
+	if i+6 > len(s) {
+		return false
+	}
+	for _, c := range []byte(s[i : i+6]) {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// stripFormatting returns the text of an IRC message, without formatting codes.
+func stripFormatting(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case fColor:
+			if !isDigit(s, i+1) {
+				continue
+			}
+			i++
+			if isDigit(s, i+1) {
+				i++
+			}
+			if isDigit(s, i+2) && s[i+1] == ',' {
+				i += 2
+				if isDigit(s, i+1) {
+					i++
+				}
+			}
+		case fColorHex:
+			if !isHexColor(s, i+1) {
+				continue
+			}
+			i += 6
+			if i+1 < len(s) && s[i+1] == ',' && isHexColor(s, i+2) {
+				i += 7
+			}
+		case fBold, fItalics, fUnderline, fStrikethrough, fMonospace, fReverse, fReset:
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// ircPalette is the RGB value of the 16 standard IRC colors.
+var ircPalette = [16]uint32{
+	0xFFFFFF, 0x000000, 0x00007F, 0x009300, 0xFF0000, 0x7F0000, 0x9C009C, 0xFC7F00,
+	0xFFFF00, 0x00FC00, 0x009393, 0x00FFFF, 0x0000FC, 0xFF00FF, 0x7F7F7F, 0xD2D2D2,
+}
+
+// nearestColor returns the standard IRC color closest to the RRGGBB hex color at i in s,
+// which must be valid.
+func nearestColor(s string, i int) int {
+	rgb, _ := strconv.ParseUint(s[i:i+6], 16, 32)
+	best := 0
+	bestDistance := -1
+	for code, c := range ircPalette {
+		dr := int(rgb>>16) - int(c>>16)
+		dg := int(rgb>>8&0xFF) - int(c>>8&0xFF)
+		db := int(rgb&0xFF) - int(c&0xFF)
+		if d := dr*dr + dg*dg + db*db; bestDistance < 0 || d < bestDistance {
+			best = code
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// downgradeColors replaces the hex colors of msg with the nearest standard IRC colors.
+func downgradeColors(msg string) string {
+	if strings.IndexByte(msg, fColorHex) < 0 {
+		return msg
+	}
+	var sb strings.Builder
+	sb.Grow(len(msg))
+	for i := 0; i < len(msg); i++ {
+		if msg[i] != fColorHex {
+			sb.WriteByte(msg[i])
+			continue
+		}
+		if !isHexColor(msg, i+1) {
+			// a color reset
+			sb.WriteByte(fColor)
+		} else {
+			fmt.Fprintf(&sb, "%c%02d", fColor, nearestColor(msg, i+1))
+			i += 6
+			if i+1 < len(msg) && msg[i+1] == ',' && isHexColor(msg, i+2) {
+				fmt.Fprintf(&sb, ",%02d", nearestColor(msg, i+2))
+				i += 7
+			}
+		}
+		if isDigit(msg, i+1) || i+1 < len(msg) && msg[i+1] == ',' && isDigit(msg, i+2) {
+			// separate the following text from the color code with an empty bold span
+			sb.WriteByte(fBold)
+			sb.WriteByte(fBold)
+		}
+	}
+	return sb.String()
+}
+
+// mediaExtensions are the default extensions of the media links embedded by Discord.
+var mediaExtensions = []string{"jpg", "jpeg", "png", "gif", "mp4", "webm"}
+
+type MediaConfig struct {
+	Disabled   bool     `yaml:"disabled"`   // relay media links with the nick on the same line, as other messages
+	Extensions []string `yaml:"extensions"` // extensions of media links, defaults to jpg, jpeg, png, gif, mp4 and webm
+	Pattern    string   `yaml:"pattern"`    // regular expression matching whole media links, overriding extensions
+
+	pattern *regexp.Regexp
+}
+
+// validate compiles the pattern of media links.
+func (c *MediaConfig) validate() error {
+	if c.Pattern != "" {
+		p, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid media pattern: %v", err)
+		}
+		c.pattern = p
+		return nil
+	}
+	extensions := c.Extensions
+	if len(extensions) == 0 {
+		extensions = mediaExtensions
+	}
+	quoted := make([]string, len(extensions))
+	for i, ext := range extensions {
+		quoted[i] = regexp.QuoteMeta(strings.TrimPrefix(ext, "."))
+	}
+	c.pattern = regexp.MustCompile("^https?://[^\\s\\x01-\\x16]+\\.(?i:" + strings.Join(quoted, "|") + ")$")
+	return nil
+}
+
+// mediaLink returns whether the IRC message body is a single media link, which is sent in its own
+// Discord message so that Discord embeds it.
+func (b *Bridge) mediaLink(body string) bool {
+	if b.cfg.Media.Disabled || b.cfg.Media.pattern == nil {
+		return false
+	}
+	return !strings.ContainsRune(body, ' ') && b.cfg.Media.pattern.MatchString(body)
+}
+
+// urlLength returns the length of the URL at the start of s, or 0 if there is none.
+// It matches ^https?://[^\s<]+[^<.,:;"')\]\s], so that trailing punctuation is not part of the URL.
+func urlLength(s string) int {
+	var n int
+	if strings.HasPrefix(s, "https://") {
+		n = len("https://")
+	} else if strings.HasPrefix(s, "http://") {
+		n = len("http://")
+	} else {
+		return 0
+	}
+	start := n
+	for n < len(s) && !isURLSpace(s[n]) && s[n] != '<' {
+		n++
+	}
+	for n > start+1 && strings.IndexByte("<.,:;\"')]", s[n-1]) >= 0 {
+		n--
+	}
+	if n < start+2 {
+		return 0
+	}
+	return n
+}
+
+// isURLSpace returns whether c is an ASCII whitespace byte, which ends URLs.
+func isURLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// discordFormat converts IRC formatting to Discord markdown, escaping markdown characters
+// outside of code and URLs. It runs in a single pass over the bytes of msg.
+func discordFormat(msg string) string {
+	// invalid UTF-8 is replaced when sending the message anyway: replace it first, so that
+	// removing formatting codes does not join the bytes around them into other characters
+	msg = strings.ToValidUTF8(msg, "\uFFFD")
+	var sb strings.Builder
+	sb.Grow(len(msg) + len(msg)/8 + 8)
+	var prevStyle ircStyle
+	var nextStyle ircStyle
+	raw := false
+	urlEnd := 0
+	lineStart := true // only whitespace was written on the current line
+	escapeAt := -1    // index of the dot of an ordered list item
+	// the end of the message is handled as a reset, closing the open styles
+	for i := 0; i <= len(msg); i++ {
+		c := fReset
+		if i < len(msg) {
+			c = msg[i]
+		}
+		if raw {
+			switch c {
+			case fBold, fItalics, fUnderline, fStrikethrough, fReset, fMonospace, fReverse:
+				// formatting is dropped in code
+				continue
+			case '`', fColor, fColorHex:
+			default:
+				sb.WriteByte(c)
+				lineStart = false
+				continue
+			}
+		}
+		if i >= urlEnd && c == 'h' {
+			if n := urlLength(msg[i:]); n > 0 {
+				urlEnd = i + n
+			}
+		}
+		write := true
+		escape := i == escapeAt
+		switch c {
+		// formatting codes toggle their style
+		case fBold:
+			nextStyle.bold = !nextStyle.bold
+			write = false
+		case fItalics:
+			nextStyle.italics = !nextStyle.italics
+			write = false
+		case fUnderline:
+			nextStyle.underline = !nextStyle.underline
+			write = false
+		case fStrikethrough:
+			nextStyle.strikethrough = !nextStyle.strikethrough
+			write = false
+		case fReset:
+			nextStyle = ircStyle{}
+			write = false
+		case fMonospace, fReverse:
+			continue
+		case fColor:
+			if !isDigit(msg, i+1) {
+				continue
+			}
+			i++
+			if isDigit(msg, i+1) {
+				i++
+			}
+			if isDigit(msg, i+2) && msg[i+1] == ',' {
+				i += 2
+				if isDigit(msg, i+1) {
+					i++
+				}
+			}
+			continue
+		case fColorHex:
+			if !isHexColor(msg, i+1) {
+				continue
+			}
+			i += 6
+			if i+1 < len(msg) && msg[i+1] == ',' && isHexColor(msg, i+2) {
+				i += 7
+			}
+			continue
+		case '`':
+			if !raw {
+				if strings.IndexByte(msg[i+1:], '`') > 0 {
+					raw = true
+				}
+			} else {
+				raw = false
+			}
+		case '\\', '*', '_', '~':
+			// in URLs, don't escape chars
+			escape = i >= urlEnd
+		case '|':
+			// spoilers
+			escape = i >= urlEnd && (i+1 < len(msg) && msg[i+1] == '|' || i > 0 && msg[i-1] == '|')
+		case ']':
+			// masked links
+			escape = i >= urlEnd && i+1 < len(msg) && msg[i+1] == '('
+		case '#', '-', '>':
+			// headers, subtexts, lists and quotes
+			escape = lineStart && markdownBlock(msg[i:])
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			if lineStart {
+				// ordered lists
+				j := i + 1
+				for j < len(msg) && isDigit(msg, j) {
+					j++
+				}
+				if strings.HasPrefix(msg[j:], ". ") {
+					escapeAt = j
+				}
+			}
+		}
+		if !write && i < len(msg) {
+			continue
+		}
+		if prevStyle != nextStyle {
+			if prevStyle.italics {
+				sb.WriteByte('*')
+			}
+			if prevStyle.bold {
+				sb.WriteString("**")
+			}
+			if prevStyle.underline {
+				sb.WriteString("__")
+			}
+			if prevStyle.strikethrough {
+				sb.WriteString("~~")
+			}
+			prevStyle = ircStyle{}
+			if !write {
+				continue
+			}
+			sb.WriteString("\u200B")
+			if nextStyle.strikethrough {
+				sb.WriteString("~~")
+			}
+			if nextStyle.underline {
+				sb.WriteString("__")
+			}
+			if nextStyle.bold {
+				sb.WriteString("**")
+			}
+			if nextStyle.italics {
+				sb.WriteByte('*')
+			}
+			prevStyle = nextStyle
+		}
+		if !write {
+			continue
+		}
+		if escape {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+		lineStart = c == '\n' || lineStart && (c == ' ' || c == '\t')
+	}
+	return sb.String()
+}
+
+// markdownBlocks are the prefixes of lines rendered by Discord as blocks.
+var markdownBlocks = []string{"# ", "## ", "### ", "-# ", "- ", "> ", ">>> "}
+
+// markdownBlock returns whether line starts with the markup of a Discord block.
+func markdownBlock(line string) bool {
+	for _, prefix := range markdownBlocks {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternMention matches @name and legacy @name#1234 mentions, with underscores possibly escaped by discordFormat
+var patternMention = regexp.MustCompile("@((?:\\\\?[\\w.])+)(?:#(\\d{4}))?")
+var patternEmoji = regexp.MustCompile(":(\\w+):")
+
+func (b *Bridge) discordTransformPart(channel string, msg string) string {
+	b.discord.state().RLock()
+	defer b.discord.state().RUnlock()
+	c, err := b.discord.state().Channel(channel)
+	if err != nil {
+		return msg
+	}
+	g, err := b.discord.state().Guild(c.GuildID)
+	if err != nil {
+		return msg
+	}
+	msg = regexReplaceAll(patternMention, msg, func(groups []int) string {
+		original := msg[groups[0]:groups[1]]
+		if groups[0] > 0 && isWordByte(msg[groups[0]-1]) {
+			// e.g. an email address
+			return original
+		}
+		name := strings.ReplaceAll(msg[groups[2]:groups[3]], "\\", "")
+		discriminator := ""
+		suffix := ""
+		if groups[4] >= 0 {
+			discriminator = msg[groups[4]:groups[5]]
+		} else {
+			// trailing dots are punctuation rather than part of the name
+			trimmed := strings.TrimRight(name, ".")
+			suffix = name[len(trimmed):]
+			name = trimmed
+		}
+		if name == "" {
+			return original
+		}
+		if i := b.identityName(name); i != nil && discriminator == "" {
+			return "<@" + i.Discord + ">" + suffix
+		}
+		if mention := discordMention(g, strings.ToLower(name), discriminator, func(r *discordgo.Role) bool {
+			return b.roleMentionable(channel, r)
+		}); mention != "" {
+			return mention + suffix
+		}
+		if name == "everyone" || name == "here" {
+			return "@\u200B" + original[1:]
+		}
+		return original
+	})
+	msg = regexReplaceAll(patternEmoji, msg, func(groups []int) string {
+		original := msg[groups[0]:groups[1]]
+		emoji := strings.ToLower(msg[groups[2]:groups[3]])
+		for _, e := range g.Emojis {
+			if e.Available && emoji == strings.ToLower(e.Name) {
+				return e.MessageFormat()
+			}
+		}
+		if e := b.applicationEmoji(emoji); e != nil {
+			return e.MessageFormat()
+		}
+		return original
+	})
+	return msg
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// discordMention returns the mention of the member or role of g named name, or an empty string.
+// Names are matched against usernames, then server nicks, then global names, then the roles for
+// which mentionable returns true; ties are broken by picking the oldest user.
+func discordMention(g *discordgo.Guild, name string, discriminator string, mentionable func(r *discordgo.Role) bool) string {
+	if discriminator != "" && discriminator != "0" {
+		for _, u := range g.Members {
+			if name == strings.ToLower(u.User.Username) && discriminator == u.User.Discriminator {
+				return u.Mention()
+			}
+		}
+		return ""
+	}
+	matchers := []func(u *discordgo.Member) bool{
+		func(u *discordgo.Member) bool { return name == strings.ToLower(u.User.Username) },
+		func(u *discordgo.Member) bool { return name == strings.ToLower(u.Nick) },
+		func(u *discordgo.Member) bool { return name == strings.ToLower(u.User.GlobalName) },
+	}
+	for _, match := range matchers {
+		var best *discordgo.Member
+		for _, u := range g.Members {
+			if match(u) && (best == nil || snowflakeLess(u.User.ID, best.User.ID)) {
+				best = u
+			}
+		}
+		if best != nil {
+			return best.Mention()
+		}
+	}
+	for _, r := range g.Roles {
+		if name == strings.ToLower(r.Name) && mentionable(r) {
+			return r.Mention()
+		}
+	}
+	return ""
+}
+
+func snowflakeLess(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func (b *Bridge) discordTransform(channel, msg string) string {
+	if c, err := b.discord.state().Channel(channel); err == nil && c.GuildID != "" {
+		b.membersFetch(b.discord, c.GuildID, msg)
+	}
+	var sb strings.Builder
+	for len(msg) > 0 {
+		rawStart := strings.IndexByte(msg, '`')
+		if rawStart >= 0 {
+			rawEnd := rawStart + 1 + strings.IndexByte(msg[rawStart+1:], '`')
+			if rawEnd >= 0 {
+				if rawStart > 0 {
+					sb.WriteString(b.discordTransformPart(channel, msg[:rawStart]))
+					sb.WriteString(msg[rawStart : rawEnd+1])
+					msg = msg[rawEnd+1:]
+					continue
+				}
+			}
+		}
+		sb.WriteString(b.discordTransformPart(channel, msg))
+		break
+	}
+	return sb.String()
+}
+
+func (b *Bridge) discordSend(id string, channel string, msg string, replyID string) *discordgo.Message {
+	return b.discordSendFrom(id, channel, msg, replyID, true)
+}
+
+// discordSendFrom is discordSend for text written by IRC users, which may mention roles only if roles is true.
+func (b *Bridge) discordSendFrom(id string, channel string, msg string, replyID string, roles bool) *discordgo.Message {
+	s := b.traceRelay(id).child("discord.transform")
+	if b.discordPlain(channel) {
+		// IRC formatting is stripped rather than converted, and markdown characters are escaped
+		msg = plainText(msg)
+	}
+	msg = discordFormat(msg)
+	msg = b.discordTransform(channel, msg)
+	s.finish()
+	return b.discordPost(id, channel, msg, replyID, roles)
+}
+
+// patternRoleMention matches Discord role mentions.
+var patternRoleMention = regexp.MustCompile("<@&(\\d+)>")
+
+// discordAllowedMentions returns the mentions that may ping in content sent to Discord channel channel:
+// users and the replied user, and if roles is true, roles allowed by the role mentions policy, but never
+// @everyone and @here, even when written in raw Discord syntax on IRC.
+func (b *Bridge) discordAllowedMentions(channel string, content string, roles bool) *discordgo.MessageAllowedMentions {
+	allowed := &discordgo.MessageAllowedMentions{
+		Parse:       []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeUsers},
+		RepliedUser: true,
+	}
+	if !roles {
+		return allowed
+	}
+	c, err := b.discord.state().Channel(channel)
+	if err != nil {
+		return allowed
+	}
+	mentioned := make(map[string]bool)
+	for _, match := range patternRoleMention.FindAllStringSubmatch(content, -1) {
+		r, err := b.discord.state().Role(c.GuildID, match[1])
+		if err != nil || mentioned[r.ID] || !b.roleMentionable(channel, r) {
+			continue
+		}
+		mentioned[r.ID] = true
+		allowed.Roles = append(allowed.Roles, r.ID)
+	}
+	return allowed
+}
+
+// discordPost sends the Discord message content, relayed from the IRC message id if any,
+// pinging the roles it mentions if roles is true.
+func (b *Bridge) discordPost(id string, channel string, content string, replyID string, roles bool) *discordgo.Message {
+	if b.discordChannelDeleted(channel) {
+		return nil
+	}
+	s := b.traceRelay(id).child("discord.journal")
+	qid := b.discordQueue.add(queueEntry{
+		Channel: channel,
+		Content: content,
+		Origin:  id,
+		ReplyID: replyID,
+		NoRoles: !roles,
+	})
+	s.finish()
+	m, err := b.discordDeliver(id, channel, content, replyID, roles)
+	// messages Discord refuses, e.g. for missing permissions, would be refused again
+	b.discordQueue.finish(qid, err == nil || !discordRetryable(err))
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// discordRetryable returns whether sending a message failed with err could succeed later.
+func discordRetryable(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Response == nil {
+		// e.g. a network error
+		return true
+	}
+	code := restErr.Response.StatusCode
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// discordDeliver sends the Discord message content for discordPost.
+func (b *Bridge) discordDeliver(id string, channel string, content string, replyID string, roles bool) (*discordgo.Message, error) {
+	dm := &discordgo.MessageSend{
+		Content:         content,
+		AllowedMentions: b.discordAllowedMentions(channel, content, roles),
+	}
+	if replyID != "" {
+		dm.Reference = &discordgo.MessageReference{
+			MessageID: replyID,
+			ChannelID: channel,
+		}
+	}
+	s := b.traceRelay(id).child("discord.send")
+	s.set("discord.channel", channel)
+	m, err := b.discord.ChannelMessageSendComplex(channel, dm)
+	s.finish()
+	if err != nil {
+		b.discordHealth.miss()
+		b.sentryError("discord send", err, map[string]string{
+			"channel": channel,
+		})
+		return nil, err
+	}
+	b.correlateChannel(m.ID, channel)
+	if id != "" {
+		b.correlate(id, m.ID)
+	}
+	crosspost := false
+	for _, ch := range b.mappings()[channel] {
+		crosspost = crosspost || ch.Crosspost
+	}
+	if crosspost {
+		if c, err := b.discord.state().Channel(channel); err == nil && c.Type == discordgo.ChannelTypeGuildNews {
+			if _, err := b.discord.ChannelMessageCrosspost(channel, m.ID); err != nil {
+				logErr.Printf("failed crossposting discord message %v: %v", m.ID, err)
+				b.sentryError("discord crosspost", err, map[string]string{
+					"channel": channel,
+				})
+			}
+		}
+	}
+	return m, nil
+}
+
+var patternNickReply = regexp.MustCompile("^([^\\s:,]+)[:,] ")
+
+// recentMessage returns the last message in Discord channel dc of the Discord user the
+// IRC message body is addressed to, in the IRC "nick: message" style.
+func (b *Bridge) recentMessage(dc string, body string) string {
+	match := patternNickReply.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	// undo anti-ping changes made to relayed nicks
+	nick := strings.ToLower(strings.TrimSuffix(strings.ReplaceAll(match[1], "\u200B", ""), "[d]"))
+	b.recentMessagesLock.Lock()
+	defer b.recentMessagesLock.Unlock()
+	return b.recentMessages[dc][nick]
+}
+
+// ircPlayback returns whether m is an old message played back by a bouncer that should not be relayed.
+func (b *Bridge) ircPlayback(m *irc.Message) bool {
+	if msgID := string(m.Tags["msgid"]); msgID != "" && len(b.discordIDs(msgID)) > 0 {
+		// already relayed
+		return true
+	}
+	serverTime := string(m.Tags["time"])
+	if serverTime == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, serverTime)
+	if err != nil {
+		return false
+	}
+	if b.cfg.PlaybackMaxAge > 0 {
+		return time.Since(t) > b.cfg.PlaybackMaxAge
+	}
+	return t.Before(b.ircConnected)
+}
+
+func (b *Bridge) ircHandler(c ircConn, m *irc.Message) {
+	defer b.sentryRecover()
+	if b.ircBatchHold(c, m) {
+		return
+	}
+	b.ircTrackJoins(c, m)
+	if m.Name == c.CurrentNick() && m.Command != "PRIVMSG" {
+		return
+	}
+	msgID := string(m.Tags["msgid"])
+	replyID := func(dc string) string {
+		return b.ircReplyID(m, dc)
+	}
+	handled := true
+	switch m.Command {
+	case "CAP":
+		if len(m.Params) < 3 {
+			break
+		}
+		// the irc library only tracks caps during registration: keep track of them ourselves,
+		// including caps added or removed later on with cap-notify
+		b.ircClientLock.Lock()
+		for _, name := range strings.Fields(m.Trailing()) {
+			name, value, _ := strings.Cut(name, "=")
+			if name == "sts" && (m.Params[1] == "LS" || m.Params[1] == "NEW") && !b.ircUpgrading && b.ircSTS(value) {
+				b.ircUpgrading = true
+				logErr.Printf("upgrading irc connection to %v to tls", b.ircAddr)
+				c.WriteMessage(&irc.Message{
+					Command: "QUIT",
+					Params:  []string{"Upgrading to a secure connection"},
+				})
+			}
+			switch m.Params[1] {
+			case "ACK":
+				if strings.HasPrefix(name, "-") {
+					delete(b.ircCaps, name[1:])
+				} else {
+					b.ircCaps[name] = true
+				}
+			case "DEL":
+				delete(b.ircCaps, name)
+			case "NEW":
+				if b.ircCaps[name] {
+					break
+				}
+				for _, requested := range ircCapsRequested {
+					if name == requested {
+						c.WriteMessage(&irc.Message{
+							Command: "CAP",
+							Params:  []string{"REQ", name},
+						})
+					}
+				}
+			}
+		}
+		if b.ircRegistered {
+			// during registration, caps are acknowledged one by one until 001
+			b.ircCapsCheck(c)
+		}
+		b.ircClientLock.Unlock()
+		b.saslHandle(c, m)
+	case "AUTHENTICATE", "903", "904", "905", "906", "907":
+		b.saslHandle(c, m)
+	case "381": // RPL_YOUREOPER
+		logErr.Printf("logged in as irc operator %v", b.cfg.Oper.Name)
+	case "464", "491": // ERR_PASSWDMISMATCH, ERR_NOOPERHOST
+		if !b.ircRegistered {
+			b.ircClassify(m)
+			break
+		}
+		logErr.Printf("failed logging in as irc operator: %v", m.Trailing())
+	case "465", "ERROR": // ERR_YOUREBANNEDCREEP
+		b.ircClassify(m)
+	case "FAIL":
+		b.redactFailed(c, m)
+		b.historyFailed(m)
+	case "001":
+		b.ircRegistered = true
+		b.ircClientLock.Lock()
+		b.ircCapsCheck(c)
+		b.ircClientLock.Unlock()
+		b.ircFatalFailures = 0
+		b.ircHealth.up()
+		if b.cfg.Oper.Name != "" {
+			// before joining, so that channel privileges are granted
+			c.WriteMessage(&irc.Message{
+				Command: "OPER",
+				Params:  []string{b.cfg.Oper.Name, b.cfg.Oper.Password},
+			})
+		}
+		// use the server clock for detecting playback when possible
+		if t, err := time.Parse(time.RFC3339Nano, string(m.Tags["time"])); err == nil {
+			b.ircConnected = t
+		}
+		joins := make(map[string]bool)
+		for _, chs := range b.mappings() {
+			for _, ch := range chs {
+				if joins[ch.IRC] {
+					continue
+				}
+				joins[ch.IRC] = true
+				c.WriteMessage(b.ircJoin(ch.IRC))
+			}
+		}
+		for _, ic := range b.ircNoticeChannels() {
+			if !joins[ic] {
+				joins[ic] = true
+				c.WriteMessage(b.ircJoin(ic))
+			}
+		}
+		if b.cfg.ServerNotices != "" {
+			c.WriteMessage(&irc.Message{
+				Command: "MODE",
+				Params:  []string{c.CurrentNick(), "+w"},
+			})
+		}
+		b.ircClientLock.Lock()
+		b.ircClient = c
+		b.ircClientLock.Unlock()
+		b.webhookPost(&Event{
+			Event:  "connect",
+			Source: "irc",
+		})
+	case "005":
+		if len(m.Params) > 2 {
+			for _, param := range m.Params[1 : len(m.Params)-1] {
+				key, value, _ := strings.Cut(param, "=")
+				switch key {
+				case "BOT":
+					c.WriteMessage(&irc.Message{
+						Command: "MODE",
+						Params:  []string{c.CurrentNick(), "+" + value},
+					})
+				case "STATUSMSG":
+					b.ircStatusMsg = value
+				case "CASEMAPPING":
+					b.ircCasemapping = value
+				}
+			}
+		}
+		c.WriteMessage(&irc.Message{
+			Command: "PING",
+			Params:  []string{"ready"},
+		})
+	case "PONG":
+		if m.Params[len(m.Params)-1] == "ready" {
+			b.ircReady = true
+			go b.ircReplay(c)
+		}
+	default:
+		handled = false
+	}
+	if handled || !b.ircReady {
+		return
+	}
+	if b.ircPlayback(m) {
+		return
+	}
+	b.accountTrack(m)
+	switch m.Command {
+	case "NICK", "JOIN", "PART", "QUIT", "TAGMSG":
+		if b.optOutIRC(m.Prefix.Name) {
+			return
+		}
+	case "KICK":
+		if len(m.Params) > 1 && b.optOutIRC(m.Params[1]) {
+			return
+		}
+	}
+	switch m.Command {
+	case "NICK":
+		for dc, chs := range b.mappings() {
+			if !relayToDiscord(chs) {
+				continue
+			}
+			b.discordSendFrom(msgID, dc, fmt.Sprintf("%c%s%c %s", fItalics, m.Prefix.Name, fReset, b.localize("nick", m.Params[0])), replyID(dc), false)
+		}
+	case "JOIN":
+		for _, ch := range b.ircChannels(m.Params[0]) {
+			if !ch.relayToDiscord() {
+				continue
+			}
+			if b.digestHold(ch.Discord, m.Prefix.Name, true) {
+				continue
+			}
+			name := b.ircName(m.Prefix.Name, b.ircAccount(m.Prefix.Name))
+			b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, name, fReset, b.localize("join")), replyID(ch.Discord), false)
+		}
+	case "PART":
+		for _, ch := range b.ircChannels(m.Params[0]) {
+			if !ch.relayToDiscord() || b.digestHold(ch.Discord, m.Prefix.Name, false) {
+				continue
+			}
+			if len(m.Params) > 1 {
+				b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, m.Prefix.Name, fReset, b.localize("partReason", m.Params[1])), replyID(ch.Discord), false)
+			} else {
+				b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, m.Prefix.Name, fReset, b.localize("part")), replyID(ch.Discord), false)
+			}
+		}
+	case "KICK":
+		for _, ch := range b.ircChannels(m.Params[0]) {
+			if !ch.relayToDiscord() {
+				continue
+			}
+			if len(m.Params) > 2 {
+				b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, m.Params[1], fReset, b.localize("kickReason", m.Prefix.Name, m.Params[2])), replyID(ch.Discord), false)
+			} else {
+				b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, m.Params[1], fReset, b.localize("kick", m.Prefix.Name)), replyID(ch.Discord), false)
+			}
+		}
+	case "INVITE":
+		// received for invites of other users with invite-notify
+		if len(m.Params) < 2 {
+			return
+		}
+		for _, ch := range b.ircChannels(m.Params[1]) {
+			if ch.relayToDiscord() {
+				b.discordSendFrom(msgID, ch.Discord, fmt.Sprintf("%c%s%c %s", fItalics, m.Params[0], fReset, b.localize("ircInvite", m.Prefix.Name)), "", false)
+			}
+		}
+	case "QUIT":
+		if len(m.Params) > 0 && b.netsplitHold(m.Prefix.Name, m.Params[0], msgID) {
+			return
+		}
+		b.ircQuit(m.Prefix.Name, m.Params, msgID)
+	case "REDACT":
+		if len(m.Params) < 2 || !b.ircPrivileged(m) {
+			return
+		}
+		for _, ch := range b.ircChannels(m.Params[0]) {
+			if !ch.relayToDiscord() {
+				continue
+			}
+			for _, id := range b.discordChannelIDs(m.Params[1], ch.Discord) {
+				b.discord.ChannelMessageDelete(ch.Discord, id)
+			}
+			b.webhookPost(&Event{
+				Event:          "delete",
+				Source:         "irc",
+				DiscordChannel: ch.Discord,
+				IRCChannel:     m.Params[0],
+				Author:         m.Prefix.Name,
+				IRCID:          m.Params[1],
+			})
+		}
+	case "TAGMSG":
+		if string(m.Tags["+typing"]) != "active" {
+			return
+		}
+		for _, ch := range b.ircChannels(m.Params[0]) {
+			if ch.relayToDiscord() {
+				b.discord.ChannelTyping(ch.Discord)
+			}
+		}
+	case "PRIVMSG":
+		if m.Params[0] == c.CurrentNick() && b.adminCommand(c, m) {
+			return
+		}
+		b.ircPrivmsg(c, m)
+	case "NOTICE":
+		// intentionally not passed through, except server notices (e.g. netsplits, klines)
+		if b.cfg.ServerNotices == "" || m.User != "" || len(m.Params) < 2 || m.Params[0] != c.CurrentNick() {
+			return
+		}
+		// server notices repeat text of users, e.g. realnames and kill reasons
+		b.discordSendFrom("", b.cfg.ServerNotices, fmt.Sprintf("%c[%s]%c %s", fItalics, m.Prefix.Name, fReset, m.Params[1]), "", false)
+	case "WALLOPS":
+		if b.cfg.ServerNotices == "" || len(m.Params) < 1 {
+			return
+		}
+		b.discordSendFrom("", b.cfg.ServerNotices, fmt.Sprintf("%c[WALLOPS %s]%c %s", fItalics, m.Prefix.Name, fReset, m.Params[0]), "", false)
+	}
+}
+
+// ctcpRelayed returns whether CTCP messages with verb are relayed to Discord.
+func (b *Bridge) ctcpRelayed(verb string) bool {
+	for _, v := range b.cfg.CTCP {
+		if v == "*" || strings.EqualFold(v, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// ircQuit relays the quit of nick with the QUIT params to Discord.
+func (b *Bridge) ircQuit(nick string, params []string, msgID string) {
+	for dc, chs := range b.mappings() {
+		if !relayToDiscord(chs) || b.digestHold(dc, nick, false) {
+			continue
+		}
+		if len(params) > 0 {
+			b.discordSendFrom(msgID, dc, fmt.Sprintf("%c%s%c %s", fItalics, nick, fReset, b.localize("quitReason", params[0])), "", false)
+		} else {
+			b.discordSendFrom(msgID, dc, fmt.Sprintf("%c%s%c %s", fItalics, nick, fReset, b.localize("quit")), "", false)
+		}
+	}
+}
+
+// ircReplyID returns the ID of the Discord message of Discord channel dc the IRC message m replies to, if any:
+// replies are to the Discord message relayed in the same channel.
+func (b *Bridge) ircReplyID(m *irc.Message, dc string) string {
+	if ids := b.discordChannelIDs(string(m.Tags["+draft/reply"]), dc); len(ids) > 0 {
+		return ids[len(ids)-1]
+	}
+	return ""
+}
+
+// ircPrivmsg relays the IRC message m sent to a channel.
+func (b *Bridge) ircPrivmsg(c ircConn, m *irc.Message) {
+	msgID := string(m.Tags["msgid"])
+	// STATUSMSG targets such as @#channel are only sent to the channel members with that status
+	ic := strings.TrimLeft(m.Params[0], b.ircStatusMsg)
+	statusMsg := m.Params[0][:len(m.Params[0])-len(ic)]
+	chs := b.ircChannels(ic)
+	if len(chs) == 0 {
+		return
+	}
+	b.readMarkerSet(ic, string(m.Tags["time"]))
+	discordID := taggedDiscordID(m.Tags)
+	if m.Name == c.CurrentNick() {
+		if discordID != "" {
+			b.correlateIRCChannel(msgID, ic)
+			b.correlateText(msgID, m.Trailing())
+			b.correlate(msgID, discordID)
+			for _, id := range b.coalescedIDs(discordID, ic) {
+				b.correlate(msgID, id)
+			}
+			b.traceEchoFinish(discordID)
+		}
+		return
+	}
+	if discordID != "" && len(b.ircIDs(discordID)) > 0 {
+		// prevent loops: another bridge relayed a Discord message this bridge relayed too
+		return
+	}
+	if b.optOutIRC(m.Name) {
+		return
+	}
+	release := b.ircQueue.accept(msgID, queueEntry{
+		Received: m.String(),
+	})
+	defer release()
+	if msgID != "" {
+		b.correlateIRCChannel(msgID, ic)
+	}
+	b.traceRelayStart(msgID, "irc.receive").set("irc.channel", ic)
+	forwarded := map[string]bool{ic: true}
+	for _, ch := range chs {
+		if !ch.relayToDiscord() {
+			continue
+		}
+		b.ircRelay(c, m, ch.Discord, ic, statusMsg, msgID, b.ircReplyID(m, ch.Discord))
+		if statusMsg != "" {
+			continue
+		}
+		// the IRC channels bridged to the same Discord channel are relayed to each other
+		for _, o := range b.mappings()[ch.Discord] {
+			if !forwarded[o.IRC] && o.relayToIRC() {
+				forwarded[o.IRC] = true
+				b.ircForward(m, ic, o.IRC)
+			}
+		}
+	}
+	b.traceRelayFinish(msgID)
+}
+
+// ircForward relays the IRC message m of IRC channel ic to IRC channel target, bridged to the same Discord channel.
+// Its echo is ignored as any message of the bridge, which prevents loops.
+func (b *Bridge) ircForward(m *irc.Message, ic string, target string) {
+	body := m.Params[1]
+	if body[0] == '\x01' {
+		verb, data, _ := strings.Cut(strings.Trim(body[1:], "\x01"), " ")
+		if !strings.EqualFold(verb, "ACTION") {
+			return
+		}
+		body = fmt.Sprintf("* %s", data)
+	}
+	line := fmt.Sprintf("%c<[%s] %s>%c %s", fBold, ic, b.antiPing(m.Prefix.Name), fReset, body)
+	if len(line) > b.cfg.MaxLineLength {
+		line = truncateLine(line, " …", b.cfg.MaxLineLength)
+	}
+	b.ircWrite(&irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{target, line},
+	})
+}
+
+// ircRelay relays an IRC message of IRC channel ic to Discord channel dc.
+func (b *Bridge) ircRelay(c ircConn, m *irc.Message, dc string, ic string, statusMsg string, msgID string, replyID string) {
+	body := m.Params[1]
+	if replyID != "" {
+		body = strings.TrimPrefix(body, fmt.Sprintf("%s: ", c.CurrentNick()))
+	} else if b.cfg.NickReplies {
+		replyID = b.recentMessage(dc, body)
+	}
+	if body[0] == '\x01' {
+		body = strings.Trim(body[1:], "\x01")
+		verb, data, _ := strings.Cut(body, " ")
+		verb = strings.ToUpper(verb)
+		if verb == "ACTION" {
+			// a CTCP ACTION is sent as an italicized message
+			body = fmt.Sprintf("%c%s", fItalics, data)
+		} else if b.ctcpRelayed(verb) {
+			body = strings.TrimSuffix(fmt.Sprintf("[CTCP %s] %s", verb, data), " ")
+		} else {
+			// drop unknown CTCP
+			return
+		}
+	}
+	if statusMsg != "" && b.cfg.MarkStatusMsg {
+		body = fmt.Sprintf("%c[%s]%c %s", fItalics, b.localize("statusMsg", statusMsg), fReset, body)
+	}
+	account := b.ircAccount(m.Prefix.Name)
+	name := b.ircName(m.Prefix.Name, account)
+	roles := b.ircPrivileged(m)
+	if !b.floodAllow("irc "+dc+" "+ic+" "+b.ircIdentity(m.Prefix.Name), func(dropped int) {
+		b.discordSend("", dc, fmt.Sprintf("%c<%s>%c %s", fBold, name, fReset, b.floodSummary(dropped)), "")
+	}) {
+		return
+	}
+	posted := func(dm *discordgo.Message, id string, content string) {
+		if dm == nil {
+			return
+		}
+		b.webhookPost(&Event{
+			Event:          "message",
+			Source:         "irc",
+			DiscordChannel: dc,
+			IRCChannel:     ic,
+			Author:         m.Prefix.Name,
+			Account:        account,
+			Content:        content,
+			DiscordID:      dm.ID,
+			IRCID:          id,
+		})
+	}
+	art := false
+	for _, ch := range b.mappings()[dc] {
+		if ch.IRC == ic {
+			art = ch.Art
+		}
+	}
+	plain := b.discordPlain(dc)
+	if art && !plain && replyID == "" && artColorful(body) {
+		b.artRelay(dc, m.Prefix.Name, name, msgID, body, posted)
+		return
+	}
+	if lines := strings.Split(body, "\n"); replyID == "" && !plain && pasteCode(lines) {
+		// a multiline message
+		b.coalesceFlush("discord " + dc)
+		dms := b.pasteRelay(dc, name, append([]string{msgID}, make([]string, len(lines)-1)...), lines)
+		posted(dms[0], msgID, body)
+		return
+	}
+	media := b.mediaLink(body)
+	if b.cfg.Coalesce > 0 && replyID == "" && !media {
+		// consecutive messages are sent as a single multiline Discord message
+		b.coalesce("discord "+dc, b.ircIdentity(m.Prefix.Name), msgID, body, func(ids []string, lines []string) {
+			if !plain && pasteCode(lines) {
+				for i, dm := range b.pasteRelay(dc, name, ids, lines) {
+					posted(dm, ids[i], lines[i])
+				}
+				return
+			}
+			dm := b.discordSendFrom(ids[0], dc, fmt.Sprintf("%c<%s>%c %s", fBold, name, fReset, strings.Join(lines, "\n")), "", roles)
+			for i, id := range ids {
+				if dm != nil && i > 0 && id != "" {
+					b.correlate(id, dm.ID)
+				}
+				posted(dm, id, lines[i])
+			}
+		})
+		return
+	}
+	b.coalesceFlush("discord " + dc)
+	var dm *discordgo.Message
+	if media {
+		// send image link in its own message so that it can be embedded by discord
+		b.discordSend("", dc, fmt.Sprintf("%c<%s>", fBold, name), replyID)
+		dm = b.discordSendFrom(msgID, dc, body, replyID, roles)
+	} else {
+		dm = b.discordSendFrom(msgID, dc, fmt.Sprintf("%c<%s>%c %s", fBold, name, fReset, body), replyID, roles)
+	}
+	posted(dm, msgID, m.Params[1])
+}
+
+var replacerNewline = strings.NewReplacer(
+	"\r\n", " ",
+	"\n", " ",
+	"\r", " ",
+)
+
+var replacerLineBreak = strings.NewReplacer(
+	"\r\n", "\n",
+	"\r", "\n",
+)
+
+// quotePrefix starts the quoted lines of Discord messages formatted for IRC.
+const quotePrefix = string(fColor) + "14> "
+
+var discordParser = formatting.NewParser(nil)
+
+func (b *Bridge) discordIRCFormat(s discordSession, guildID string, m string) string {
+	ast := discordParser.Parse(m)
+	var sb strings.Builder
+	// quoted lines are each prefixed with "> " and grayed out; the trailing newline of a quote
+	// is held back so that it is written after the color is reset
+	quote := false
+	quoteNewline := false
+	formatting.Walk(ast, func(nn formatting.Node, entering bool) {
+		switch n := nn.(type) {
+		case *formatting.TextNode:
+			if !entering {
+				break
+			}
+			if !quote {
+				sb.WriteString(n.Content)
+				break
+			}
+			for _, line := range strings.SplitAfter(n.Content, "\n") {
+				if line == "" {
+					continue
+				}
+				if quoteNewline {
+					sb.WriteByte(fColor)
+					sb.WriteString("\n" + quotePrefix)
+					quoteNewline = false
+				}
+				if strings.HasSuffix(line, "\n") {
+					line = line[:len(line)-1]
+					quoteNewline = true
+				}
+				sb.WriteString(line)
+			}
+		case *formatting.BlockQuoteNode:
+			if entering {
+				quote = true
+				quoteNewline = false
+				sb.WriteString(quotePrefix)
+			} else {
+				quote = false
+				sb.WriteByte(fColor)
+				if quoteNewline {
+					sb.WriteString("\n")
+				}
+			}
+		case *formatting.CodeNode:
+			if entering {
+				sb.WriteByte(fMonospace)
+				sb.WriteString("`")
+				if n.Language != "" {
+					sb.WriteString(n.Language)
+					sb.WriteString(" ")
+				}
+				sb.WriteString(n.Content)
+				sb.WriteString("`")
+				sb.WriteByte(fMonospace)
+			}
+		case *formatting.SpoilerNode:
+			if entering {
+				sb.WriteByte(fReverse)
+				sb.WriteString("||")
+			} else {
+				sb.WriteString("||")
+				sb.WriteByte(fReverse)
+			}
+		case *formatting.URLNode:
+			if entering {
+				sb.WriteString(n.URL)
+			}
+		case *formatting.EmojiNode:
+			if entering {
+				sb.WriteString(":")
+				sb.WriteString(n.Text)
+				sb.WriteString(":")
+			}
+		case *formatting.ChannelMentionNode:
+			if entering {
+				if channel, err := s.state().Channel(n.ID); err == nil && len(b.mappings()[n.ID]) == 0 && !discordPublic(s, channel) {
+					sb.WriteString(privateChannel)
+				} else if err == nil {
+					sb.WriteString("#")
+					sb.WriteString(channel.Name)
+				} else {
+					sb.WriteString("#invalid-channel")
+				}
+			}
+		case *formatting.RoleMentionNode:
+			if entering {
+				if role, err := s.state().Role(guildID, n.ID); err == nil {
+					sb.WriteString("@")
+					sb.WriteString(role.Name)
+				} else {
+					sb.WriteString("@invalid-role")
+				}
+			}
+		case *formatting.UserMentionNode:
+			if entering {
+				if i := b.identityDiscord(n.ID); i != nil {
+					// highlight the user on IRC
+					sb.WriteString("@")
+					sb.WriteString(i.ircNick())
+				} else if member, err := s.state().Member(guildID, n.ID); err == nil {
+					sb.WriteString("@")
+					sb.WriteString(b.displayName(member, member.User))
+				} else {
+					sb.WriteString("@invalid-user")
+				}
+			}
+		case *formatting.SpecialMentionNode:
+			if entering {
+				sb.WriteString("@")
+				sb.WriteString(n.Mention)
+			}
+		case *formatting.TimestampNode:
+			if entering {
+				unix, err := strconv.ParseInt(n.Stamp, 10, 64)
+				if err != nil {
+					sb.WriteString("<invalid-timestamp>")
+					break
+				}
+				t := time.Unix(unix, 0).In(b.timestampLocation)
+				if layout, ok := b.timestampLayouts[n.Format]; ok {
+					sb.WriteString(t.Format(layout))
+					break
+				}
+				switch n.Format {
+				case "R":
+					d := time.Now().Sub(t)
+					if d > 0 {
+						sb.WriteString(d.String())
+						sb.WriteString(" ago")
+					} else {
+						sb.WriteString("in ")
+						sb.WriteString(d.String())
+					}
+				default:
+					sb.WriteString("<invalid-timestamp>")
+				}
+			}
+		case *formatting.BoldNode:
+			sb.WriteByte(fBold)
+		case *formatting.UnderlineNode:
+			sb.WriteByte(fUnderline)
+		case *formatting.ItalicsNode:
+			sb.WriteByte(fItalics)
+		case *formatting.StrikethroughNode:
+			sb.WriteByte(fStrikethrough)
+		}
+	})
+	return sb.String()
+}
+
+func (b *Bridge) discordReady(s discordSession, m *discordgo.Ready) {
+	b.gatewayReady(s)
+	appID := s.state().User.ID
+	if m.Application != nil {
+		appID = m.Application.ID
+	}
+	go b.emojiSync(s, appID)
+	go b.discordReplay(s)
+}
+
+// discordReplay relays the messages received from Discord before a restart, and the messages to Discord
+// queued before a restart or not sent while disconnected.
+func (b *Bridge) discordReplay(s discordSession) {
+	for _, e := range b.discordQueue.takeReplay() {
+		if e.Message != nil {
+			if e.Message.Author != nil {
+				b.discordMessage(s, &discordgo.MessageCreate{Message: e.Message})
+			}
+		} else {
+			b.discordPost(e.Origin, e.Channel, e.Content, e.ReplyID, !e.NoRoles)
+		}
+		b.discordQueue.done(e.ID)
+	}
+}
+
+func (b *Bridge) discordMessage(s discordSession, m *discordgo.MessageCreate) {
+	defer b.sentryRecover()
+	if m.Author.ID == s.state().User.ID {
+		return
+	}
+	chs := b.discordMappings(s, m.ChannelID)
+	if len(chs) == 0 {
+		return
+	}
+	if b.optOutDiscord(m.Author.ID) {
+		return
+	}
+	b.gatewaySeen(m.Message)
+	release := b.discordQueue.accept(m.ID, queueEntry{
+		Message: m.Message,
+	})
+	defer release()
+	b.correlateChannel(m.ID, m.ChannelID)
+	b.traceRelayStart(m.ID, "discord.receive").set("discord.channel", m.ChannelID)
+	defer b.traceRelayFinish(m.ID)
+	if m.Member != nil {
+		member := *m.Member
+		member.User = m.Author
+		b.memberSeen(s, m.GuildID, &member)
+	}
+	b.membersMentioned(s, m.Message)
+	// also relay to the other Discord channels bridged to the same IRC channels
+	forwarded := map[string]bool{
+		m.ChannelID: true,
+	}
+	for _, ch := range chs {
+		// for threads, the parent channel
+		forwarded[ch.Discord] = true
+	}
+	for _, ch := range chs {
+		if !ch.relayMessageToIRC(s, m.Message) {
+			continue
+		}
+		var forward []string
+		for _, o := range b.ircChannels(ch.IRC) {
+			if forwarded[o.Discord] || !o.relayToDiscord() {
+				continue
+			}
+			forwarded[o.Discord] = true
+			forward = append(forward, o.Discord)
+		}
+		if ch.Delay > 0 || ch.EditGrace > 0 {
+			b.holdRelay(s, m, ch, forward)
+		} else {
+			b.discordRelayForward(s, m, ch, forward)
+		}
+	}
+}
+
+// discordRelayForward relays a Discord message to the IRC channel of mapping ch, and forwards it to
+// the Discord channels of forward.
+func (b *Bridge) discordRelayForward(s discordSession, m *discordgo.MessageCreate, ch *Channel, forward []string) {
+	b.discordRelay(s, m, ch)
+	for _, dc := range forward {
+		b.discordForward(s, m, ch, dc)
+	}
+}
+
+// discordForward relays a Discord message of the mapping ch to another Discord channel dc
+// bridged to the same IRC channel, labeled with its origin.
+func (b *Bridge) discordForward(s discordSession, m *discordgo.MessageCreate, ch *Channel, dc string) {
+	var lines []string
+	if m.Content != "" {
+		lines = append(lines, b.discordIRCFormat(s, m.GuildID, m.Content))
+	}
+	for _, a := range m.Attachments {
+		lines = append(lines, a.URL)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	name := b.antiPing(b.displayName(m.Member, m.Author))
+	// role mentions were turned into names, which must not mention the roles of the same names in dc
+	b.discordSendFrom("", dc, fmt.Sprintf("%c<[%s] %s>%c %s", fBold, ch.label(s, m.GuildID), name, fReset, strings.Join(lines, "\n")), "", false)
+}
+
+// ircBody returns the text relayed to IRC for the content of a Discord message of guildID:
+// a single line, unless it has quotes, whose lines are kept on their own lines.
+func (b *Bridge) ircBody(s discordSession, guildID string, content string) string {
+	body := b.discordIRCFormat(s, guildID, content)
+	body = quoteLines(body)
+	body = b.discordInvites(s, body)
+	body = b.discordChannelLinks(s, body)
+	return body
+}
+
+// quoteLines joins the lines of text formatted by discordIRCFormat with spaces, except its quoted lines.
+func quoteLines(text string) string {
+	var lines []string
+	quoted := false
+	for _, line := range strings.Split(replacerLineBreak.Replace(text), "\n") {
+		q := strings.HasPrefix(line, quotePrefix)
+		if len(lines) == 0 || q || quoted {
+			lines = append(lines, line)
+		} else if lines[len(lines)-1] == "" {
+			lines[len(lines)-1] = line
+		} else {
+			lines[len(lines)-1] += " " + line
+		}
+		quoted = q
+	}
+	n := 0
+	for _, line := range lines {
+		if line != "" {
+			lines[n] = line
+			n++
+		}
+	}
+	return strings.Join(lines[:n], "\n")
+}
+
+// ircPrefix returns the prefix of the lines relaying the Discord message m to the IRC channel of mapping ch,
+// with the nick of its author.
+func (b *Bridge) ircPrefix(s discordSession, m *discordgo.Message, ch *Channel) string {
+	color := b.nickColor(m)
+	nick := b.displayName(m.Member, m.Author)
+	if p := b.antiPing(nick); ch.plainToIRC() && strings.ContainsRune(p, '\u200B') {
+		// zero-width spaces are stripped from plain text
+		nick += "[d]"
+	} else {
+		nick = p
+	}
+	status := b.rolePrefix(m.GuildID, m.Member)
+	var prefix string
+	if color != "" {
+		prefix = fmt.Sprintf("<%s%s%s%c> ", status, color, nick, fReset)
+	} else {
+		prefix = fmt.Sprintf("<%s%s> ", status, nick)
+	}
+	if ch.bots(m) == botsMark {
+		prefix = "[bot] " + prefix
+	}
+	if !ch.roleAllowed(s, m) {
+		prefix = ch.RoleMarker + " " + prefix
+	}
+	if ch.thread != "" {
+		prefix = fmt.Sprintf("[%s] %s", ch.thread, prefix)
+	}
+	if len(b.ircChannels(ch.IRC)) > 1 {
+		prefix = fmt.Sprintf("[%s] %s", ch.label(s, m.GuildID), prefix)
+	}
+	return prefix
+}
+
+// discordRelay relays a Discord message to the IRC channel of mapping ch.
+func (b *Bridge) discordRelay(s discordSession, m *discordgo.MessageCreate, ch *Channel) {
+	ic := ch.IRC
+	replyID := ""
+	if m.MessageReference != nil && m.MessageReference.Type == discordgo.MessageReferenceTypeDefault {
+		replyID = b.ircReplyTo(m.MessageReference.MessageID, ic)
+	}
+
+	prefix := b.ircPrefix(s, m.Message, ch)
+	relay := func(text string) {
+		b.coalesceFlush("irc " + ic)
+		line := prefix + text
+		if len(line) > b.cfg.MaxLineLength {
+			line = truncateLine(line, " … <"+discordMessageURL(m.GuildID, m.ChannelID, m.ID)+">", b.cfg.MaxLineLength)
+		}
+		tags := irc.Tags{}
+		if replyID != "" {
+			tags["+draft/reply"] = irc.TagValue(replyID)
+		}
+		if b.cfg.MessageTags != messageTagsURL {
+			tags["+discord"] = irc.TagValue(m.ID)
+		}
+		if b.cfg.MessageTags != messageTagsID {
+			tags["+discord-url"] = irc.TagValue(discordMessageURL(m.GuildID, m.ChannelID, m.ID))
+		}
+		b.ircWrite(&irc.Message{
+			Tags:    tags,
+			Command: "PRIVMSG",
+			Params:  []string{ic, line},
+		})
+	}
+
+	if !b.floodAllow("discord "+m.ChannelID+" "+ic+" "+m.Author.ID, func(dropped int) {
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, prefix + b.floodSummary(dropped)},
+		})
+	}) {
+		return
+	}
+
+	if m.Type == discordgo.MessageTypeReply && (b.cfg.ReplyExcerpts == replyExcerptsAlways || b.cfg.ReplyExcerpts == replyExcerptsUnbridged && replyID == "") {
+		if excerpt := b.replyExcerpt(s, m.Message); excerpt != "" {
+			relay(excerpt)
+		}
+	}
+
+	if b.cfg.NickReplies {
+		b.recentMessagesLock.Lock()
+		recent := b.recentMessages[m.ChannelID]
+		if recent == nil {
+			recent = make(map[string]string)
+			b.recentMessages[m.ChannelID] = recent
+		}
+		recent[strings.ToLower(m.Author.Username)] = m.ID
+		if m.Author.GlobalName != "" {
+			recent[strings.ToLower(m.Author.GlobalName)] = m.ID
+		}
+		if m.Member != nil && m.Member.Nick != "" {
+			recent[strings.ToLower(m.Member.Nick)] = m.ID
+		}
+		b.recentMessagesLock.Unlock()
+	}
+
+	// only plain messages are merged with the following ones
+	plain := replyID == "" && m.MessageReference == nil && len(m.Attachments) == 0 && len(m.Embeds) == 0 && len(m.Components) == 0
+	var attachments []string
+	for _, attachment := range m.Attachments {
+		if text := b.channelAttachment(ch, attachment); text != "" {
+			attachments = append(attachments, text)
+		}
+	}
+	if len(m.Content) > 0 {
+		ts := b.traceRelay(m.ID).child("irc.transform")
+		body := b.ircBody(s, m.GuildID, m.Content)
+		ts.finish()
+		b.editSeen(m.ID, ic, body)
+		// quoted lines are relayed on their own lines
+		lines := strings.Split(body, "\n")
+		body = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			relay(line)
+		}
+		if ch.Attachments.Inline {
+			// the attachments not fitting on the line of the content are relayed on their own lines
+			for len(attachments) > 0 && len(prefix)+len(body)+1+len(attachments[0]) <= b.cfg.MaxLineLength {
+				body += " " + attachments[0]
+				attachments = attachments[1:]
+			}
+		}
+		if b.cfg.Coalesce > 0 && plain && len(lines) == 1 {
+			b.coalesce("irc "+ic, m.Author.ID, m.ID, body, func(ids []string, lines []string) {
+				if len(ids) > 1 {
+					b.correlateCoalesced(ids[0], ic, ids[1:])
+				}
+				relay(strings.Join(lines, " | "))
+			})
+		} else {
+			relay(body)
+		}
+	}
+	for _, text := range attachments {
+		relay(text)
+	}
+	if m.Author.Bot || m.WebhookID != "" {
+		// embeds of messages from users are link previews
+		for _, embed := range m.Embeds {
+			if line := b.discordEmbed(s, m.GuildID, embed); line != "" {
+				relay(line)
+			}
+		}
+	}
+	for _, line := range b.discordComponents(s, m.GuildID, m.Components) {
+		relay(line)
+	}
+	for _, snapshot := range m.MessageSnapshots {
+		if snapshot.Message == nil {
+			continue
+		}
+		forward := fmt.Sprintf("%c[%s]%c ", fItalics, b.localize("forwarded"), fReset)
+		if m.MessageReference != nil {
+			if c, err := s.state().Channel(m.MessageReference.ChannelID); err == nil {
+				forward = fmt.Sprintf("%c[%s]%c ", fItalics, b.localize("forwardedFrom", c.Name), fReset)
+			}
+		}
+		if len(snapshot.Message.Content) > 0 {
+			body := b.discordIRCFormat(s, m.GuildID, snapshot.Message.Content)
+			body = replacerNewline.Replace(body)
+			body = b.discordInvites(s, body)
+			relay(forward + body)
+		}
+		for _, attachment := range snapshot.Message.Attachments {
+			if text := b.channelAttachment(ch, attachment); text != "" {
+				relay(forward + text)
+			}
+		}
+	}
+	b.webhookPost(&Event{
+		Event:          "message",
+		Source:         "discord",
+		DiscordChannel: m.ChannelID,
+		IRCChannel:     ic,
+		Author:         m.Author.Username,
+		Content:        m.Content,
+		DiscordID:      m.ID,
+	})
+}
+
+// taggedDiscordID returns the ID of the Discord message a message relayed to IRC comes from.
+func taggedDiscordID(tags irc.Tags) string {
+	if id := string(tags["+discord"]); id != "" {
+		return id
+	}
+	if url := string(tags["+discord-url"]); url != "" {
+		return url[strings.LastIndexByte(url, '/')+1:]
+	}
+	return ""
+}
+
+func discordMessageURL(guildID string, channelID string, messageID string) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// truncateLine cuts line at a word boundary so that it fits in length bytes once suffix is appended.
+func truncateLine(line string, suffix string, length int) string {
+	n := length - len(suffix) - 1
+	if n <= 0 {
+		return suffix
+	}
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	if i := strings.LastIndexByte(line[:n], ' '); i > n*3/4 {
+		n = i
+	}
+	return line[:n] + string([]byte{fReset}) + suffix
+}
+
+// discordComponents renders message components (buttons, select menus, text) as IRC lines.
+func (b *Bridge) discordComponents(s discordSession, guildID string, components []discordgo.MessageComponent) []string {
+	var lines []string
+	for _, component := range components {
+		switch c := component.(type) {
+		case *discordgo.ActionsRow:
+			var buttons []string
+			for _, component := range c.Components {
+				switch c := component.(type) {
+				case *discordgo.Button:
+					label := c.Label
+					if label == "" && c.Emoji != nil {
+						label = c.Emoji.Name
+					}
+					if c.URL != "" {
+						label = fmt.Sprintf("%s <%s>", label, c.URL)
+					}
+					buttons = append(buttons, label)
+				case *discordgo.SelectMenu:
+					var options []string
+					for _, option := range c.Options {
+						options = append(options, option.Label)
+					}
+					menu := c.Placeholder
+					if len(options) > 0 {
+						if menu != "" {
+							menu += ": "
+						}
+						menu += strings.Join(options, " | ")
+					}
+					lines = append(lines, fmt.Sprintf("%c[%s]%c", fItalics, b.localize("menu", menu), fReset))
+				}
+			}
+			if len(buttons) > 0 {
+				lines = append(lines, fmt.Sprintf("%c[%s]%c", fItalics, b.localize("buttons", strings.Join(buttons, " | ")), fReset))
+			}
+		case *discordgo.TextDisplay:
+			lines = append(lines, replacerNewline.Replace(b.discordIRCFormat(s, guildID, c.Content)))
+		case *discordgo.Section:
+			lines = append(lines, b.discordComponents(s, guildID, c.Components)...)
+		case *discordgo.Container:
+			lines = append(lines, b.discordComponents(s, guildID, c.Components)...)
+		}
+	}
+	return lines
+}
+
+// ircColor returns the IRC formatting code for a color set in the config.
+func ircColor(color string) (string, error) {
+	if strings.HasPrefix(color, "#") {
+		if _, err := strconv.ParseUint(color[1:], 16, 24); err != nil || len(color) != 7 {
+			return "", fmt.Errorf("invalid hex color: %q", color)
+		}
+		return fmt.Sprintf("%c%s", fColorHex, strings.ToUpper(color[1:])), nil
+	}
+	code, err := strconv.Atoi(color)
+	if err != nil || code < 0 || code > 98 {
+		return "", fmt.Errorf("invalid color number: %q", color)
+	}
+	return fmt.Sprintf("%c%02d", fColor, code), nil
+}
+
+// nickColor returns the IRC formatting code for the color of the author of m, or an empty string.
+func (b *Bridge) nickColor(m *discordgo.Message) string {
+	if b.cfg.Colors.Disabled {
+		return ""
+	}
+	if color, ok := b.cfg.Colors.Users[m.Author.ID]; ok {
+		return color
+	}
+	colorCode := b.discord.state().MessageColor(m)
+	if colorCode == 0 {
+		colorCode = m.Author.AccentColor
+	}
+	if colorCode != 0 {
+		return fmt.Sprintf("%c%06X", fColorHex, colorCode)
+	}
+	h := fnv.New32()
+	_, _ = h.Write([]byte(m.Author.Username))
+	colorCode = b.validColors[h.Sum32()%uint32(len(b.validColors))]
+	return fmt.Sprintf("%c%02d", fColor, colorCode)
+}
+
+// statusPrefixes lists IRC status prefixes by decreasing rank
+const statusPrefixes = "~&@%+"
+
+// rolePrefix returns the highest ranking IRC status prefix configured for the roles of member.
+func (b *Bridge) rolePrefix(guildID string, member *discordgo.Member) string {
+	if len(b.cfg.RolePrefixes) == 0 || member == nil {
+		return ""
+	}
+	best := ""
+	for _, id := range member.Roles {
+		prefix, ok := b.cfg.RolePrefixes[id]
+		if !ok {
+			role, err := b.discord.state().Role(guildID, id)
+			if err != nil {
+				continue
+			}
+			if prefix, ok = b.cfg.RolePrefixes[role.Name]; !ok {
+				continue
+			}
+		}
+		if best == "" || statusRank(prefix) < statusRank(best) {
+			best = prefix
+		}
+	}
+	return best
+}
+
+func statusRank(prefix string) int {
+	if i := strings.Index(statusPrefixes, prefix); i >= 0 {
+		return i
+	}
+	return len(statusPrefixes)
+}
+
+const replyExcerptLength = 80
+
+// replyExcerpt returns a short line quoting the message m replies to.
+func (b *Bridge) replyExcerpt(s discordSession, m *discordgo.Message) string {
+	parent := m.ReferencedMessage
+	if parent == nil {
+		var err error
+		parent, err = s.state().Message(m.MessageReference.ChannelID, m.MessageReference.MessageID)
+		if err != nil {
+			parent, err = s.ChannelMessage(m.MessageReference.ChannelID, m.MessageReference.MessageID)
+			if err != nil {
+				return ""
+			}
+		}
+	}
+	if parent.Author == nil {
+		return ""
+	}
+	content := parent.Content
+	if content == "" && len(parent.Attachments) > 0 {
+		content = parent.Attachments[0].URL
+	}
+	excerpt := truncate(replacerNewline.Replace(b.discordIRCFormat(s, m.GuildID, content)), replyExcerptLength)
+	if parent.Author.ID != s.state().User.ID {
+		// messages relayed from IRC already start with the IRC nick
+		excerpt = b.antiPing(b.discordName(m.GuildID, parent.Author)) + ": " + excerpt
+	}
+	return fmt.Sprintf("%c↩ %s%c", fItalics, excerpt, fReset)
+}
+
+// discordName returns the name of user u as displayed in guild guildID.
+func (b *Bridge) discordName(guildID string, u *discordgo.User) string {
+	member, _ := b.discord.state().Member(guildID, u.ID)
+	return b.displayName(member, u)
+}
+
+// displayName returns the first available name of user u, which is member in a guild, in the configured order.
+func (b *Bridge) displayName(member *discordgo.Member, u *discordgo.User) string {
+	for _, name := range b.cfg.NameOrder {
+		switch name {
+		case "nick":
+			if member != nil && member.Nick != "" {
+				return member.Nick
+			}
+		case "global":
+			if u.GlobalName != "" {
+				return u.GlobalName
+			}
+		case "username":
+			return u.Username
+		}
+	}
+	return u.Username
+}
+
+var patternInvite = regexp.MustCompile("(?:https?://)?(?:www\\.)?(?:discord\\.gg|discord(?:app)?\\.com/invite)/([\\w-]+)")
+
+// discordInvites appends the guild and channel Discord invite links of msg lead to.
+func (b *Bridge) discordInvites(s discordSession, msg string) string {
+	return regexReplaceAll(patternInvite, msg, func(groups []int) string {
+		original := msg[groups[0]:groups[1]]
+		code := msg[groups[2]:groups[3]]
+		b.invitesLock.Lock()
+		desc, ok := b.invites[code]
+		b.invitesLock.Unlock()
+		if !ok {
+			if invite, err := s.Invite(code); err == nil && invite.Guild != nil {
+				desc = invite.Guild.Name
+				if invite.Channel != nil {
+					desc += " #" + invite.Channel.Name
+				}
+			}
+			b.invitesLock.Lock()
+			if len(b.invites) >= 1024 {
+				b.invites = make(map[string]string)
+			}
+			b.invites[code] = desc
+			b.invitesLock.Unlock()
+		}
+		if desc == "" {
+			return original
+		}
+		return fmt.Sprintf("%s (%s)", original, b.localize("invite", desc))
+	})
+}
+
+var patternChannelLink = regexp.MustCompile("https://(?:(?:ptb|canary)\\.)?discord(?:app)?\\.com/channels/(\\d+)/(\\d+)(?:/(\\d+))?\\b")
+
+// privateChannel replaces the names of the Discord channels hidden from @everyone on IRC.
+const privateChannel = "#private-channel"
+
+// discordChannelLinks rewrites the links to Discord channels of msg to the names of the channels,
+// as the IRC channel a channel is bridged to if any, keeping the link after the name for messages.
+func (b *Bridge) discordChannelLinks(s discordSession, msg string) string {
+	return regexReplaceAll(patternChannelLink, msg, func(groups []int) string {
+		original := msg[groups[0]:groups[1]]
+		dc := msg[groups[4]:groups[5]]
+		var name string
+		if chs := b.mappings()[dc]; len(chs) > 0 {
+			name = chs[0].IRC
+		} else if c, err := s.state().Channel(dc); err == nil && c.GuildID == msg[groups[2]:groups[3]] {
+			if discordPublic(s, c) {
+				name = "#" + c.Name
+			} else {
+				name = privateChannel
+			}
+		} else {
+			return original
+		}
+		if groups[6] < 0 {
+			return name
+		}
+		return fmt.Sprintf("%s (%s)", name, original)
+	})
+}
+
+// discordPublic returns whether @everyone can view Discord channel c, or the parent channel of thread c,
+// per the permission overwrites of the channel and the permissions of @everyone if known.
+func discordPublic(s discordSession, c *discordgo.Channel) bool {
+	if c.IsThread() {
+		parent, err := s.state().Channel(c.ParentID)
+		if err != nil {
+			return false
+		}
+		c = parent
+	}
+	if everyone, err := s.state().Role(c.GuildID, c.GuildID); err == nil && everyone.Permissions&discordgo.PermissionViewChannel == 0 {
+		return false
+	}
+	for _, o := range c.PermissionOverwrites {
+		if o.Type == discordgo.PermissionOverwriteTypeRole && o.ID == c.GuildID {
+			return o.Deny&discordgo.PermissionViewChannel == 0
+		}
+	}
+	return true
+}
+
+// antiPing changes nick so that IRC clients do not highlight users with the same nick.
+func (b *Bridge) antiPing(nick string) string {
+	if b.cfg.AntiPing == antiPingSuffix {
+		return nick + "[d]"
+	}
+	if utf8.RuneCountInString(nick) < 2 {
+		return nick
+	}
+	switch b.cfg.AntiPing {
+	case antiPingSwap:
+		r := []rune(nick)
+		r[0], r[len(r)-1] = r[len(r)-1], r[0]
+		if string(r) == nick {
+			// e.g. "anna": insert a zero-width space instead
+			return string(r[:1]) + "\u200B" + string(r[1:])
+		}
+		return string(r)
+	case antiPingNone:
+		return nick
+	default:
+		r, size := utf8.DecodeRuneInString(nick)
+		return string([]rune{r, '\u200B'}) + nick[size:]
+	}
+}
+
+func (b *Bridge) discordDelete(s discordSession, m *discordgo.MessageDelete) {
+	defer b.sentryRecover()
+	// Discord seems to omit the Author in message deletion notifications
+	if m.Author != nil && m.Author.ID == s.state().User.ID {
+		return
+	}
+	held := b.holdDelete(m.ID)
+	for _, ch := range b.discordMappings(s, m.ChannelID) {
+		if !ch.relayToIRC() || held[ch.IRC] {
+			// never relayed
+			continue
+		}
+		ids := b.ircChannelIDs(m.ID, ch.IRC)
+		for _, id := range ids {
+			b.ircWrite(&irc.Message{
+				Command: "REDACT",
+				Params:  []string{ch.IRC, id},
+			})
+		}
+		if texts := b.evictedTexts(m.ID, ch.IRC); len(ids) == 0 && len(texts) > 0 {
+			// relayed before the IDs were evicted
+			b.historyRedact(ch.IRC, m.ID, texts)
+		}
+		b.webhookPost(&Event{
+			Event:          "delete",
+			Source:         "discord",
+			DiscordChannel: m.ChannelID,
+			IRCChannel:     ch.IRC,
+			DiscordID:      m.ID,
+		})
+	}
+}
+
+// discordReactionAdd is a reaction add event, with fields not yet supported by discordgo
+type discordReactionAdd struct {
+	discordgo.MessageReaction
+	Burst bool `json:"burst"` // super reaction
+}
+
+// discordEvent handles raw Discord events, for events needing fields not supported by discordgo.
+func (b *Bridge) discordEvent(s discordSession, e *discordgo.Event) {
+	defer b.sentryRecover()
+	switch e.Type {
+	case "MESSAGE_REACTION_ADD":
+		var m discordReactionAdd
+		if err := json.Unmarshal(e.RawData, &m); err != nil {
+			logErr.Printf("failed decoding discord reaction: %v", err)
+			return
+		}
+		b.discordReact(s, &m)
+	}
+}
+
+func (b *Bridge) discordReact(s discordSession, m *discordReactionAdd) {
+	if m.UserID == s.state().User.ID || b.optOutDiscord(m.UserID) {
+		return
+	}
+	reaction := reactionEmoji(&m.Emoji)
+	if reaction == "" {
+		return
+	}
+	if m.Burst {
+		reaction += "(super)"
+	}
+	for _, ch := range b.discordMappings(s, m.ChannelID) {
+		if !ch.relayToIRC() {
+			continue
+		}
+		tags := irc.Tags{
+			"+draft/react": irc.TagValue(reaction),
+		}
+		// react to the relayed message so that clients count reactions per message
+		if id := b.ircReplyTo(m.MessageID, ch.IRC); id != "" {
+			tags["+draft/reply"] = irc.TagValue(id)
+		}
+		b.ircWrite(&irc.Message{
+			Tags:    tags,
+			Command: "TAGMSG",
+			Params:  []string{ch.IRC},
+		})
+	}
+}
+
+// reactionEmoji returns the text of a reaction emoji as displayed by IRC clients.
+func reactionEmoji(e *discordgo.Emoji) string {
+	if e.Name == "" {
+		return ""
+	}
+	if e.ID != "" {
+		// custom emoji
+		return ":" + e.Name + ":"
+	}
+	// unicode emoji: use the emoji presentation rather than the text presentation
+	return strings.ReplaceAll(e.Name, "\uFE0E", "\uFE0F")
+}
+
+func (b *Bridge) discordTyping(s discordSession, m *discordgo.TypingStart) {
+	if m.UserID == s.state().User.ID || b.optOutDiscord(m.UserID) {
+		return
+	}
+	for _, ch := range b.discordMappings(s, m.ChannelID) {
+		if !ch.relayToIRC() {
+			continue
+		}
+		b.ircWrite(&irc.Message{
+			Tags: irc.Tags{
+				"+typing": "active",
+			},
+			Command: "TAGMSG",
+			Params:  []string{ch.IRC},
+		})
+	}
+}
+
+func (b *Bridge) discordEventCreate(s discordSession, m *discordgo.GuildScheduledEventCreate) {
+	b.discordAnnounceEvent(s, m.GuildScheduledEvent, "scheduled")
+}
+
+func (b *Bridge) discordEventUpdate(s discordSession, m *discordgo.GuildScheduledEventUpdate) {
+	switch m.Status {
+	case discordgo.GuildScheduledEventStatusActive:
+		b.discordAnnounceEvent(s, m.GuildScheduledEvent, "started")
+	case discordgo.GuildScheduledEventStatusCompleted:
+		b.discordAnnounceEvent(s, m.GuildScheduledEvent, "ended")
+	case discordgo.GuildScheduledEventStatusCanceled:
+		b.discordAnnounceEvent(s, m.GuildScheduledEvent, "cancelled")
+	default:
+		b.discordAnnounceEvent(s, m.GuildScheduledEvent, "updated")
+	}
+}
+
+var eventMessages = map[string]string{
+	"scheduled": "eventScheduled",
+	"updated":   "eventUpdated",
+	"started":   "eventStarted",
+	"ended":     "eventEnded",
+	"cancelled": "eventCancelled",
+}
+
+func (b *Bridge) discordAnnounceEvent(s discordSession, e *discordgo.GuildScheduledEvent, action string) {
+	channels := b.cfg.EventChannels[e.GuildID]
+	if len(channels) == 0 {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📅 %s: %c%s%c", b.localize(eventMessages[action]), fBold, e.Name, fBold)
+	if action == "scheduled" || action == "updated" {
+		sb.WriteString(" — ")
+		sb.WriteString(e.ScheduledStartTime.In(b.timestampLocation).Format(b.timestampLayouts["F"]))
+	}
+	if e.ChannelID != "" {
+		if c, err := s.state().Channel(e.ChannelID); err == nil {
+			sb.WriteString(" — 🔊 ")
+			sb.WriteString(c.Name)
+		}
+	} else if e.EntityMetadata.Location != "" {
+		sb.WriteString(" — ")
+		sb.WriteString(e.EntityMetadata.Location)
+	}
+	text := replacerNewline.Replace(sb.String())
+	for _, ic := range channels {
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, text},
+		})
+	}
+}
+
+func (b *Bridge) discordConnect(s discordSession, m *discordgo.Connect) {
+	b.discordHealth.up()
+	b.webhookPost(&Event{
+		Event:  "connect",
+		Source: "discord",
+	})
+}
+
+func (b *Bridge) discordDisconnect(s discordSession, m *discordgo.Disconnect) {
+	b.discordHealth.down()
+	b.webhookPost(&Event{
+		Event:  "disconnect",
+		Source: "discord",
+	})
+	b.tokenCheck(s)
+}
+
+func regexReplaceAll(r *regexp.Regexp, s string, f func(s []int) string) string {
+	matches := r.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteString(s[:matches[0][0]])
+	for i, match := range matches {
+		sb.WriteString(f(match))
+		if i+1 < len(matches) {
+			sb.WriteString(s[matches[i][1]:matches[i+1][0]])
+		} else {
+			sb.WriteString(s[matches[i][1]:])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+// mappings returns the mappings of Discord channels, which change while the bridge runs.
+// The returned map must not be modified.
+func (b *Bridge) mappings() map[string]Channels {
+	b.channelsLock.RLock()
+	defer b.channelsLock.RUnlock()
+	return b.cfg.Channels
+}
+
+// mappingsUpdate replaces the mappings of Discord channels with a copy modified by f.
+func (b *Bridge) mappingsUpdate(f func(chs map[string]Channels)) {
+	b.channelsLock.Lock()
+	defer b.channelsLock.Unlock()
+	chs := make(map[string]Channels, len(b.cfg.Channels)+1)
+	for dc, dchs := range b.cfg.Channels {
+		chs[dc] = dchs
+	}
+	f(chs)
+	b.cfg.Channels = chs
+}
+
+// validateCategories checks the mappings of the categories, and fills in defaults.
+func (b *Bridge) validateCategories() error {
+	for category, ch := range b.cfg.Categories {
+		if ch == nil || ch.IRC == "" {
+			return fmt.Errorf("no irc channel for category %v", category)
+		}
+		if err := ch.validate(""); err != nil {
+			return fmt.Errorf("invalid category %v: %v", category, err)
+		}
+	}
+	return nil
+}
+
+// categoryTemplate returns the category key and mapping of the category of ID parentID named parentName, if any.
+func (b *Bridge) categoryTemplate(parentID string, parentName string) (string, *Channel) {
+	if parentID == "" {
+		return "", nil
+	}
+	if ch, ok := b.cfg.Categories[parentID]; ok {
+		return parentID, ch
+	}
+	for category, ch := range b.cfg.Categories {
+		if parentName != "" && strings.EqualFold(category, parentName) {
+			return category, ch
+		}
+	}
+	return "", nil
+}
+
+// categoryMapping returns the mapping of Discord channel c per the mapping of its category, or nil.
+func (b *Bridge) categoryMapping(s discordSession, c *discordgo.Channel, parentName string) *Channel {
+	if (c.Type != discordgo.ChannelTypeGuildText && c.Type != discordgo.ChannelTypeGuildNews) || !b.gatewayShard(c.GuildID) {
+		return nil
+	}
+	category, template := b.categoryTemplate(c.ParentID, parentName)
+	if template == nil || (!template.Private && !discordPublic(s, c)) {
+		return nil
+	}
+	ch := *template
+	ch.IRC = strings.ReplaceAll(template.IRC, "*", c.Name)
+	ch.Discord = c.ID
+	ch.category = category
+	return &ch
+}
+
+// categoryParentName returns the name of the parent category of Discord channel c, if known.
+func categoryParentName(s discordSession, c *discordgo.Channel) string {
+	if c.ParentID == "" {
+		return ""
+	}
+	parent, err := s.state().Channel(c.ParentID)
+	if err != nil {
+		return ""
+	}
+	return parent.Name
+}
+
+// categoryMap maps the channels of the mapped categories not mapped explicitly.
+// It runs at startup, before any channel is bridged.
+func (b *Bridge) categoryMap(s discordSession) error {
+	guilds, err := discordGuilds(s, nil)
+	if err != nil {
+		return err
+	}
+	for _, guildID := range guilds {
+		if !b.gatewayShard(guildID) {
+			continue
+		}
+		channels, err := s.GuildChannels(guildID)
+		if err != nil {
+			return fmt.Errorf("listing channels of discord guild %v: %v", guildID, err)
+		}
+		names := make(map[string]string)
+		for _, c := range channels {
+			if c.Type == discordgo.ChannelTypeGuildCategory {
+				names[c.ID] = c.Name
+			}
+		}
+		for _, c := range channels {
+			if _, ok := b.cfg.Channels[c.ID]; ok {
+				continue
+			}
+			if ch := b.categoryMapping(s, c, names[c.ParentID]); ch != nil {
+				if b.cfg.Channels == nil {
+					b.cfg.Channels = make(map[string]Channels)
+				}
+				b.cfg.Channels[c.ID] = Channels{ch}
+			}
+		}
+	}
+	return nil
+}
+
+// categoryAdd maps Discord channel c per the mapping of its category, if it is not mapped yet.
+func (b *Bridge) categoryAdd(s discordSession, c *discordgo.Channel) {
+	if _, ok := b.mappings()[c.ID]; ok {
+		return
+	}
+	ch := b.categoryMapping(s, c, categoryParentName(s, c))
+	if ch == nil {
+		return
+	}
+	b.mappingsUpdate(func(chs map[string]Channels) {
+		chs[c.ID] = Channels{ch}
+	})
+	logErr.Printf("mapped discord channel %v (#%v) of category %v to %v", c.ID, c.Name, ch.category, ch.IRC)
+	b.ircWrite(b.ircJoin(ch.IRC))
+}
+
+// categoryMapped returns whether Discord channel dc is mapped per the mapping of category.
+func (b *Bridge) categoryMapped(dc string, category string) bool {
+	chs := b.mappings()[dc]
+	return len(chs) > 0 && chs[0].category == category
+}
+
+// categoryRemove unmaps Discord channel dc if it was mapped per the mapping of its category,
+// leaving the IRC channels no longer bridged.
+func (b *Bridge) categoryRemove(dc string) {
+	chs := b.mappings()[dc]
+	if len(chs) == 0 || chs[0].category == "" {
+		return
+	}
+	b.mappingsUpdate(func(chs map[string]Channels) {
+		delete(chs, dc)
+	})
+	for _, ch := range chs {
+		if len(b.ircChannels(ch.IRC)) > 0 {
+			continue
+		}
+		b.ircWrite(&irc.Message{
+			Command: "PART",
+			Params:  []string{ch.IRC},
+		})
+	}
+}
+
+func (b *Bridge) discordChannelCreate(s discordSession, m *discordgo.ChannelCreate) {
+	b.categoryAdd(s, m.Channel)
+	b.autoMapAdd(s, m.Channel)
+}
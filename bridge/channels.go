@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+)
+
+// discordChannelDeleted returns whether the mapped Discord channel dc was deleted, and is no longer relayed to.
+func (b *Bridge) discordChannelDeleted(dc string) bool {
+	chs := b.mappings()[dc]
+	return len(chs) > 0 && chs[0].deleted
+}
+
+// channelAnnounce writes text to the IRC channels Discord channel dc is bridged to.
+func (b *Bridge) channelAnnounce(dc string, text string) {
+	for _, ch := range b.mappings()[dc] {
+		if !ch.relayToIRC() {
+			continue
+		}
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ch.IRC, text},
+		})
+	}
+}
+
+func (b *Bridge) discordChannelUpdate(s discordSession, m *discordgo.ChannelUpdate) {
+	if m.BeforeUpdate != nil && (m.BeforeUpdate.ParentID != m.ParentID || discordPublic(s, m.BeforeUpdate) != discordPublic(s, m.Channel)) {
+		// moved out of or into a mapped category, or hidden from or shown to @everyone
+		if ch := b.categoryMapping(s, m.Channel, categoryParentName(s, m.Channel)); ch == nil || !b.categoryMapped(m.ID, ch.category) {
+			b.categoryRemove(m.ID)
+		}
+		b.categoryAdd(s, m.Channel)
+	}
+	if _, ok := b.mappings()[m.ID]; !ok || m.BeforeUpdate == nil || m.BeforeUpdate.Name == m.Name {
+		return
+	}
+	b.channelAnnounce(m.ID, fmt.Sprintf("%c%s%c", fItalics, b.localize("channelRenamed", m.BeforeUpdate.Name, m.Name), fReset))
+}
+
+func (b *Bridge) discordChannelDelete(s discordSession, m *discordgo.ChannelDelete) {
+	if _, ok := b.mappings()[m.ID]; !ok {
+		return
+	}
+	b.mappingsUpdate(func(chs map[string]Channels) {
+		deleted := make(Channels, 0, len(chs[m.ID]))
+		for _, ch := range chs[m.ID] {
+			c := *ch
+			c.deleted = true
+			deleted = append(deleted, &c)
+		}
+		chs[m.ID] = deleted
+	})
+	logErr.Printf("mapped discord channel %v (#%v) was deleted, no longer bridging it", m.ID, m.Name)
+	text := b.localize("channelDeleted", m.Name)
+	b.channelAnnounce(m.ID, fmt.Sprintf("%c%s%c", fItalics, text, fReset))
+	if b.cfg.ServerNotices != "" && b.cfg.ServerNotices != m.ID {
+		b.discordSend("", b.cfg.ServerNotices, text, "")
+	}
+	b.categoryRemove(m.ID)
+}
@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestChannelRenameDelete(t *testing.T) {
+	h := newHarness(t, "")
+	before := &discordgo.Channel{ID: testChannel, GuildID: testGuild, Name: "test"}
+	h.b.discordChannelUpdate(h.discord, &discordgo.ChannelUpdate{
+		Channel:      &discordgo.Channel{ID: testChannel, GuildID: testGuild, Name: "general"},
+		BeforeUpdate: before,
+	})
+	h.b.discordChannelDelete(h.discord, &discordgo.ChannelDelete{
+		Channel: &discordgo.Channel{ID: testChannel, GuildID: testGuild, Name: "general"},
+	})
+	sent := h.irc.take()
+	want := []string{
+		"Discord channel #test renamed to #general",
+		"Discord channel #general was deleted and is no longer bridged",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if m.Params[0] != "#test" || stripFormatting(m.Params[1]) != want[i] {
+			t.Errorf("message %d: got %v, want %q", i, m, want[i])
+		}
+	}
+
+	h.fromIRC(":carol!c@host PRIVMSG #test :hello?")
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Errorf("got discord messages %v, want none to the deleted channel", sent)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	h := newHarness(t, "categories:\n  support: \"#support-*\"\n")
+	for _, c := range []*discordgo.Channel{
+		{ID: "200", Name: "Support", Type: discordgo.ChannelTypeGuildCategory},
+		{ID: "201", Name: "help", ParentID: "200", Type: discordgo.ChannelTypeGuildText},
+		{ID: "203", Name: "lounge", Type: discordgo.ChannelTypeGuildText},
+		{ID: "204", Name: "staff", ParentID: "200", Type: discordgo.ChannelTypeGuildText, PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			{ID: testGuild, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+		}},
+	} {
+		c.GuildID = testGuild
+		if err := h.discord.st.ChannelAdd(c); err != nil {
+			t.Fatalf("adding channel: %v", err)
+		}
+	}
+	if err := h.b.categoryMap(h.discord); err != nil {
+		t.Fatalf("mapping categories: %v", err)
+	}
+	if chs := h.b.mappings()["201"]; len(chs) != 1 || chs[0].IRC != "#support-help" {
+		t.Errorf("got mappings %+v for the channel of the category, want #support-help", chs)
+	}
+	if _, ok := h.b.mappings()["203"]; ok {
+		t.Errorf("mapped a channel out of the category")
+	}
+	if _, ok := h.b.mappings()["204"]; ok {
+		t.Errorf("mapped a channel hidden from @everyone")
+	}
+
+	billing := &discordgo.Channel{ID: "202", GuildID: testGuild, Name: "billing", ParentID: "200", Type: discordgo.ChannelTypeGuildText}
+	if err := h.discord.st.ChannelAdd(billing); err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	h.b.discordChannelCreate(h.discord, &discordgo.ChannelCreate{Channel: billing})
+	if sent := h.irc.take(); len(sent) != 1 || sent[0].String() != "JOIN #support-billing" {
+		t.Fatalf("got irc messages %v, want a join", sent)
+	}
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #support-billing :hi")
+	if sent := h.discord.take(); len(sent) != 1 || sent[0].ChannelID != "202" {
+		t.Errorf("got discord messages %v, want one to the new channel", sent)
+	}
+
+	h.b.discordChannelDelete(h.discord, &discordgo.ChannelDelete{Channel: billing})
+	sent := h.irc.take()
+	if len(sent) != 2 || sent[1].String() != "PART #support-billing" {
+		t.Errorf("got irc messages %v, want a deletion notice and a part", sent)
+	}
+	if _, ok := h.b.mappings()["202"]; ok {
+		t.Errorf("kept the mapping of the deleted channel")
+	}
+}
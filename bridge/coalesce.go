@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"strings"
+	"time"
+)
+
+// coalesceBuffer holds the consecutive messages of an author waiting to be relayed as one.
+type coalesceBuffer struct {
+	author string
+	ids    []string
+	lines  []string
+	flush  func(ids []string, lines []string)
+	timer  *time.Timer
+}
+
+// coalesce buffers a message to be relayed to destination, merging it with the following messages
+// of the same author sent within the coalescing delay. flush of the first message is called with
+// the IDs and lines of the merged messages.
+func (b *Bridge) coalesce(destination string, author string, id string, line string, flush func(ids []string, lines []string)) {
+	b.coalesceDelay(destination, author, id, line, b.cfg.Coalesce, flush)
+}
+
+// coalesceQueue returns the queue of the messages received from the other side than destination,
+// which stay queued while they are buffered.
+func (b *Bridge) coalesceQueue(destination string) *outQueue {
+	if strings.HasPrefix(destination, "irc ") {
+		return b.discordQueue
+	}
+	return b.ircQueue
+}
+
+// coalesceDelay is coalesce with a custom coalescing delay.
+func (b *Bridge) coalesceDelay(destination string, author string, id string, line string, delay time.Duration, flush func(ids []string, lines []string)) {
+	b.coalesceQueue(destination).retain(id)
+	b.coalesceLock.Lock()
+	buf := b.coalesceBuffers[destination]
+	if buf != nil && buf.author == author {
+		buf.ids = append(buf.ids, id)
+		buf.lines = append(buf.lines, line)
+		b.coalesceLock.Unlock()
+		return
+	}
+	b.coalesceLock.Unlock()
+	b.coalesceFlush(destination)
+
+	buf = &coalesceBuffer{
+		author: author,
+		ids:    []string{id},
+		lines:  []string{line},
+		flush:  flush,
+	}
+	b.coalesceLock.Lock()
+	b.coalesceBuffers[destination] = buf
+	buf.timer = time.AfterFunc(delay, func() {
+		b.coalesceLock.Lock()
+		if b.coalesceBuffers[destination] != buf {
+			b.coalesceLock.Unlock()
+			return
+		}
+		delete(b.coalesceBuffers, destination)
+		b.coalesceLock.Unlock()
+		b.coalesceRelay(buf, destination)
+	})
+	b.coalesceLock.Unlock()
+}
+
+// coalesceFlush relays the messages buffered for destination right away,
+// so that they are not reordered with a message relayed without coalescing.
+func (b *Bridge) coalesceFlush(destination string) {
+	b.coalesceLock.Lock()
+	buf := b.coalesceBuffers[destination]
+	if buf == nil {
+		b.coalesceLock.Unlock()
+		return
+	}
+	delete(b.coalesceBuffers, destination)
+	buf.timer.Stop()
+	b.coalesceLock.Unlock()
+	b.coalesceRelay(buf, destination)
+}
+
+// coalesceRelay flushes the messages of buf, buffered for destination.
+func (b *Bridge) coalesceRelay(buf *coalesceBuffer, destination string) {
+	buf.flush(buf.ids, buf.lines)
+	q := b.coalesceQueue(destination)
+	for _, id := range buf.ids {
+		q.release(id)
+	}
+}
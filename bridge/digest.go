@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// digestMaxNicks is the count of nicks listed per event in membership digests.
+const digestMaxNicks = 20
+
+// digest is the IRC joins, parts and quits accumulated for a Discord channel during a window of cfg.JoinDigest.
+type digest struct {
+	joined []string
+	left   []string
+}
+
+// digestHold accumulates the join (or part or quit, if !joined) of nick relayed to Discord channel dc,
+// returning false if membership events are not digested.
+func (b *Bridge) digestHold(dc string, nick string, joined bool) bool {
+	if b.cfg.JoinDigest <= 0 {
+		return false
+	}
+	b.digestLock.Lock()
+	defer b.digestLock.Unlock()
+	d, ok := b.digests[dc]
+	if !ok {
+		d = &digest{}
+		b.digests[dc] = d
+		time.AfterFunc(b.cfg.JoinDigest, func() {
+			b.digestFlush(dc)
+		})
+	}
+	if joined {
+		d.joined = digestAppend(d.joined, nick)
+	} else {
+		d.left = digestAppend(d.left, nick)
+	}
+	return true
+}
+
+// digestAppend appends nick to nicks unless already listed.
+func digestAppend(nicks []string, nick string) []string {
+	for _, n := range nicks {
+		if n == nick {
+			return nicks
+		}
+	}
+	return append(nicks, nick)
+}
+
+// digestFlush relays the pending digest of Discord channel dc as a single message.
+func (b *Bridge) digestFlush(dc string) {
+	b.digestLock.Lock()
+	d, ok := b.digests[dc]
+	delete(b.digests, dc)
+	b.digestLock.Unlock()
+	if !ok {
+		return
+	}
+	var parts []string
+	if len(d.joined) > 0 {
+		parts = append(parts, b.localize("digestJoined", len(d.joined), digestNicks(d.joined)))
+	}
+	if len(d.left) > 0 {
+		parts = append(parts, b.localize("digestLeft", len(d.left), digestNicks(d.left)))
+	}
+	b.discordSendFrom("", dc, fmt.Sprintf("%c%s%c", fItalics, strings.Join(parts, "; "), fReset), "", false)
+}
+
+// digestNicks returns the list of nicks of a digest, cut after digestMaxNicks.
+func digestNicks(nicks []string) string {
+	if len(nicks) > digestMaxNicks {
+		return strings.Join(nicks[:digestMaxNicks], ", ") + ", …"
+	}
+	return strings.Join(nicks, ", ")
+}
@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestJoinDigest(t *testing.T) {
+	h := newHarness(t, "joinDigest: 1h\n")
+	for _, line := range []string{
+		":a!u@host JOIN #test",
+		":b!u@host JOIN #test",
+		":a!u@host JOIN #test",
+		":c!u@host PART #test :bye",
+		":d!u@host QUIT :Quit: bye",
+	} {
+		h.fromIRC(line)
+	}
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Fatalf("relayed %d membership messages before the digest", len(sent))
+	}
+	h.b.digestFlush(testChannel)
+	sent := h.discord.take()
+	want := "\u200b*2 joined: a, b; 2 left: c, d*"
+	if len(sent) != 1 || sent[0].Content != want {
+		t.Fatalf("got discord messages %v, want %q", sent, want)
+	}
+}
@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"fmt"
+	"gopkg.in/irc.v3"
+	"regexp"
+	"time"
+)
+
+// ircRetryDelay is the delay before reconnecting to IRC after a transient failure.
+const ircRetryDelay = 15 * time.Second
+
+// ircFatalDelay is the delay before reconnecting to IRC after a first fatal failure, doubled
+// after each consecutive fatal failure up to ircFatalMaxDelay.
+const ircFatalDelay = 10 * time.Minute
+
+const ircFatalMaxDelay = 6 * time.Hour
+
+// ircThrottleDelay is the delay before reconnecting to IRC after being throttled for reconnecting too fast.
+const ircThrottleDelay = 2 * time.Minute
+
+// ircConnectionsDelay is the delay before reconnecting to the same IRC server after it refused the
+// connection for too many connections, e.g. while a previous connection of the bridge times out.
+const ircConnectionsDelay = 5 * time.Minute
+
+const (
+	disconnectPassword    = "password"
+	disconnectBanned      = "banned"      // from the network
+	disconnectKilled      = "killed"      // by services
+	disconnectServerBan   = "server-ban"  // from the server only, e.g. a K-line
+	disconnectThrottled   = "throttled"   // for reconnecting too fast
+	disconnectConnections = "connections" // too many connections from the host
+)
+
+// ircDisconnect is the classified cause of an IRC disconnection.
+type ircDisconnect struct {
+	kind   string
+	reason string // as sent by the server
+}
+
+// fatal returns whether reconnecting is expected to fail until the configuration or the server changes,
+// fallback being whether fallback servers are configured.
+func (d *ircDisconnect) fatal(fallback bool) bool {
+	switch d.kind {
+	case disconnectPassword, disconnectBanned, disconnectKilled:
+		return true
+	case disconnectServerBan:
+		// unless switching to a fallback server
+		return !fallback
+	}
+	return false
+}
+
+// String returns an actionable description of d.
+func (d *ircDisconnect) String() string {
+	switch d.kind {
+	case disconnectPassword:
+		return fmt.Sprintf("the IRC server rejected the password (%s): check the sasl settings and the account of the bridge", d.reason)
+	case disconnectBanned:
+		return fmt.Sprintf("the bridge is banned from the IRC server (%s): contact the server staff", d.reason)
+	case disconnectKilled:
+		return fmt.Sprintf("the bridge was killed by the IRC services (%s): check that its nick is registered to its sasl account", d.reason)
+	case disconnectServerBan:
+		return fmt.Sprintf("the bridge is banned from the IRC server (%s): add fallback servers or contact the server staff", d.reason)
+	case disconnectThrottled:
+		return fmt.Sprintf("the IRC server throttled the bridge (%s)", d.reason)
+	case disconnectConnections:
+		return fmt.Sprintf("the IRC server refused the connection (%s)", d.reason)
+	}
+	return d.reason
+}
+
+// disconnectPatterns classify the ERROR reasons of disconnections, in order.
+var disconnectPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	// e.g. "Closing Link: host (Killed (NickServ (GHOST command used by someone)))"
+	{disconnectKilled, regexp.MustCompile(`(?i)\bKilled \((?:\S+\.)*(?:NickServ|ChanServ|OperServ|services)\b`)},
+	{disconnectBanned, regexp.MustCompile(`(?i)\b[GZ]-?Lined\b|\bAKILL`)},
+	{disconnectServerBan, regexp.MustCompile(`(?i)\b[KD]-?Lined\b|\bbanned\b`)},
+	{disconnectThrottled, regexp.MustCompile(`(?i)throttl|too fast|connection rate`)},
+	{disconnectConnections, regexp.MustCompile(`(?i)too many (?:\S+ )?connections|max(?:imum)? (?:number of )?connections`)},
+}
+
+// ircClassify records the cause of the coming IRC disconnection from message m, if it tells it,
+// as some are not fixed by reconnecting after ircRetryDelay.
+func (b *Bridge) ircClassify(m *irc.Message) {
+	switch m.Command {
+	case "464": // ERR_PASSWDMISMATCH, also sent after a failed OPER once registered
+		if !b.ircRegistered {
+			b.ircDisconnected = &ircDisconnect{kind: disconnectPassword, reason: m.Trailing()}
+		}
+	case "465": // ERR_YOUREBANNEDCREEP
+		b.ircDisconnected = &ircDisconnect{kind: disconnectBanned, reason: m.Trailing()}
+	case "ERROR":
+		if b.ircDisconnected != nil {
+			// the numeric sent before is more specific
+			return
+		}
+		for _, p := range disconnectPatterns {
+			if p.pattern.MatchString(m.Trailing()) {
+				b.ircDisconnected = &ircDisconnect{kind: p.kind, reason: m.Trailing()}
+				return
+			}
+		}
+	}
+}
+
+// ircRetry returns the delay before reconnecting to IRC after the connection ended with err,
+// logging and notifying fatal disconnections.
+func (b *Bridge) ircRetry(err error) time.Duration {
+	d := b.ircDisconnected
+	b.ircDisconnected = nil
+	if d == nil {
+		logErr.Printf("irc error: %v", err)
+		b.ircFatalFailures = 0
+		return ircRetryDelay
+	}
+	if !d.fatal(len(b.cfg.Servers) > 0) {
+		logErr.Printf("irc error: %v: %s", err, d)
+		b.ircFatalFailures = 0
+		switch d.kind {
+		case disconnectThrottled:
+			return ircThrottleDelay
+		case disconnectServerBan, disconnectConnections:
+			if len(b.cfg.Servers) == 0 {
+				return ircConnectionsDelay
+			}
+			if b.ircRegistered {
+				// ircLoop only switches servers when failing to register
+				b.ircServerIndex++
+			}
+			logErr.Printf("switching to the next irc server")
+		}
+		return ircRetryDelay
+	}
+	delay := ircFatalDelay
+	for i := 0; i < b.ircFatalFailures && delay < ircFatalMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > ircFatalMaxDelay {
+		delay = ircFatalMaxDelay
+	}
+	b.ircFatalFailures++
+	text := fmt.Sprintf("%s; retrying in %v", d, delay)
+	logErr.Printf("irc fatal error: %s", text)
+	if b.cfg.ServerNotices != "" {
+		b.discordSend("", b.cfg.ServerNotices, text, "")
+	}
+	return delay
+}
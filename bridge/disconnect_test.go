@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIRCFatalDisconnect(t *testing.T) {
+	h := newHarness(t, "serverNotices: \"900\"\n")
+	errClosed := fmt.Errorf("connection closed")
+
+	h.fromIRC(":irc.example.com ERROR :Closing Link: host (Ping timeout)")
+	if d := h.b.ircRetry(errClosed); d != ircRetryDelay {
+		t.Errorf("got delay %v after a transient error, want %v", d, ircRetryDelay)
+	}
+	for _, want := range []time.Duration{10 * time.Minute, 20 * time.Minute} {
+		h.fromIRC(":irc.example.com 465 bridge :You are banned from this server")
+		h.fromIRC(":irc.example.com ERROR :Closing Link: host (K-Lined)")
+		if d := h.b.ircRetry(errClosed); d != want {
+			t.Errorf("got delay %v after a ban, want %v", d, want)
+		}
+	}
+	sent := h.discord.take()
+	if len(sent) != 2 || !strings.HasPrefix(sent[0].Content, "the bridge is banned from the IRC server (You are banned from this server)") {
+		t.Errorf("got discord messages %v, want ban notices", sent)
+	}
+
+	h.b.ircFatalFailures = 0
+	h.fromIRC(":irc.example.com ERROR :Closing Link: host (Killed (NickServ (GHOST command used by alice)))")
+	if d := h.b.ircRetry(errClosed); d != ircFatalDelay {
+		t.Errorf("got delay %v after a kill by services, want %v", d, ircFatalDelay)
+	}
+}
+
+func TestIRCDisconnectReasons(t *testing.T) {
+	h := newHarness(t, "servers: [\"fallback.example.com:6697\"]\n")
+	errClosed := fmt.Errorf("connection closed")
+	for _, tc := range []struct {
+		reason string
+		delay  time.Duration
+		next   bool
+	}{
+		{"Closing Link: host (Throttled: Reconnecting too fast)", ircThrottleDelay, false},
+		{"Closing Link: host (K-Lined)", ircRetryDelay, true},
+		{"Closing Link: host (Too many host connections (global))", ircRetryDelay, true},
+		{"Closing Link: host (G-Lined)", ircFatalDelay, false},
+	} {
+		h.b.ircRegistered = true
+		index := h.b.ircServerIndex
+		h.fromIRC(":irc.example.com ERROR :" + tc.reason)
+		if d := h.b.ircRetry(errClosed); d != tc.delay {
+			t.Errorf("%q: got delay %v, want %v", tc.reason, d, tc.delay)
+		}
+		if next := h.b.ircServerIndex != index; next != tc.next {
+			t.Errorf("%q: got next server %v, want %v", tc.reason, next, tc.next)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+// editMaxCached is the count of relayed Discord messages whose text is kept to diff their edits against.
+const editMaxCached = 1024
+
+// editMaxCells bounds the size of the word diff table, above which edits are relayed in full.
+const editMaxCells = 250000
+
+// editSeen records the text relayed to IRC channel ic for the Discord message id.
+func (b *Bridge) editSeen(id string, ic string, text string) {
+	b.editLock.Lock()
+	defer b.editLock.Unlock()
+	if len(b.editTexts) >= editMaxCached {
+		b.editTexts = make(map[string]string)
+	}
+	b.editTexts[ic+" "+id] = text
+}
+
+// editText returns the text last relayed to IRC channel ic for the Discord message id, if known.
+func (b *Bridge) editText(id string, ic string) (string, bool) {
+	b.editLock.Lock()
+	defer b.editLock.Unlock()
+	text, ok := b.editTexts[ic+" "+id]
+	return text, ok
+}
+
+func (b *Bridge) discordMessageUpdate(s discordSession, m *discordgo.MessageUpdate) {
+	defer b.sentryRecover()
+	// embeds being unfurled also trigger updates, without an author
+	if m.Author == nil || m.Author.ID == s.state().User.ID || m.Content == "" || m.EditedTimestamp == nil {
+		return
+	}
+	if b.optOutDiscord(m.Author.ID) {
+		return
+	}
+	chs := b.discordMappings(s, m.ChannelID)
+	if len(chs) == 0 {
+		return
+	}
+	held := b.holdEdit(m.Message)
+	text := b.ircBody(s, m.GuildID, m.Content)
+	for _, ch := range chs {
+		if !ch.relayMessageToIRC(s, m.Message) || held[ch.IRC] {
+			// held messages are relayed in their last version
+			continue
+		}
+		old, ok := b.editText(m.ID, ch.IRC)
+		if !ok && len(b.ircChannelIDs(m.ID, ch.IRC)) == 0 {
+			// never relayed to the channel, or too long ago
+			continue
+		}
+		b.editSeen(m.ID, ch.IRC, text)
+		diff := text
+		if !ok && m.BeforeUpdate != nil && m.BeforeUpdate.Content != "" {
+			old, ok = b.ircBody(s, m.GuildID, m.BeforeUpdate.Content), true
+		}
+		if ok {
+			if old == text {
+				continue
+			}
+			diff = editDiff(old, text)
+		}
+		tags := irc.Tags{}
+		if id := b.ircReplyTo(m.ID, ch.IRC); id != "" {
+			tags["+draft/reply"] = irc.TagValue(id)
+		}
+		line := fmt.Sprintf("%s%c%s:%c %s", b.ircPrefix(s, m.Message, ch), fItalics, b.localize("edited"), fReset, replacerNewline.Replace(diff))
+		if len(line) > b.cfg.MaxLineLength {
+			line = truncateLine(line, " … <"+discordMessageURL(m.GuildID, m.ChannelID, m.ID)+">", b.cfg.MaxLineLength)
+		}
+		b.ircWrite(&irc.Message{
+			Tags:    tags,
+			Command: "PRIVMSG",
+			Params:  []string{ch.IRC, line},
+		})
+	}
+}
+
+// editDiff returns the words of text, with the words inserted since old in green,
+// and the words removed since old struck through in red.
+func editDiff(old string, text string) string {
+	a := strings.Fields(old)
+	b := strings.Fields(text)
+	if (len(a)+1)*(len(b)+1) > editMaxCells {
+		return text
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var words []string
+	var removed, inserted []string
+	flush := func() {
+		if len(removed) > 0 {
+			words = append(words, fmt.Sprintf("%c04%c%s%c", fColor, fStrikethrough, strings.Join(removed, " "), fReset))
+			removed = nil
+		}
+		if len(inserted) > 0 {
+			words = append(words, fmt.Sprintf("%c03%s%c", fColor, strings.Join(inserted, " "), fReset))
+			inserted = nil
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			words = append(words, b[j])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			inserted = append(inserted, b[j])
+			j++
+		default:
+			removed = append(removed, a[i])
+			i++
+		}
+	}
+	flush()
+	return strings.Join(words, " ")
+}
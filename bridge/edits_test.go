@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEditDiff(t *testing.T) {
+	for _, tc := range []struct {
+		old, text, want string
+	}{
+		{"the quick brown fox", "the slow brown fox jumps", "the \x0304\x1equick\x0f \x0303slow\x0f brown fox \x0303jumps\x0f"},
+		{"hello world", "hello", "hello \x0304\x1eworld\x0f"},
+		{"same", "same", "same"},
+	} {
+		if got := editDiff(tc.old, tc.text); got != tc.want {
+			t.Errorf("editDiff(%q, %q): got %q, want %q", tc.old, tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestRelayEdit(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "the quick brown fox", nil)
+	h.echo("e")
+
+	edited := *m
+	edited.Content = "the slow brown fox"
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited})
+	// embeds being unfurled
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &discordgo.Message{ID: m.ID, ChannelID: m.ChannelID}})
+
+	sent := h.irc.take()
+	if len(sent) != 1 {
+		t.Fatalf("got irc messages %v, want 1", sent)
+	}
+	want := "<a\u200blice> edit: the quick slow brown fox"
+	if got := stripFormatting(sent[0].Params[1]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if reply := string(sent[0].Tags["+draft/reply"]); reply != "e0" {
+		t.Errorf("got reply tag %q, want e0", reply)
+	}
+}
+
+func TestRelayEditChannels(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "the quick brown fox", nil)
+	h.echo("e")
+	// bridged after the message was relayed
+	h.b.mappingsUpdate(func(chs map[string]Channels) {
+		chs[testChannel] = append(Channels{chs[testChannel][0]}, &Channel{Discord: testChannel, IRC: "#other"})
+	})
+
+	edited := *m
+	edited.Content = "the slow brown fox"
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited})
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].Params[0] != "#test" || string(sent[0].Tags["+draft/reply"]) != "e0" {
+		t.Errorf("got irc messages %v, want an edit replying to e0 in #test only", sent)
+	}
+}
+
+func TestRelayDelay(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].Delay = 50 * time.Millisecond
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "teh quick fox", nil)
+	deleted := h.fromDiscord(alice, "wrong channel", nil)
+	edited := *m
+	edited.Content = "the quick fox"
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited})
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: deleted})
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Fatalf("got irc messages %v before the delay, want none", sent)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	sent := h.irc.take()
+	if want := "<a\u200blice> the quick fox"; len(sent) != 1 || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+}
+
+func TestRelayEditGrace(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].EditGrace = time.Minute
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "teh quick fox", nil)
+	edited := *m
+	edited.Content = "the quick fox"
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited})
+	time.Sleep(50 * time.Millisecond)
+
+	// relayed on the edit rather than after the grace period
+	sent := h.irc.take()
+	if want := "<a\u200blice> the quick fox"; len(sent) != 1 || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+}
+
+func TestRelayEditGraceOrder(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].EditGrace = time.Minute
+	alice := h.addMember("500", "alice", "")
+	first := h.fromDiscord(alice, "first", nil)
+	second := h.fromDiscord(alice, "secnod", nil)
+	edited := *second
+	edited.Content = "second"
+	edited.Attachments = []*discordgo.MessageAttachment{{ID: "700", URL: "https://cdn.discordapp.com/a.png"}}
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited})
+	time.Sleep(50 * time.Millisecond)
+	// held back by the first message, still in its grace period
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Fatalf("got irc messages %v before the first message, want none", sent)
+	}
+
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: &discordgo.Message{ID: first.ID, ChannelID: first.ChannelID}})
+	time.Sleep(50 * time.Millisecond)
+	sent := h.irc.take()
+	var lines []string
+	for _, m := range sent {
+		lines = append(lines, stripFormatting(m.Params[1]))
+	}
+	want := []string{"<a\u200blice> second", "<a\u200blice> https://cdn.discordapp.com/a.png"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got irc messages %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const embedLineLength = 200
+
+var patternMaskedLink = regexp.MustCompile("\\[([^\\]]*)\\]\\([^)]*\\)")
+
+// embedRenderers render embeds of well-known integrations, returning an empty string for unknown embeds.
+var embedRenderers = []func(e *discordgo.MessageEmbed) string{
+	embedGit,
+	embedRSS,
+}
+
+// discordEmbed renders an embed posted by a bot or webhook as a single IRC line.
+// Embeds of the webhook API come from its callers, so every field may have newlines.
+func (b *Bridge) discordEmbed(s discordSession, guildID string, e *discordgo.MessageEmbed) string {
+	for _, r := range embedRenderers {
+		if line := r(e); line != "" {
+			return replacerNewline.Replace(line)
+		}
+	}
+	var parts []string
+	if e.Author != nil && e.Author.Name != "" && e.Title == "" {
+		parts = append(parts, fmt.Sprintf("%c%s%c", fBold, e.Author.Name, fBold))
+	}
+	if e.Title != "" {
+		parts = append(parts, fmt.Sprintf("%c%s%c", fBold, b.embedText(s, guildID, e.Title), fBold))
+	}
+	if e.Description != "" {
+		description, _, _ := strings.Cut(e.Description, "\n")
+		parts = append(parts, b.embedText(s, guildID, description))
+	}
+	for _, field := range e.Fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", b.embedText(s, guildID, field.Name), b.embedText(s, guildID, field.Value)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	line := truncate(strings.Join(parts, " — "), embedLineLength)
+	if e.URL != "" {
+		line += " <" + e.URL + ">"
+	}
+	return replacerNewline.Replace(line)
+}
+
+// embedGit renders GitHub and GitLab push, pull request and issue embeds as compact notifications,
+// e.g.: "[repo:main] 2 new commits: abc1234 Fix crash (+1 more) <url>".
+func embedGit(e *discordgo.MessageEmbed) string {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Host != "github.com" && u.Host != "gitlab.com" && !strings.HasPrefix(u.Host, "gitlab.")) {
+		return ""
+	}
+	if e.Title == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte(fBold)
+	sb.WriteString(replacerNewline.Replace(e.Title))
+	sb.WriteByte(fBold)
+	lines := strings.Split(strings.TrimSpace(e.Description), "\n")
+	if lines[0] != "" {
+		first := patternMaskedLink.ReplaceAllString(lines[0], "$1")
+		first = strings.ReplaceAll(first, "`", "")
+		sb.WriteString(": ")
+		sb.WriteString(truncate(first, embedLineLength))
+		if len(lines) > 1 {
+			fmt.Fprintf(&sb, " (+%d more)", len(lines)-1)
+		}
+	}
+	if e.Author != nil && e.Author.Name != "" {
+		fmt.Fprintf(&sb, " by %s", e.Author.Name)
+	}
+	sb.WriteString(" <")
+	sb.WriteString(e.URL)
+	sb.WriteString(">")
+	return sb.String()
+}
+
+// embedRSS renders the embeds of RSS bots, a linked title with the name of the feed and a publication time,
+// as compact notifications without their summary, e.g.: "[Feed] Title <url>".
+func embedRSS(e *discordgo.MessageEmbed) string {
+	if e.Title == "" || e.URL == "" || e.Timestamp == "" || len(e.Fields) > 0 {
+		return ""
+	}
+	var feed string
+	switch {
+	case e.Author != nil && e.Author.Name != "":
+		feed = e.Author.Name
+	case e.Footer != nil && e.Footer.Text != "":
+		feed = e.Footer.Text
+	default:
+		return ""
+	}
+	title := truncate(replacerNewline.Replace(e.Title), embedLineLength)
+	return fmt.Sprintf("[%s] %c%s%c <%s>", replacerNewline.Replace(feed), fBold, title, fBold, e.URL)
+}
+
+func (b *Bridge) embedText(s discordSession, guildID string, text string) string {
+	text = patternMaskedLink.ReplaceAllString(text, "$1")
+	return replacerNewline.Replace(b.discordIRCFormat(s, guildID, text))
+}
+
+func truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length]) + "…"
+}
@@ -0,0 +1,36 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+)
+
+func TestEmbedRenderers(t *testing.T) {
+	h := newHarness(t, "")
+	for _, tc := range []struct {
+		embed *discordgo.MessageEmbed
+		want  string
+	}{
+		{&discordgo.MessageEmbed{
+			Title:       "[bridge:main] 2 new commits",
+			URL:         "https://github.com/example/bridge/compare/a...b",
+			Description: "[`abc1234`](https://github.com/example/bridge/commit/abc1234) Fix crash\n[`def5678`](https://github.com/example/bridge/commit/def5678) Add test",
+			Author:      &discordgo.MessageEmbedAuthor{Name: "alice"},
+		}, "[bridge:main] 2 new commits: abc1234 Fix crash (+1 more) by alice <https://github.com/example/bridge/compare/a...b>"},
+		{&discordgo.MessageEmbed{
+			Title:       "Release 1.0 is out",
+			URL:         "https://blog.example.com/release-1.0",
+			Description: "A long summary of the release,\nover several lines.",
+			Timestamp:   "2024-01-02T03:04:05Z",
+			Footer:      &discordgo.MessageEmbedFooter{Text: "Example Blog"},
+		}, "[Example Blog] Release 1.0 is out <https://blog.example.com/release-1.0>"},
+		{&discordgo.MessageEmbed{
+			Title:       "Status",
+			Description: "All systems operational",
+		}, "Status — All systems operational"},
+	} {
+		if got := stripFormatting(h.b.discordEmbed(h.discord, testGuild, tc.embed)); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
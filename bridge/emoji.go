@@ -0,0 +1,93 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"github.com/bwmarrin/discordgo"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// emojiMaxSize is the maximum size of an emoji image accepted by Discord.
+const emojiMaxSize = 256 * 1024
+
+// patternEmojiName matches the names allowed for emojis by Discord.
+var patternEmojiName = regexp.MustCompile("^\\w{2,32}$")
+
+// emojiSync uploads the images of the configured emojis directory missing from the emojis of the
+// application appID, then caches the application emojis, so that IRC :shortcodes: resolve to them
+// in guilds without a matching custom emoji.
+func (b *Bridge) emojiSync(s discordSession, appID string) {
+	emojis, err := s.ApplicationEmojis(appID)
+	if err != nil {
+		logErr.Printf("fetching application emojis: %v", err)
+		return
+	}
+	if b.cfg.Emojis != "" {
+		emojis = append(emojis, b.emojiUpload(s, appID, emojis)...)
+	}
+	b.emojiLock.Lock()
+	b.applicationEmojis = emojis
+	b.emojiLock.Unlock()
+}
+
+// emojiUpload uploads the images of the configured emojis directory not in existing, named after
+// their file names, returning the created emojis.
+func (b *Bridge) emojiUpload(s discordSession, appID string, existing []*discordgo.Emoji) []*discordgo.Emoji {
+	entries, err := os.ReadDir(b.cfg.Emojis)
+	if err != nil {
+		logErr.Printf("reading emojis directory: %v", err)
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, e := range existing {
+		names[strings.ToLower(e.Name)] = true
+	}
+	var created []*discordgo.Emoji
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		mimeType := mime.TypeByExtension(strings.ToLower(ext))
+		if entry.IsDir() || !strings.HasPrefix(mimeType, "image/") || names[strings.ToLower(name)] {
+			continue
+		}
+		if !patternEmojiName.MatchString(name) {
+			logErr.Printf("skipping emoji %q: invalid name", entry.Name())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.cfg.Emojis, entry.Name()))
+		if err != nil {
+			logErr.Printf("reading emoji %q: %v", entry.Name(), err)
+			continue
+		}
+		if len(data) > emojiMaxSize {
+			logErr.Printf("skipping emoji %q: larger than %d bytes", entry.Name(), emojiMaxSize)
+			continue
+		}
+		e, err := s.ApplicationEmojiCreate(appID, &discordgo.EmojiParams{
+			Name:  name,
+			Image: "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data),
+		})
+		if err != nil {
+			logErr.Printf("uploading emoji %q: %v", entry.Name(), err)
+			continue
+		}
+		names[strings.ToLower(name)] = true
+		created = append(created, e)
+	}
+	return created
+}
+
+// applicationEmoji returns the application emoji named name, case-insensitively, or nil.
+func (b *Bridge) applicationEmoji(name string) *discordgo.Emoji {
+	b.emojiLock.RLock()
+	defer b.emojiLock.RUnlock()
+	for _, e := range b.applicationEmojis {
+		if strings.ToLower(e.Name) == name {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplicationEmojis(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"parrot.gif", "wave.png", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("GIF89a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newHarness(t, "emojis: "+dir+"\n")
+	h.discord.emojis = []*discordgo.Emoji{{ID: "20", Name: "Wave", Available: true}}
+	h.b.emojiSync(h.discord, h.discord.st.User.ID)
+	if len(h.discord.emojis) != 2 || h.discord.emojis[1].Name != "parrot" {
+		t.Fatalf("got application emojis %v, want parrot uploaded", h.discord.emojis)
+	}
+
+	h.fromIRC(":carol!c@host PRIVMSG #test :hi :parrot: :wave: :nope:")
+	sent := h.discord.take()
+	want := "\u200b**<carol>**\u200b hi <:parrot:" + h.discord.emojis[1].ID + "> <:Wave:20> :nope:"
+	if len(sent) != 1 || sent[0].Content != want {
+		t.Errorf("got discord messages %v, want %q", sent, want)
+	}
+}
@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+)
+
+type FloodConfig struct {
+	Burst    int           `yaml:"burst"`    // messages a user can send at once, 0 to disable
+	Interval time.Duration `yaml:"interval"` // time for a user to regain one message, defaults to 2s
+}
+
+// floodBucket is the token bucket of a user in a channel.
+type floodBucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int // messages dropped since the user was last allowed to send
+}
+
+// floodAllow reports whether a message from the user identified by key may be relayed.
+// Otherwise, the message is counted as dropped, and summarize is called with the count of
+// dropped messages once the user is allowed to send again.
+func (b *Bridge) floodAllow(key string, summarize func(dropped int)) bool {
+	if b.cfg.Flood.Burst <= 0 {
+		return true
+	}
+	burst := float64(b.cfg.Flood.Burst)
+	b.floodLock.Lock()
+	defer b.floodLock.Unlock()
+	now := time.Now()
+	if len(b.floodBuckets) > 1024 {
+		// forget users whose bucket is full again
+		for k, bucket := range b.floodBuckets {
+			if bucket.dropped == 0 && now.Sub(bucket.last) > time.Duration(burst)*b.cfg.Flood.Interval {
+				delete(b.floodBuckets, k)
+			}
+		}
+	}
+	bucket := b.floodBuckets[key]
+	if bucket == nil {
+		bucket = &floodBucket{
+			tokens: burst,
+			last:   now,
+		}
+		b.floodBuckets[key] = bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.last)) / float64(b.cfg.Flood.Interval)
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	bucket.dropped++
+	if bucket.dropped == 1 {
+		time.AfterFunc(time.Duration((1-bucket.tokens)*float64(b.cfg.Flood.Interval)), func() {
+			b.floodLock.Lock()
+			dropped := bucket.dropped
+			bucket.dropped = 0
+			b.floodLock.Unlock()
+			summarize(dropped)
+		})
+	}
+	return false
+}
+
+// floodSummary returns the text replacing messages dropped by flood protection.
+func (b *Bridge) floodSummary(dropped int) string {
+	if dropped == 1 {
+		return fmt.Sprintf("%c%s%c", fItalics, b.localize("floodOne"), fItalics)
+	}
+	return fmt.Sprintf("%c%s%c", fItalics, b.localize("flood", dropped), fItalics)
+}
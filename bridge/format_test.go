@@ -0,0 +1,251 @@
+package bridge
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// ircFormatCases are IRC messages formatted for Discord, golden in testdata/discord-format.golden.
+var ircFormatCases = []string{
+	"plain text",
+	"\x02bold\x02 and \x1ditalics\x1d",
+	"\x02\x1dbold italics\x1d bold\x02",
+	"\x02bold \x1fbold underline\x0f plain",
+	"\x1estrike\x1e \x1funder\x1f",
+	"\x02unterminated bold",
+	"\x02\x02empty bold",
+	"\x0304red\x03 \x0304,12red on blue\x03 \x0399,99 \x031",
+	"\x03 no color \x03,5 comma",
+	"\x04FF0000red\x04 \x04ff0000,00ff00red on green\x04 \x04XYZ bad \x04",
+	"\x11monospace\x11 \x16reverse\x16",
+	"a_b*c~d\\e",
+	"snake_case_name and 2*3*4",
+	"~~not strike~~ **not bold** __not underline__",
+	"`code_with_underscores` and _outside_",
+	"``empty raw`` and `unterminated _raw",
+	"`a` `b` `c`",
+	"`\x02no bold\x0304 in code` \x02bold\x02",
+	"https://example.com/a_b*c~d link",
+	"see https://example.com/path_with_underscores_ and_after_",
+	"\x02https://example.com/bold_url\x02",
+	"<https://example.com/x_y>",
+	"trailing url https://example.com/.",
+	"not urls: http://* https://. http:// xhttps://a_b",
+	"unicode ünïcödé \x02ボールド\x02 🎉",
+	"\x02\x1d\x1f\x1ebold italics underline strike\x0f",
+	"\x0f\x0f\x0f",
+	"# not a header",
+	"## not a header either",
+	"#channel and #hashtag # mid-line",
+	"-# not subtext",
+	"- not a list",
+	"-5 degrees and a-b - c",
+	"> not a quote",
+	">>> not a block quote",
+	">_> and <_< and -> arrows",
+	"1. not an ordered list",
+	"2024. was a year, 3.14 is pi",
+	"  - indented list",
+	"line one\n# header on line two\n> quote on line three",
+	"||not a spoiler|| and a | pipe || or",
+	"[masked](https://example.com) link",
+	"[brackets] and (parens) and [a] (b)",
+	"`# code` and `||code||`",
+	"https://example.com/a||b",
+	"\x02# bold header\x02",
+	"",
+}
+
+// discordFormatCases are Discord messages formatted for IRC, golden in testdata/irc-format.golden.
+var discordFormatCases = []string{
+	"plain text",
+	"**bold** *italics* _italics_ __underline__ ~~strike~~",
+	"***bold italics*** **bold *nested italics* bold**",
+	"__**underline bold**__ ~~**strike bold**~~",
+	"**unterminated bold",
+	"escaped \\*stars\\* and \\_underscores\\_",
+	"`inline code` and ``double `backtick` code``",
+	"```go\nfunc main() {}\n```",
+	"```\nno language\n```",
+	"||spoiler|| and ||**bold spoiler**||",
+	"> quote\nnot quote",
+	"> quote line 1\n> quote line 2\n\nafter",
+	">>> block quote\nstill quote",
+	"https://example.com/a_b*c link and <https://example.com/no_embed>",
+	"[masked](https://example.com)",
+	"<#100> <#999> <@500> <@!500> <@999> <@&600> <@&999>",
+	"@everyone @here",
+	"<:custom:123> <a:animated:456> :smile:",
+	"<t:0:f> <t:0:D> <t:abc:f>",
+	"snake_case_name 2*3*4",
+	"a\\b\\\\c",
+	"# heading\n- list",
+	"unicode ünïcödé **ボールド** 🎉",
+	"",
+}
+
+// goldenCompare compares the outputs of f for inputs to the golden file name, or updates it with -update.
+// The golden file has one Go-quoted input line and one Go-quoted output line per case, separated by blank lines.
+func goldenCompare(t *testing.T, name string, inputs []string, f func(string) string) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		var sb strings.Builder
+		for _, in := range inputs {
+			fmt.Fprintf(&sb, "%s\n%s\n\n", strconv.Quote(in), strconv.Quote(f(in)))
+		}
+		if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	golden := make(map[string]string)
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines)%2 != 0 {
+		t.Fatalf("%v: odd number of lines", path)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		in, err := strconv.Unquote(lines[i])
+		if err != nil {
+			t.Fatalf("%v: invalid input %v: %v", path, lines[i], err)
+		}
+		out, err := strconv.Unquote(lines[i+1])
+		if err != nil {
+			t.Fatalf("%v: invalid output %v: %v", path, lines[i+1], err)
+		}
+		golden[in] = out
+	}
+
+	for _, in := range inputs {
+		want, ok := golden[in]
+		if !ok {
+			t.Errorf("%q: missing from %v, run with -update", in, path)
+			continue
+		}
+		if got := f(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDiscordFormatGolden(t *testing.T) {
+	goldenCompare(t, "discord-format.golden", ircFormatCases, discordFormat)
+}
+
+func TestIRCFormatGolden(t *testing.T) {
+	h := newHarness(t, "timestamps:\n  timezone: UTC\n")
+	h.addMember("500", "alice", "Alice")
+	goldenCompare(t, "irc-format.golden", discordFormatCases, func(m string) string {
+		return h.b.discordIRCFormat(h.discord, testGuild, m)
+	})
+}
+
+// ircFormatting are the bytes of IRC formatting codes.
+const ircFormatting = "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f"
+
+// discordStripped returns a Discord message without markdown characters and escapes.
+func discordStripped(s string) string {
+	return strings.NewReplacer("\u200b", "", "\\", "", "*", "", "_", "", "~", "").Replace(s)
+}
+
+func FuzzDiscordFormat(f *testing.F) {
+	for _, in := range ircFormatCases {
+		f.Add(in)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		out := discordFormat(in)
+		if strings.ContainsAny(out, ircFormatting) {
+			t.Errorf("%q: IRC formatting codes in %q", in, out)
+		}
+		if !utf8.ValidString(out) {
+			t.Errorf("%q: invalid UTF-8 in %q", in, out)
+		}
+		// only markdown characters are added, and only formatting codes and invalid UTF-8 are removed
+		if got, want := discordStripped(out), discordStripped(stripFormatting(strings.ToValidUTF8(in, "\uFFFD"))); got != want {
+			t.Errorf("%q: got text %q, want %q", in, got, want)
+		}
+	})
+}
+
+func FuzzIRCFormat(f *testing.F) {
+	for _, in := range discordFormatCases {
+		f.Add(in)
+	}
+	h := newHarness(f, "timestamps:\n  timezone: UTC\n")
+	h.addMember("500", "alice", "Alice")
+	f.Fuzz(func(t *testing.T, in string) {
+		if strings.ContainsAny(in, ircFormatting) {
+			// formatting codes sent from Discord are passed through as is
+			return
+		}
+		out := h.b.discordIRCFormat(h.discord, testGuild, in)
+		if utf8.ValidString(in) && !utf8.ValidString(out) {
+			t.Errorf("%q: invalid UTF-8 in %q", in, out)
+		}
+		// relayed Discord formatting is always balanced, so that it does not leak to the rest of the line
+		for _, code := range []byte{fBold, fItalics, fUnderline, fStrikethrough, fMonospace, fReverse} {
+			if n := strings.Count(out, string([]byte{code})); n%2 != 0 {
+				t.Errorf("%q: unbalanced formatting code %q in %q", in, code, out)
+			}
+		}
+	})
+}
+
+// benchmarkLine is a typical IRC message, with formatting, a URL and markdown characters.
+const benchmarkLine = "\x02<nick>\x0f hey, did you see \x1dhttps://example.com/some_page_(with)_parens\x1d? " +
+	"it_has *lots* of ~markdown~ chars, `code_spans` and \x0304colors\x03 \x02everywhere\x02!"
+
+func BenchmarkDiscordFormat(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		discordFormat(benchmarkLine)
+	}
+}
+
+func BenchmarkDiscordFormatLong(b *testing.B) {
+	line := strings.Repeat(benchmarkLine+" ", 20)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		discordFormat(line)
+	}
+}
+
+func TestDowngradeColors(t *testing.T) {
+	cases := map[string]string{
+		"plain":                        "plain",
+		"\x04FF0000red\x04":            "\x0304red\x03",
+		"\x04fe0101,0000F0red on blue": "\x0304,12red on blue",
+		"\x04808080grey\x04 1":         "\x0314grey\x03 1",
+		"\x04000000,":                  "\x0301,",
+		"\x04FFFFFF,1 \x0412":          "\x0300\x02\x02,1 \x03\x02\x0212",
+		"\x04XYZ \x0302":               "\x03XYZ \x0302",
+	}
+	for in, want := range cases {
+		if got := downgradeColors(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"sort"
+	"strconv"
+)
+
+type GatewayConfig struct {
+	Compress       *bool `yaml:"compress"`       // zlib compression of gateway payloads, defaults to true
+	Reconnect      *bool `yaml:"reconnect"`      // reconnect and resume the gateway session after errors, defaults to true
+	MaxRestRetries int   `yaml:"maxRestRetries"` // retries of failed REST requests, defaults to 3
+	Reconcile      bool  `yaml:"reconcile"`      // relay the messages missed while disconnected, when a new session is started
+	ShardID        int   `yaml:"shardID"`        // shard of this bridge, when running one bridge per shard
+	ShardCount     int   `yaml:"shardCount"`     // total number of shards, defaults to 1
+}
+
+// StateConfig sets what the Discord state caches. Channels and roles are always tracked,
+// as the bridge needs them, and members are cached as they are seen.
+type StateConfig struct {
+	MaxMessageCount int   `yaml:"maxMessageCount"` // messages cached per channel, e.g. for reply excerpts, defaults to none
+	Members         int   `yaml:"members"`         // members cached per guild, defaults to 1000
+	Threads         *bool `yaml:"threads"`         // defaults to true
+	Emojis          *bool `yaml:"emojis"`          // defaults to true
+	Stickers        bool  `yaml:"stickers"`
+	ThreadMembers   bool  `yaml:"threadMembers"`
+	Voice           bool  `yaml:"voice"` // always tracked when voice occupancy is announced
+	Presences       bool  `yaml:"presences"`
+}
+
+// gatewayConfigure applies the gateway and state options to the Discord session.
+func (b *Bridge) gatewayConfigure(s *discordgo.Session) {
+	s.State.MaxMessageCount = b.cfg.State.MaxMessageCount
+	s.State.TrackThreads = b.cfg.State.Threads == nil || *b.cfg.State.Threads
+	s.State.TrackEmojis = b.cfg.State.Emojis == nil || *b.cfg.State.Emojis
+	s.State.TrackStickers = b.cfg.State.Stickers
+	s.State.TrackThreadMembers = b.cfg.State.ThreadMembers
+	s.State.TrackVoice = b.cfg.State.Voice || len(b.cfg.Voice.Channels) > 0 || b.cfg.Voice.Stages
+	s.State.TrackPresences = b.cfg.State.Presences
+	// members are cached by the bridge itself, see memberSeen
+	s.State.TrackMembers = false
+
+	if b.cfg.Gateway.Compress != nil {
+		s.Compress = *b.cfg.Gateway.Compress
+	}
+	if b.cfg.Gateway.Reconnect != nil {
+		s.ShouldReconnectOnError = *b.cfg.Gateway.Reconnect
+	}
+	if b.cfg.Gateway.MaxRestRetries > 0 {
+		s.MaxRestRetries = b.cfg.Gateway.MaxRestRetries
+	}
+	if b.cfg.Gateway.ShardCount > 1 {
+		s.ShardID = b.cfg.Gateway.ShardID
+		s.ShardCount = b.cfg.Gateway.ShardCount
+	}
+}
+
+// gatewayShard returns whether guild guildID is served by the shard of the bridge.
+func (b *Bridge) gatewayShard(guildID string) bool {
+	if b.cfg.Gateway.ShardCount <= 1 {
+		return true
+	}
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return false
+	}
+	return int((id>>22)%uint64(b.cfg.Gateway.ShardCount)) == b.cfg.Gateway.ShardID
+}
+
+// gatewayShardCheck checks that the channels mapped explicitly are in guilds served by the shard
+// of the bridge, as every bridge relays the IRC messages of its mappings.
+func (b *Bridge) gatewayShardCheck(s discordSession) error {
+	if b.cfg.Gateway.ShardCount <= 1 {
+		return nil
+	}
+	for dc := range b.cfg.Channels {
+		c, err := s.Channel(dc)
+		if err != nil {
+			return fmt.Errorf("fetching discord channel %v: %v", dc, err)
+		}
+		if !b.gatewayShard(c.GuildID) {
+			return fmt.Errorf("discord channel %v is in guild %v, not served by shard %d", dc, c.GuildID, b.cfg.Gateway.ShardID)
+		}
+	}
+	return nil
+}
+
+// gatewaySeen records a message relayed from a mapped Discord channel.
+func (b *Bridge) gatewaySeen(m *discordgo.Message) {
+	b.discordLastLock.Lock()
+	defer b.discordLastLock.Unlock()
+	if last, ok := b.discordLast[m.ChannelID]; !ok || snowflakeLess(last, m.ID) {
+		b.discordLast[m.ChannelID] = m.ID
+	}
+}
+
+// gatewayReconcile relays the messages sent in mapped channels after the last relayed ones.
+// Resumed sessions receive the missed events from Discord, but new sessions do not.
+func (b *Bridge) gatewayReconcile(s discordSession) {
+	b.discordLastLock.Lock()
+	last := make(map[string]string, len(b.discordLast))
+	for dc, id := range b.discordLast {
+		last[dc] = id
+	}
+	b.discordLastLock.Unlock()
+	for dc, id := range last {
+		messages, err := s.ChannelMessages(dc, 100, "", id, "")
+		if err != nil {
+			logErr.Printf("failed fetching missed messages of discord channel %v: %v", dc, err)
+			continue
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			return snowflakeLess(messages[i].ID, messages[j].ID)
+		})
+		guildID := ""
+		if c, err := s.state().Channel(dc); err == nil {
+			guildID = c.GuildID
+		}
+		for _, m := range messages {
+			// messages fetched through REST lack their guild and member
+			m.GuildID = guildID
+			if m.Member == nil && m.Author != nil {
+				m.Member, _ = s.state().Member(guildID, m.Author.ID)
+			}
+			b.discordMessage(s, &discordgo.MessageCreate{Message: m})
+		}
+	}
+}
+
+// gatewayReady handles the start of a gateway session.
+func (b *Bridge) gatewayReady(s discordSession) {
+	b.discordLastLock.Lock()
+	b.discordSessions++
+	reconcile := b.discordSessions > 1 && b.cfg.Gateway.Reconcile
+	b.discordLastLock.Unlock()
+	if reconcile {
+		b.gatewayReconcile(s)
+	}
+}
@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+func TestGatewayReconcile(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\ngateway:\n  reconcile: true\n")
+	alice := h.addMember("500", "alice", "")
+	h.b.gatewayReady(h.discord)
+	h.fromDiscord(alice, "before", nil)
+	h.irc.take()
+
+	// sent while the bridge was disconnected
+	for _, content := range []string{"missed one", "missed two"} {
+		h.discord.store(&discordgo.Message{
+			ID:        h.discord.nextID(),
+			ChannelID: testChannel,
+			Content:   content,
+			Author:    alice.User,
+			Timestamp: time.Now(),
+			Type:      discordgo.MessageTypeDefault,
+		})
+	}
+	h.b.gatewayReady(h.discord)
+	sent := h.irc.take()
+	if len(sent) != 2 || sent[0].Params[1] != "<a\u200blice> missed one" || sent[1].Params[1] != "<a\u200blice> missed two" {
+		t.Fatalf("got irc messages %v, want the missed messages in order", sent)
+	}
+
+	// the messages are only relayed once
+	h.b.gatewayReady(h.discord)
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v after another session, want none", sent)
+	}
+}
+
+func TestGatewayShards(t *testing.T) {
+	var shards []*harness
+	for id := 0; id < 2; id++ {
+		h := newHarness(t, fmt.Sprintf("gateway:\n  shardCount: 2\n  shardID: %d\nautoMap: true\n", id))
+		if err := h.b.cfg.AutoMap.validate(); err != nil {
+			t.Fatalf("validating autoMap: %v", err)
+		}
+		shards = append(shards, h)
+	}
+	// testGuild is served by shard 0
+	if err := shards[0].b.gatewayShardCheck(shards[0].discord); err != nil {
+		t.Errorf("checking the mappings of shard 0: %v", err)
+	}
+	if err := shards[1].b.gatewayShardCheck(shards[1].discord); err == nil {
+		t.Errorf("checking the mappings of shard 1: got no error for a channel of another shard")
+	}
+	shards[1].b.mappingsUpdate(func(chs map[string]Channels) {
+		delete(chs, testChannel)
+	})
+
+	for _, h := range shards {
+		general := &discordgo.Channel{ID: "101", GuildID: testGuild, Name: "general", Type: discordgo.ChannelTypeGuildText}
+		if err := h.discord.st.ChannelAdd(general); err != nil {
+			t.Fatalf("adding channel: %v", err)
+		}
+		if err := h.b.autoMap(h.discord); err != nil {
+			t.Fatalf("auto-mapping: %v", err)
+		}
+		h.irc.take()
+	}
+	if chs := shards[1].b.mappings()["101"]; len(chs) != 0 {
+		t.Errorf("got mappings %+v on shard 1, want none", chs)
+	}
+
+	sent := 0
+	for _, h := range shards {
+		h.fromIRC(":carol!c@host PRIVMSG #general :hello")
+		sent += len(h.discord.take())
+	}
+	if sent != 1 {
+		t.Errorf("got %d discord messages from both shards, want 1", sent)
+	}
+}
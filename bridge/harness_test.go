@@ -0,0 +1,436 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIRC is an IRC connection recording the messages written by the bridge.
+type fakeIRC struct {
+	lock sync.Mutex
+	nick string
+	sent []*irc.Message
+}
+
+func (c *fakeIRC) CurrentNick() string {
+	return c.nick
+}
+
+func (c *fakeIRC) WriteMessage(m *irc.Message) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sent = append(c.sent, m)
+	return nil
+}
+
+// take returns and forgets the messages written so far.
+func (c *fakeIRC) take() []*irc.Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sent := c.sent
+	c.sent = nil
+	return sent
+}
+
+// fakeDiscord is a Discord session backed by a local state, recording the requests of the bridge.
+type fakeDiscord struct {
+	b  *Bridge // receiving the gateway events
+	st *discordgo.State
+
+	lock     sync.Mutex
+	lastID   uint64
+	messages map[string]*discordgo.Message
+	sent     []*discordgo.Message
+	sends    map[string]*discordgo.MessageSend // data of the sent messages, by ID
+	deleted  []string
+	members  []*discordgo.Member // all guild members, only some of which are cached in the state
+	chunks   bool                // whether members can be requested through the gateway
+	lost     bool                // whether the member requests through the gateway are never answered
+	searches int
+	emojis   []*discordgo.Emoji // application emojis
+	threads  []string           // joined threads
+	revoked  bool               // whether the token is rejected
+}
+
+func newFakeDiscord(b *Bridge) *fakeDiscord {
+	st := discordgo.NewState()
+	st.User = &discordgo.User{
+		ID:       "1",
+		Username: "bridge",
+		Bot:      true,
+	}
+	return &fakeDiscord{
+		b:        b,
+		st:       st,
+		lastID:   1000,
+		messages: make(map[string]*discordgo.Message),
+		sends:    make(map[string]*discordgo.MessageSend),
+		chunks:   true,
+	}
+}
+
+// matchMembers returns the members whose username or nick starts with query.
+func (s *fakeDiscord) matchMembers(query string, limit int) []*discordgo.Member {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var members []*discordgo.Member
+	for _, m := range s.members {
+		if len(members) >= limit {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(m.User.Username), query) || strings.HasPrefix(strings.ToLower(m.Nick), query) {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// nextID returns a new snowflake, greater than the previous ones.
+func (s *fakeDiscord) nextID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastID++
+	return strconv.FormatUint(s.lastID, 10)
+}
+
+// take returns and forgets the messages sent so far.
+func (s *fakeDiscord) take() []*discordgo.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sent := s.sent
+	s.sent = nil
+	return sent
+}
+
+func (s *fakeDiscord) store(m *discordgo.Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages[m.ID] = m
+}
+
+func (s *fakeDiscord) state() *discordgo.State {
+	return s.st
+}
+
+func (s *fakeDiscord) RequestGuildMembers(guildID, query string, limit int, nonce string, presences bool) error {
+	if !s.chunks {
+		return fmt.Errorf("missing guild members intent")
+	}
+	if s.lost {
+		return nil
+	}
+	go s.b.discordMembersChunk(s, &discordgo.GuildMembersChunk{
+		GuildID:    guildID,
+		Members:    s.matchMembers(query, limit),
+		ChunkCount: 1,
+		Nonce:      nonce,
+	})
+	return nil
+}
+
+func (s *fakeDiscord) ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if m, ok := s.messages[messageID]; ok && m.ChannelID == channelID {
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown message %v", messageID)
+}
+
+// ChannelMessages only supports afterID, and returns the messages newest first, as Discord.
+func (s *fakeDiscord) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var messages []*discordgo.Message
+	for _, m := range s.messages {
+		if m.ChannelID == channelID && (afterID == "" || snowflakeLess(afterID, m.ID)) {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return snowflakeLess(messages[j].ID, messages[i].ID)
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (s *fakeDiscord) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	m := &discordgo.Message{
+		ID:               s.nextID(),
+		ChannelID:        channelID,
+		Content:          data.Content,
+		Author:           s.st.User,
+		MessageReference: data.Reference,
+		Timestamp:        time.Now(),
+	}
+	if c, err := s.st.Channel(channelID); err == nil {
+		m.GuildID = c.GuildID
+	}
+	s.store(m)
+	s.lock.Lock()
+	s.sent = append(s.sent, m)
+	s.sends[m.ID] = data
+	s.lock.Unlock()
+	return m, nil
+}
+
+func (s *fakeDiscord) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.messages, messageID)
+	s.deleted = append(s.deleted, messageID)
+	return nil
+}
+
+func (s *fakeDiscord) ChannelMessageCrosspost(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.ChannelMessage(channelID, messageID)
+}
+
+func (s *fakeDiscord) ChannelTyping(channelID string, options ...discordgo.RequestOption) error {
+	return nil
+}
+
+func (s *fakeDiscord) GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, m := range s.members {
+		if m.User.ID == userID {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown member %v", userID)
+}
+
+func (s *fakeDiscord) GuildMembersSearch(guildID, query string, limit int, options ...discordgo.RequestOption) ([]*discordgo.Member, error) {
+	s.lock.Lock()
+	s.searches++
+	s.lock.Unlock()
+	return s.matchMembers(query, limit), nil
+}
+
+func (s *fakeDiscord) User(userID string, options ...discordgo.RequestOption) (*discordgo.User, error) {
+	s.lock.Lock()
+	revoked := s.revoked
+	s.lock.Unlock()
+	if revoked {
+		return nil, &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}
+	}
+	if userID == "@me" {
+		return s.st.User, nil
+	}
+	return nil, fmt.Errorf("unknown user %v", userID)
+}
+
+func (s *fakeDiscord) Invite(inviteID string, options ...discordgo.RequestOption) (*discordgo.Invite, error) {
+	return nil, fmt.Errorf("unknown invite %v", inviteID)
+}
+
+func (s *fakeDiscord) AutoModerationRule(guildID, ruleID string, options ...discordgo.RequestOption) (*discordgo.AutoModerationRule, error) {
+	return nil, fmt.Errorf("unknown rule %v", ruleID)
+}
+
+func (s *fakeDiscord) UserGuilds(limit int, beforeID, afterID string, withCounts bool, options ...discordgo.RequestOption) ([]*discordgo.UserGuild, error) {
+	var guilds []*discordgo.UserGuild
+	for _, g := range s.st.Guilds {
+		guilds = append(guilds, &discordgo.UserGuild{ID: g.ID, Name: g.Name})
+	}
+	return guilds, nil
+}
+
+func (s *fakeDiscord) GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error) {
+	g, err := s.st.Guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	return g.Channels, nil
+}
+
+func (s *fakeDiscord) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return s.st.Channel(channelID)
+}
+
+func (s *fakeDiscord) ThreadJoin(id string, options ...discordgo.RequestOption) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.threads = append(s.threads, id)
+	return nil
+}
+
+func (s *fakeDiscord) ApplicationEmojis(appID string, options ...discordgo.RequestOption) ([]*discordgo.Emoji, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*discordgo.Emoji(nil), s.emojis...), nil
+}
+
+func (s *fakeDiscord) ApplicationEmojiCreate(appID string, data *discordgo.EmojiParams, options ...discordgo.RequestOption) (*discordgo.Emoji, error) {
+	e := &discordgo.Emoji{
+		ID:        s.nextID(),
+		Name:      data.Name,
+		Available: true,
+	}
+	s.lock.Lock()
+	s.emojis = append(s.emojis, e)
+	s.lock.Unlock()
+	return e, nil
+}
+
+const (
+	testGuild   = "10"
+	testChannel = "100"
+)
+
+// harness runs the bridge against fake IRC and Discord connections, with a guild
+// testGuild containing a channel testChannel bridged to #test.
+type harness struct {
+	t       testing.TB
+	b       *Bridge
+	irc     *fakeIRC
+	discord *fakeDiscord
+}
+
+// newHarness creates a bridge from the YAML config extra, added to a minimal config.
+func newHarness(t testing.TB, extra string) *harness {
+	var c Config
+	base := fmt.Sprintf(`
+discordToken: "token"
+server: "irc.example.com:6697"
+nickname: "bridge"
+channels:
+  %q: "#test"
+stsPath: %q
+`, testChannel, filepath.Join(t.TempDir(), "sts.json"))
+	if err := yaml.Unmarshal([]byte(base+extra), &c); err != nil {
+		t.Fatalf("decoding config: %v", err)
+	}
+
+	b, err := New(&c)
+	if err != nil {
+		t.Fatalf("creating bridge: %v", err)
+	}
+	// connected and registered to IRC
+	b.ircCaps = map[string]bool{
+		"message-tags":            true,
+		"echo-message":            true,
+		"draft/message-redaction": true,
+		"server-time":             true,
+		"account-tag":             true,
+		"draft/message-reactions": true,
+	}
+	b.ircJoined = make(map[string]bool)
+	b.ircJoinAttempts = make(map[string]int)
+	b.ircBatches = make(map[string]*ircBatch)
+	b.ircStatusMsg = "@+"
+	b.ircConnected = time.Now()
+	b.ircReady = true
+
+	h := &harness{
+		t:       t,
+		b:       b,
+		irc:     &fakeIRC{nick: "bridge"},
+		discord: newFakeDiscord(b),
+	}
+	b.ircClient = h.irc
+	b.discord = h.discord
+	err = h.discord.st.GuildAdd(&discordgo.Guild{
+		ID:   testGuild,
+		Name: "Test",
+		Channels: []*discordgo.Channel{{
+			ID:      testChannel,
+			GuildID: testGuild,
+			Name:    "test",
+			Type:    discordgo.ChannelTypeGuildText,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("adding guild: %v", err)
+	}
+	return h
+}
+
+// addMember adds a member to the test guild, cached in the state.
+func (h *harness) addMember(id string, username string, nick string) *discordgo.Member {
+	member := h.addRemoteMember(id, username, nick)
+	if err := h.discord.st.MemberAdd(member); err != nil {
+		h.t.Fatalf("adding member: %v", err)
+	}
+	return member
+}
+
+// addRemoteMember adds a member to the test guild, without caching it in the state.
+func (h *harness) addRemoteMember(id string, username string, nick string) *discordgo.Member {
+	member := &discordgo.Member{
+		GuildID: testGuild,
+		User: &discordgo.User{
+			ID:       id,
+			Username: username,
+		},
+		Nick: nick,
+	}
+	h.discord.lock.Lock()
+	h.discord.members = append(h.discord.members, member)
+	h.discord.lock.Unlock()
+	return member
+}
+
+// fromIRC handles a raw IRC line received by the bridge.
+func (h *harness) fromIRC(line string) {
+	m, err := irc.ParseMessage(line)
+	if err != nil {
+		h.t.Fatalf("parsing irc line %q: %v", line, err)
+	}
+	h.b.ircHandler(h.irc, m)
+}
+
+// fromDiscord handles a message sent on Discord by member in the test channel.
+func (h *harness) fromDiscord(member *discordgo.Member, content string, reference *discordgo.Message) *discordgo.Message {
+	m := &discordgo.Message{
+		ID:        h.discord.nextID(),
+		ChannelID: testChannel,
+		GuildID:   testGuild,
+		Content:   content,
+		Author:    member.User,
+		Member:    member,
+		Timestamp: time.Now(),
+		Type:      discordgo.MessageTypeDefault,
+	}
+	if reference != nil {
+		m.Type = discordgo.MessageTypeReply
+		m.MessageReference = reference.Reference()
+		m.ReferencedMessage = reference
+	}
+	h.discord.store(m)
+	h.b.discordMessage(h.discord, &discordgo.MessageCreate{Message: m})
+	return m
+}
+
+// echo handles the echo of the IRC messages written by the bridge, with message IDs
+// prefixed with prefix, and returns them.
+func (h *harness) echo(prefix string) []*irc.Message {
+	sent := h.irc.take()
+	for i, m := range sent {
+		e := m.Copy()
+		if e.Tags == nil {
+			e.Tags = irc.Tags{}
+		}
+		e.Tags["msgid"] = irc.TagValue(fmt.Sprintf("%s%d", prefix, i))
+		e.Prefix = &irc.Prefix{
+			Name: h.irc.nick,
+			User: "bridge",
+			Host: "localhost",
+		}
+		h.b.ircHandler(h.irc, e)
+	}
+	return sent
+}
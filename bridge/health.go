@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"fmt"
+	"gopkg.in/irc.v3"
+	"sync"
+	"time"
+)
+
+type HealthConfig struct {
+	DiscordChannels []string      `yaml:"discordChannels"` // Discord channels notified of IRC outages
+	IRCChannels     []string      `yaml:"ircChannels"`     // IRC channels notified of Discord outages
+	Delay           time.Duration `yaml:"delay"`           // outages shorter than this are not notified, defaults to 1m
+}
+
+// healthLink tracks the outages of the connection to one side of the bridge,
+// to notify them on the other side.
+type healthLink struct {
+	b      *Bridge
+	name   string
+	notify func(text string)
+
+	lock     sync.Mutex
+	since    time.Time // start of the current outage, zero if up
+	missed   int       // messages not relayed to this side during the outage
+	timer    *time.Timer
+	notified bool
+}
+
+// healthNotifyDiscord notifies text to the Discord channels notified of IRC outages.
+func (b *Bridge) healthNotifyDiscord(text string) {
+	for _, dc := range b.cfg.Health.DiscordChannels {
+		b.discordSend("", dc, fmt.Sprintf("%c%s%c", fItalics, text, fReset), "")
+	}
+}
+
+// healthNotifyIRC notifies text to the IRC channels notified of Discord outages.
+func (b *Bridge) healthNotifyIRC(text string) {
+	for _, ic := range b.cfg.Health.IRCChannels {
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, fmt.Sprintf("%c%s%c", fItalics, text, fReset)},
+		})
+	}
+}
+
+// down marks the link as down, notifying it after the configured delay unless it is up again.
+func (l *healthLink) down() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.since.IsZero() {
+		return
+	}
+	since := time.Now()
+	l.since = since
+	l.missed = 0
+	l.timer = time.AfterFunc(l.b.cfg.Health.Delay, func() {
+		l.lock.Lock()
+		if l.since != since {
+			l.lock.Unlock()
+			return
+		}
+		l.notified = true
+		l.lock.Unlock()
+		l.notify("⚠ " + l.b.localize("healthDown", l.name, since.In(l.b.timestampLocation).Format("15:04")))
+	})
+}
+
+// up marks the link as up, notifying the end of the outage if its start was notified.
+func (l *healthLink) up() {
+	l.lock.Lock()
+	if l.since.IsZero() {
+		l.lock.Unlock()
+		return
+	}
+	l.timer.Stop()
+	notified, missed := l.notified, l.missed
+	duration := time.Since(l.since).Round(time.Second)
+	l.since = time.Time{}
+	l.notified = false
+	l.lock.Unlock()
+	if !notified {
+		return
+	}
+	if missed > 0 {
+		l.notify("✅ " + l.b.localize("healthMissed", l.name, duration, missed))
+	} else {
+		l.notify("✅ " + l.b.localize("healthUp", l.name, duration))
+	}
+}
+
+// miss counts a message that could not be relayed to the link during an outage.
+func (l *healthLink) miss() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.since.IsZero() {
+		l.missed++
+	}
+}
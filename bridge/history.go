@@ -0,0 +1,131 @@
+package bridge
+
+import (
+	"gopkg.in/irc.v3"
+	"strconv"
+)
+
+// historyLimit is the count of recent messages of a channel searched for the relayed copy of a deleted Discord message.
+const historyLimit = 100
+
+// historyRedact searches the recent history of IRC channel ic for the message relayed from the deleted
+// Discord message discordID, by its tag or else by the texts relayed, to redact it.
+func (b *Bridge) historyRedact(ic string, discordID string, texts []string) {
+	if !b.ircCapsEnabled("draft/chathistory", "batch", "draft/message-redaction") {
+		return
+	}
+	b.historyLock.Lock()
+	ids, pending := b.historyLookups[ic]
+	if !pending {
+		ids = make(map[string][]string)
+		b.historyLookups[ic] = ids
+	}
+	ids[discordID] = texts
+	b.historyLock.Unlock()
+	if pending {
+		// the pending lookup will find it
+		return
+	}
+	b.ircWrite(&irc.Message{
+		Command: "CHATHISTORY",
+		Params:  []string{"LATEST", ic, "*", strconv.Itoa(historyLimit)},
+	})
+}
+
+// historyRebuild searches the recent history of IRC channel ic for the messages relayed by the bridge,
+// to correlate them again with the Discord messages of their tags. It returns false if the server
+// does not support history.
+func (b *Bridge) historyRebuild(ic string) bool {
+	if !b.ircCapsEnabled("draft/chathistory", "batch") {
+		return false
+	}
+	b.historyLock.Lock()
+	_, pending := b.historyLookups[ic]
+	pending = pending || b.historyRebuilds[ic]
+	b.historyRebuilds[ic] = true
+	b.historyLock.Unlock()
+	if !pending {
+		b.ircWrite(&irc.Message{
+			Command: "CHATHISTORY",
+			Params:  []string{"LATEST", ic, "*", strconv.Itoa(historyLimit)},
+		})
+	}
+	return true
+}
+
+// historyPending returns whether the history of IRC channel ic is being searched.
+func (b *Bridge) historyPending(ic string) bool {
+	b.historyLock.Lock()
+	defer b.historyLock.Unlock()
+	_, ok := b.historyLookups[ic]
+	return ok || b.historyRebuilds[ic]
+}
+
+// historyBatch redacts the messages relayed by the bridge from the deleted Discord messages
+// looked up, and correlates them again if the ID maps are being rebuilt, in the chathistory batch.
+func (b *Bridge) historyBatch(c ircConn, batch *ircBatch) {
+	ic := batch.start.Params[2]
+	b.historyLock.Lock()
+	ids := b.historyLookups[ic]
+	delete(b.historyLookups, ic)
+	rebuild := b.historyRebuilds[ic]
+	delete(b.historyRebuilds, ic)
+	b.historyLock.Unlock()
+	// the Discord channel of a message is only known if the IRC channel is bridged to a single one
+	var dc string
+	if chs := b.ircChannels(ic); len(chs) == 1 {
+		dc = chs[0].Discord
+	}
+	// the lines of servers not keeping the client tags are found by their text, once each
+	texts := make(map[string]int)
+	for _, ts := range ids {
+		for _, text := range ts {
+			texts[text]++
+		}
+	}
+	for _, m := range batch.messages {
+		if m.Command != "PRIVMSG" || m.Prefix == nil || m.Name != c.CurrentNick() {
+			continue
+		}
+		msgID := string(m.Tags["msgid"])
+		if msgID == "" {
+			continue
+		}
+		discordID := taggedDiscordID(m.Tags)
+		if rebuild && discordID != "" && !b.correlated(msgID, discordID) {
+			b.correlateIRCChannel(msgID, ic)
+			b.correlate(msgID, discordID)
+			if dc != "" {
+				b.correlateChannel(discordID, dc)
+			}
+		}
+		redact := false
+		if discordID != "" {
+			_, redact = ids[discordID]
+		} else if texts[m.Trailing()] > 0 && len(b.discordIDs(msgID)) == 0 {
+			texts[m.Trailing()]--
+			redact = true
+		}
+		if redact {
+			b.ircWrite(&irc.Message{
+				Command: "REDACT",
+				Params:  []string{ic, msgID},
+			})
+		}
+	}
+}
+
+// historyFailed handles the failure of the history lookup of the channel named in m, e.g. when the server
+// does not keep its history.
+func (b *Bridge) historyFailed(m *irc.Message) {
+	if len(m.Params) < 3 || m.Params[0] != "CHATHISTORY" {
+		return
+	}
+	b.historyLock.Lock()
+	defer b.historyLock.Unlock()
+	// the context parameters of the failure name the target, e.g. FAIL CHATHISTORY INVALID_TARGET LATEST #chan :...
+	for _, p := range m.Params[2 : len(m.Params)-1] {
+		delete(b.historyLookups, p)
+		delete(b.historyRebuilds, p)
+	}
+}
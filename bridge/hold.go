@@ -0,0 +1,140 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"time"
+)
+
+// held is a Discord message held before being relayed to IRC.
+type held struct {
+	m       *discordgo.Message // as last edited
+	since   time.Time
+	deleted bool
+	pending map[string]*holdTimer // IRC channel to the timer relaying the message to it
+}
+
+type holdTimer struct {
+	*time.Timer
+	h       *held
+	s       discordSession
+	ch      *Channel
+	forward []string
+	delay   time.Duration // minimum hold, whether the message is edited or not
+	grace   bool          // whether an edit ends the hold
+	over    bool          // whether the hold is over, and the message is relayed once the previous ones are
+}
+
+// holdRelay relays Discord message m, as last edited, to the IRC channel of mapping ch and forwards it
+// to the Discord channels of forward once the delay of ch is over, or its edit grace period if it is not
+// edited before, unless it is deleted before.
+func (b *Bridge) holdRelay(s discordSession, m *discordgo.MessageCreate, ch *Channel, forward []string) {
+	b.holdLock.Lock()
+	defer b.holdLock.Unlock()
+	h, ok := b.holds[m.ID]
+	if !ok {
+		h = &held{m: m.Message, since: time.Now(), pending: make(map[string]*holdTimer)}
+		b.holds[m.ID] = h
+	}
+	hold := ch.Delay
+	if ch.EditGrace > hold {
+		hold = ch.EditGrace
+	}
+	t := &holdTimer{h: h, s: s, ch: ch, forward: forward, delay: ch.Delay, grace: ch.EditGrace > 0}
+	h.pending[ch.IRC] = t
+	b.holdOrder[ch.IRC] = append(b.holdOrder[ch.IRC], t)
+	b.discordQueue.retain(m.ID)
+	t.Timer = time.AfterFunc(hold, func() {
+		defer b.sentryRecover()
+		b.holdLock.Lock()
+		t.over = true
+		b.holdLock.Unlock()
+		b.holdRelease(ch.IRC)
+	})
+}
+
+// holdRelease relays the held messages of IRC channel ic whose hold is over, up to the first one still held.
+func (b *Bridge) holdRelease(ic string) {
+	b.holdRelayLock.Lock()
+	defer b.holdRelayLock.Unlock()
+	for {
+		b.holdLock.Lock()
+		order := b.holdOrder[ic]
+		if len(order) == 0 || !order[0].over {
+			b.holdLock.Unlock()
+			return
+		}
+		t := order[0]
+		if len(order) == 1 {
+			delete(b.holdOrder, ic)
+		} else {
+			b.holdOrder[ic] = order[1:]
+		}
+		delete(t.h.pending, ic)
+		if len(t.h.pending) == 0 {
+			delete(b.holds, t.h.m.ID)
+		}
+		message, deleted := t.h.m, t.h.deleted
+		b.holdLock.Unlock()
+		if !deleted {
+			b.discordRelayForward(t.s, &discordgo.MessageCreate{Message: message}, t.ch, t.forward)
+		}
+		b.discordQueue.release(message.ID)
+	}
+}
+
+// holdEdit applies the edit m to the Discord message it edits if it is held,
+// returning the IRC channels the message is held for.
+func (b *Bridge) holdEdit(m *discordgo.Message) map[string]bool {
+	b.holdLock.Lock()
+	defer b.holdLock.Unlock()
+	h, ok := b.holds[m.ID]
+	if !ok {
+		return nil
+	}
+	// edits carry the whole message, but not always the member of its author
+	edited := *h.m
+	edited.Content = m.Content
+	edited.EditedTimestamp = m.EditedTimestamp
+	edited.Attachments = m.Attachments
+	edited.Embeds = m.Embeds
+	h.m = &edited
+	for _, t := range h.pending {
+		// a timer not stopped is already relaying the message
+		if t.grace && t.Stop() {
+			t.Reset(time.Until(h.since.Add(t.delay)))
+		}
+	}
+	return holdPending(h)
+}
+
+// holdDelete drops the Discord message id if it is held, returning the IRC channels the message was held for.
+func (b *Bridge) holdDelete(id string) map[string]bool {
+	b.holdLock.Lock()
+	h, ok := b.holds[id]
+	if !ok {
+		b.holdLock.Unlock()
+		return nil
+	}
+	h.deleted = true
+	for _, t := range h.pending {
+		// stop holding back the messages sent after it
+		if t.Stop() {
+			t.over = true
+		}
+	}
+	pending := holdPending(h)
+	b.holdLock.Unlock()
+	for ic := range pending {
+		b.holdRelease(ic)
+	}
+	return pending
+}
+
+// holdPending returns a copy of the IRC channels h is held for. holdLock must be held.
+func holdPending(h *held) map[string]bool {
+	pending := make(map[string]bool, len(h.pending))
+	for ic := range h.pending {
+		pending[ic] = true
+	}
+	return pending
+}
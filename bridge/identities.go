@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identity links a Discord user to their IRC nicks and services accounts, so that their mentions
+// are translated to highlight them on the other side.
+type Identity struct {
+	Discord  string   `yaml:"discord"`  // Discord user ID
+	Nicks    []string `yaml:"nicks"`    // IRC nicks, the first one is used to mention the user on IRC
+	Accounts []string `yaml:"accounts"` // IRC services accounts, matched before nicks
+	OptOut   bool     `yaml:"optOut"`   // do not relay anything of the user, on either side
+}
+
+// validateIdentities checks the identities.
+func (b *Bridge) validateIdentities() error {
+	seen := make(map[string]bool)
+	for _, i := range b.cfg.Identities {
+		if i == nil || i.Discord == "" {
+			return fmt.Errorf("no discord user for identity")
+		}
+		if len(i.Nicks) == 0 && len(i.Accounts) == 0 {
+			return fmt.Errorf("no irc nicks or accounts for identity of discord user %v", i.Discord)
+		}
+		if seen[i.Discord] {
+			return fmt.Errorf("duplicate identity of discord user %v", i.Discord)
+		}
+		seen[i.Discord] = true
+	}
+	return nil
+}
+
+// ircNick returns the name of the user of i on IRC.
+func (i *Identity) ircNick() string {
+	if len(i.Nicks) > 0 {
+		return i.Nicks[0]
+	}
+	return i.Accounts[0]
+}
+
+// identityDiscord returns the identity of Discord user id, or nil.
+func (b *Bridge) identityDiscord(id string) *Identity {
+	for _, i := range b.cfg.Identities {
+		if i.Discord == id {
+			return i
+		}
+	}
+	return nil
+}
+
+// identityIRC returns the identity of IRC user nick logged in to account, if any, or nil.
+// Accounts take precedence, as nicks can be used by anyone unless enforced by services.
+func (b *Bridge) identityIRC(nick string, account string) *Identity {
+	if account != "" {
+		for _, i := range b.cfg.Identities {
+			if containsFold(i.Accounts, account) {
+				return i
+			}
+		}
+	}
+	for _, i := range b.cfg.Identities {
+		if containsFold(i.Nicks, nick) {
+			return i
+		}
+	}
+	return nil
+}
+
+// identityName returns the identity of the IRC user named name in an IRC message, by nick or account, or nil.
+func (b *Bridge) identityName(name string) *Identity {
+	for _, i := range b.cfg.Identities {
+		if containsFold(i.Nicks, name) || containsFold(i.Accounts, name) {
+			return i
+		}
+	}
+	return nil
+}
+
+// optOutDiscord returns whether Discord user id opted out of being relayed.
+func (b *Bridge) optOutDiscord(id string) bool {
+	i := b.identityDiscord(id)
+	return i != nil && i.OptOut
+}
+
+// optOutIRC returns whether IRC user nick opted out of being relayed.
+func (b *Bridge) optOutIRC(nick string) bool {
+	i := b.identityIRC(nick, b.ircAccount(nick))
+	return i != nil && i.OptOut
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package bridge
+
+import (
+	"fmt"
+)
+
+// locales is the catalog of the user-visible messages of the bridge, by locale then key.
+// Messages are fmt formats; the subject of a message (e.g. the nick joining a channel) is written before it.
+var locales = map[string]map[string]string{
+	"en": {
+		"nick":                "is now known as %s",
+		"join":                "has joined the channel",
+		"part":                "has left the channel",
+		"partReason":          "has left the channel: %s",
+		"kick":                "was kicked off the channel by %s",
+		"kickReason":          "was kicked off the channel by %s: %s",
+		"ircInvite":           "was invited to the channel by %s",
+		"quit":                "has quit",
+		"quitReason":          "has quit: %s",
+		"netsplit":            "%d users have quit in a netsplit between %s and %s: %s",
+		"digestJoined":        "%d joined: %s",
+		"digestLeft":          "%d left: %s",
+		"statusMsg":           "to %s",
+		"floodOne":            "… and 1 more message",
+		"flood":               "… and %d more messages",
+		"voiceMessage":        "voice message, %d:%02d",
+		"attachment":          "attachment omitted: %s",
+		"attachmentSize":      "large attachment omitted: %s",
+		"forwarded":           "forwarded",
+		"forwardedFrom":       "forwarded from #%s",
+		"menu":                "menu: %s",
+		"buttons":             "buttons: %s",
+		"invite":              "invite to %s",
+		"eventScheduled":      "Event scheduled",
+		"eventUpdated":        "Event updated",
+		"eventStarted":        "Event started",
+		"eventEnded":          "Event ended",
+		"eventCancelled":      "Event cancelled",
+		"autoModBlocked":      "AutoMod blocked a message from %s in #%s (rule: %s)",
+		"autoModFlagged":      "AutoMod flagged a message from %s in #%s (rule: %s)",
+		"audit.ban":           "%s banned %s",
+		"audit.unban":         "%s unbanned %s",
+		"audit.kick":          "%s kicked %s",
+		"audit.roles":         "%s changed the roles of %s: %s",
+		"audit.channelCreate": "%s created the channel #%s",
+		"audit.channelDelete": "%s deleted the channel #%s",
+		"audit.roleCreate":    "%s created the role %s",
+		"audit.roleDelete":    "%s deleted the role %s",
+		"healthDown":          "%s link down since %s",
+		"healthUp":            "%s link back up after %v",
+		"healthMissed":        "%s link back up after %v, %d messages were not relayed",
+		"threadStarted":       "started thread: %s",
+		"threadCreated":       "Thread created: %s",
+		"threadArchived":      "Thread archived: %s",
+		"channelRenamed":      "Discord channel #%s renamed to #%s",
+		"edited":              "edit",
+		"voiceOccupancy":      "%s: %d connected",
+		"stageStarted":        "Stage '%s' started",
+		"stageSpeakers":       "Stage '%s' started, speakers: %s",
+		"stageEnded":          "Stage '%s' ended",
+		"stageSpeaker":        "is now a speaker of stage '%s'",
+		"stageAudience":       "is no longer a speaker of stage '%s'",
+		"channelDeleted":      "Discord channel #%s was deleted and is no longer bridged",
+	},
+	"fr": {
+		"nick":                "s'appelle maintenant %s",
+		"join":                "a rejoint le salon",
+		"part":                "a quitté le salon",
+		"partReason":          "a quitté le salon : %s",
+		"kick":                "a été expulsé du salon par %s",
+		"kickReason":          "a été expulsé du salon par %s : %s",
+		"ircInvite":           "a été invité dans le salon par %s",
+		"quit":                "s'est déconnecté",
+		"quitReason":          "s'est déconnecté : %s",
+		"netsplit":            "%d utilisateurs se sont déconnectés lors d'un netsplit entre %s et %s : %s",
+		"digestJoined":        "%d arrivés : %s",
+		"digestLeft":          "%d partis : %s",
+		"statusMsg":           "à %s",
+		"floodOne":            "… et 1 autre message",
+		"flood":               "… et %d autres messages",
+		"voiceMessage":        "message vocal, %d:%02d",
+		"attachment":          "pièce jointe omise : %s",
+		"attachmentSize":      "pièce jointe volumineuse omise : %s",
+		"forwarded":           "transféré",
+		"forwardedFrom":       "transféré depuis #%s",
+		"menu":                "menu : %s",
+		"buttons":             "boutons : %s",
+		"invite":              "invitation vers %s",
+		"eventScheduled":      "Événement planifié",
+		"eventUpdated":        "Événement modifié",
+		"eventStarted":        "Événement commencé",
+		"eventEnded":          "Événement terminé",
+		"eventCancelled":      "Événement annulé",
+		"autoModBlocked":      "AutoMod a bloqué un message de %s dans #%s (règle : %s)",
+		"autoModFlagged":      "AutoMod a signalé un message de %s dans #%s (règle : %s)",
+		"audit.ban":           "%s a banni %s",
+		"audit.unban":         "%s a débanni %s",
+		"audit.kick":          "%s a expulsé %s",
+		"audit.roles":         "%s a changé les rôles de %s : %s",
+		"audit.channelCreate": "%s a créé le salon #%s",
+		"audit.channelDelete": "%s a supprimé le salon #%s",
+		"audit.roleCreate":    "%s a créé le rôle %s",
+		"audit.roleDelete":    "%s a supprimé le rôle %s",
+		"healthDown":          "Lien %s coupé depuis %s",
+		"healthUp":            "Lien %s rétabli après %v",
+		"healthMissed":        "Lien %s rétabli après %v, %d messages n'ont pas été relayés",
+		"threadStarted":       "a créé le fil : %s",
+		"threadCreated":       "Fil créé : %s",
+		"threadArchived":      "Fil archivé : %s",
+		"channelRenamed":      "Salon Discord #%s renommé en #%s",
+		"edited":              "modification",
+		"voiceOccupancy":      "%s : %d connecté(s)",
+		"stageStarted":        "Scène « %s » démarrée",
+		"stageSpeakers":       "Scène « %s » démarrée, intervenants : %s",
+		"stageEnded":          "Scène « %s » terminée",
+		"stageSpeaker":        "intervient maintenant sur la scène « %s »",
+		"stageAudience":       "n'intervient plus sur la scène « %s »",
+		"channelDeleted":      "Le salon Discord #%s a été supprimé et n'est plus relié",
+	},
+}
+
+// localize formats the message key in the configured locale, falling back to English.
+func (b *Bridge) localize(key string, args ...interface{}) string {
+	format, ok := b.cfg.Messages[key]
+	if !ok {
+		format, ok = locales[b.cfg.Locale][key]
+	}
+	if !ok {
+		format = locales["en"][key]
+	}
+	return fmt.Sprintf(format, args...)
+}
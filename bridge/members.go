@@ -0,0 +1,261 @@
+package bridge
+
+import (
+	"context"
+	"github.com/bwmarrin/discordgo"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memberFetchTimeout is how long relaying a message waits for the members matching its mentions:
+// the members received later are still cached, for the next messages.
+const memberFetchTimeout = 300 * time.Millisecond
+
+// memberMissDelay is how long a name that matched no member is not queried again.
+const memberMissDelay = 10 * time.Minute
+
+// memberQueryMax is the maximum count of names queried for a single message.
+const memberQueryMax = 5
+
+// memberSearchBurst and memberSearchInterval rate limit the member searches through REST:
+// up to memberSearchBurst searches at once, then one per memberSearchInterval.
+const memberSearchBurst = 5
+const memberSearchInterval = 2 * time.Second
+
+// memberSeen caches member of guildID in the state, evicting the least recently seen members
+// if there are too many, as all the members of large guilds are not requested on startup.
+func (b *Bridge) memberSeen(s discordSession, guildID string, member *discordgo.Member) {
+	if member == nil || member.User == nil {
+		return
+	}
+	member.GuildID = guildID
+	if err := s.state().MemberAdd(member); err != nil {
+		return
+	}
+	b.membersLock.Lock()
+	seen := b.membersSeen[guildID]
+	if seen == nil {
+		seen = make(map[string]time.Time)
+		b.membersSeen[guildID] = seen
+	}
+	seen[member.User.ID] = time.Now()
+	b.membersLock.Unlock()
+	b.memberEvict(s, guildID)
+}
+
+// memberEvict removes the least recently seen members of guildID from the state,
+// when there are more than the configured maximum.
+func (b *Bridge) memberEvict(s discordSession, guildID string) {
+	max := b.cfg.State.Members
+	s.state().RLock()
+	g, err := s.state().Guild(guildID)
+	if err != nil || len(g.Members) <= max {
+		s.state().RUnlock()
+		return
+	}
+	members := make([]*discordgo.Member, len(g.Members))
+	copy(members, g.Members)
+	s.state().RUnlock()
+
+	b.membersLock.Lock()
+	seen := b.membersSeen[guildID]
+	// members cached without being seen by the bridge, e.g. on guild creation, are evicted first
+	sort.Slice(members, func(i, j int) bool {
+		return seen[members[i].User.ID].Before(seen[members[j].User.ID])
+	})
+	// evict a few more members than needed, to avoid evicting on every new member
+	members = members[:len(members)-max*9/10]
+	for _, m := range members {
+		delete(seen, m.User.ID)
+	}
+	b.membersLock.Unlock()
+	for _, m := range members {
+		if m.User.ID == s.state().User.ID {
+			continue
+		}
+		s.state().MemberRemove(m)
+	}
+}
+
+// memberCached returns whether a member of guildID can be mentioned as name.
+func memberCached(s discordSession, guildID string, name string, discriminator string) bool {
+	s.state().RLock()
+	defer s.state().RUnlock()
+	g, err := s.state().Guild(guildID)
+	if err != nil {
+		return true
+	}
+	return discordMention(g, name, discriminator, func(r *discordgo.Role) bool {
+		return r.Mentionable
+	}) != ""
+}
+
+// membersFetch fetches the members of guildID that may be mentioned in the IRC message msg,
+// and are not cached yet, waiting for them for up to memberFetchTimeout.
+func (b *Bridge) membersFetch(s discordSession, guildID string, msg string) {
+	var names, discriminators []string
+	for _, match := range patternMention.FindAllStringSubmatch(msg, -1) {
+		name := strings.ToLower(strings.TrimRight(strings.ReplaceAll(match[1], "\\", ""), "."))
+		if name == "" || name == "everyone" || name == "here" || memberCached(s, guildID, name, match[2]) {
+			continue
+		}
+		b.membersLock.Lock()
+		missed, ok := b.memberMisses[guildID+" "+name]
+		b.membersLock.Unlock()
+		if ok && time.Since(missed) < memberMissDelay {
+			continue
+		}
+		if len(names) >= memberQueryMax {
+			break
+		}
+		names = append(names, name)
+		discriminators = append(discriminators, match[2])
+	}
+	if len(names) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), memberFetchTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			b.memberQuery(ctx, s, guildID, name)
+		}(name)
+	}
+	wg.Wait()
+	for i, name := range names {
+		if !memberCached(s, guildID, name, discriminators[i]) {
+			// the gateway query can fail or be incomplete, e.g. without the guild members intent
+			if ctx.Err() != nil || !b.memberSearchAllow() {
+				continue
+			}
+			b.memberSearch(ctx, s, guildID, name)
+		}
+		// names not fetched in time are not misses
+		if ctx.Err() == nil && !memberCached(s, guildID, name, discriminators[i]) {
+			b.membersLock.Lock()
+			b.memberMisses[guildID+" "+name] = time.Now()
+			if len(b.memberMisses) > 4096 {
+				for k, t := range b.memberMisses {
+					if time.Since(t) >= memberMissDelay {
+						delete(b.memberMisses, k)
+					}
+				}
+			}
+			b.membersLock.Unlock()
+		}
+	}
+}
+
+// memberQuery requests the members of guildID whose username or nick starts with name,
+// waiting for them to be cached until ctx is done.
+func (b *Bridge) memberQuery(ctx context.Context, s discordSession, guildID string, name string) {
+	nonce := strconv.FormatUint(atomic.AddUint64(&b.memberNonce, 1), 10)
+	done := make(chan struct{})
+	b.membersLock.Lock()
+	b.memberQueries[nonce] = done
+	b.membersLock.Unlock()
+	defer func() {
+		b.membersLock.Lock()
+		delete(b.memberQueries, nonce)
+		b.membersLock.Unlock()
+	}()
+	if err := s.RequestGuildMembers(guildID, name, 10, nonce, false); err != nil {
+		logErr.Printf("failed requesting discord members of guild %v: %v", guildID, err)
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// memberSearchAllow returns whether a member search through REST can be made now.
+func (b *Bridge) memberSearchAllow() bool {
+	b.membersLock.Lock()
+	defer b.membersLock.Unlock()
+	now := time.Now()
+	if !b.memberSearchTime.IsZero() {
+		b.memberSearchTokens += float64(now.Sub(b.memberSearchTime)) / float64(memberSearchInterval)
+		if b.memberSearchTokens > memberSearchBurst {
+			b.memberSearchTokens = memberSearchBurst
+		}
+	}
+	b.memberSearchTime = now
+	if b.memberSearchTokens < 1 {
+		return false
+	}
+	b.memberSearchTokens--
+	return true
+}
+
+// memberSearch searches the members of guildID whose username or nick starts with name through REST,
+// and caches them.
+func (b *Bridge) memberSearch(ctx context.Context, s discordSession, guildID string, name string) {
+	members, err := s.GuildMembersSearch(guildID, name, 10, discordgo.WithContext(ctx))
+	if err != nil {
+		logErr.Printf("failed searching discord members of guild %v: %v", guildID, err)
+		return
+	}
+	for _, member := range members {
+		b.memberSeen(s, guildID, member)
+	}
+}
+
+// membersMentioned caches the members mentioned in a Discord message, fetching the missing ones.
+func (b *Bridge) membersMentioned(s discordSession, m *discordgo.Message) {
+	for _, u := range m.Mentions {
+		if _, err := s.state().Member(m.GuildID, u.ID); err == nil {
+			continue
+		}
+		if member, err := s.GuildMember(m.GuildID, u.ID); err == nil {
+			b.memberSeen(s, m.GuildID, member)
+		}
+	}
+}
+
+func (b *Bridge) discordMembersChunk(s discordSession, m *discordgo.GuildMembersChunk) {
+	for _, member := range m.Members {
+		b.memberSeen(s, m.GuildID, member)
+	}
+	if m.ChunkIndex != m.ChunkCount-1 {
+		return
+	}
+	b.membersLock.Lock()
+	if done, ok := b.memberQueries[m.Nonce]; ok {
+		close(done)
+		delete(b.memberQueries, m.Nonce)
+	}
+	b.membersLock.Unlock()
+}
+
+func (b *Bridge) discordMemberAdd(s discordSession, m *discordgo.GuildMemberAdd) {
+	b.memberSeen(s, m.GuildID, m.Member)
+}
+
+func (b *Bridge) discordMemberUpdate(s discordSession, m *discordgo.GuildMemberUpdate) {
+	// only keep cached members fresh
+	if m.User == nil {
+		return
+	}
+	if _, err := s.state().Member(m.GuildID, m.User.ID); err != nil {
+		return
+	}
+	b.memberSeen(s, m.GuildID, m.Member)
+}
+
+func (b *Bridge) discordMemberRemove(s discordSession, m *discordgo.GuildMemberRemove) {
+	if m.User == nil {
+		return
+	}
+	s.state().MemberRemove(m.Member)
+	b.membersLock.Lock()
+	delete(b.membersSeen[m.GuildID], m.User.ID)
+	b.membersLock.Unlock()
+}
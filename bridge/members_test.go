@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemberMentionFetch(t *testing.T) {
+	h := newHarness(t, "")
+	h.addRemoteMember("500", "dave", "")
+
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :hi @dave and @nobody")
+	sent := h.discord.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d discord messages, want 1", len(sent))
+	}
+	if want := "\u200b**<carol>**\u200b hi <@!500> and @nobody"; sent[0].Content != want {
+		t.Errorf("got %q, want %q", sent[0].Content, want)
+	}
+	if _, err := h.discord.st.Member(testGuild, "500"); err != nil {
+		t.Errorf("fetched member not cached: %v", err)
+	}
+}
+
+func TestMemberMentionedFetch(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\n")
+	alice := h.addMember("500", "alice", "")
+	h.addRemoteMember("501", "dave", "Dave")
+
+	m := &discordgo.Message{
+		ID:        h.discord.nextID(),
+		ChannelID: testChannel,
+		GuildID:   testGuild,
+		Content:   "hi <@501>",
+		Author:    alice.User,
+		Member:    &discordgo.Member{},
+		Mentions:  []*discordgo.User{{ID: "501", Username: "dave"}},
+	}
+	h.b.discordMessage(h.discord, &discordgo.MessageCreate{Message: m})
+	sent := h.irc.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d irc messages, want 1", len(sent))
+	}
+	if want := "<a\u200blice> hi @Dave"; sent[0].Params[1] != want {
+		t.Errorf("got %q, want %q", sent[0].Params[1], want)
+	}
+}
+
+func TestMemberEviction(t *testing.T) {
+	h := newHarness(t, "state:\n  members: 10\n")
+	for i := 0; i < 25; i++ {
+		id := strconv.Itoa(500 + i)
+		h.b.memberSeen(h.discord, testGuild, &discordgo.Member{
+			User: &discordgo.User{
+				ID:       id,
+				Username: "user" + id,
+			},
+		})
+	}
+	g, err := h.discord.st.Guild(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Members) > 10 {
+		t.Errorf("got %d cached members, want at most 10", len(g.Members))
+	}
+	// the most recently seen member is kept
+	if _, err := h.discord.st.Member(testGuild, "524"); err != nil {
+		t.Errorf("last seen member evicted: %v", err)
+	}
+}
+
+func TestMemberSearchFallback(t *testing.T) {
+	h := newHarness(t, "")
+	h.discord.chunks = false
+	h.addRemoteMember("500", "dave", "")
+
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :hi @dave")
+	if want := "\u200b**<carol>**\u200b hi <@!500>"; h.discord.take()[0].Content != want {
+		t.Errorf("mention not resolved through search")
+	}
+	if h.discord.searches != 1 {
+		t.Errorf("got %d searches, want 1", h.discord.searches)
+	}
+
+	// unknown names are searched once, and searches are rate limited
+	for i := 0; i < 3; i++ {
+		h.fromIRC("@msgid=i2 :carol!c@host PRIVMSG #test :@a @b @c @d @e")
+	}
+	if h.discord.searches != memberSearchBurst {
+		t.Errorf("got %d searches, want %d", h.discord.searches, memberSearchBurst)
+	}
+}
+
+func TestMemberFetchTimeout(t *testing.T) {
+	h := newHarness(t, "")
+	h.discord.lost = true
+	h.addRemoteMember("500", "dave", "")
+
+	start := time.Now()
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :@a @b @c @d @dave")
+	if elapsed := time.Since(start); elapsed > 2*memberFetchTimeout {
+		t.Errorf("relaying took %v, want at most %v", elapsed, 2*memberFetchTimeout)
+	}
+	if sent := h.discord.take(); len(sent) != 1 {
+		t.Errorf("got discord messages %v, want 1", sent)
+	}
+	// the names not fetched in time are queried again
+	h.b.membersLock.Lock()
+	misses := len(h.b.memberMisses)
+	h.b.membersLock.Unlock()
+	if misses != 0 {
+		t.Errorf("got %d member misses, want none", misses)
+	}
+}
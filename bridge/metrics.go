@@ -0,0 +1,129 @@
+package bridge
+
+import (
+	"fmt"
+	"gopkg.in/irc.v3"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the buckets of the latency histograms.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyStage is the direction and stage of the relay pipeline measured by a span.
+type latencyStage struct {
+	direction string
+	stage     string
+}
+
+// latencyStages are the measured spans, in exposition order.
+var latencyStages = []struct {
+	span string
+	latencyStage
+}{
+	{"discord.receive", latencyStage{"discord_to_irc", "total"}},
+	{"irc.transform", latencyStage{"discord_to_irc", "transform"}},
+	{"irc.journal", latencyStage{"discord_to_irc", "journal"}},
+	{"irc.write", latencyStage{"discord_to_irc", "send"}},
+	{"irc.echo", latencyStage{"discord_to_irc", "echo"}},
+	{"irc.receive", latencyStage{"irc_to_discord", "total"}},
+	{"discord.transform", latencyStage{"irc_to_discord", "transform"}},
+	{"discord.journal", latencyStage{"irc_to_discord", "journal"}},
+	{"discord.send", latencyStage{"irc_to_discord", "send"}},
+}
+
+// latencyHistogram is a cumulative histogram of latencies.
+type latencyHistogram struct {
+	buckets []uint64 // counts of latencies at most each of latencyBuckets
+	count   uint64
+	sum     time.Duration
+}
+
+// latencyObserve records the duration of a finished span, even if traces are not exported.
+func (b *Bridge) latencyObserve(name string, d time.Duration) {
+	b.latencyLock.Lock()
+	defer b.latencyLock.Unlock()
+	h, ok := b.latencies[name]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(latencyBuckets))}
+		b.latencies[name] = h
+	}
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// metricsWrite writes the latency histograms in the Prometheus text exposition format.
+func (b *Bridge) metricsWrite(w io.Writer) {
+	b.latencyLock.Lock()
+	defer b.latencyLock.Unlock()
+	fmt.Fprintln(w, "# HELP discord_ircv3_relay_latency_seconds Latency of relayed messages, by direction and pipeline stage.")
+	fmt.Fprintln(w, "# TYPE discord_ircv3_relay_latency_seconds histogram")
+	for _, s := range latencyStages {
+		h, ok := b.latencies[s.span]
+		if !ok {
+			continue
+		}
+		labels := fmt.Sprintf("direction=%q,stage=%q", s.direction, s.stage)
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "discord_ircv3_relay_latency_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(le, 'f', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "discord_ircv3_relay_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "discord_ircv3_relay_latency_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum.Seconds(), 'f', -1, 64))
+		fmt.Fprintf(w, "discord_ircv3_relay_latency_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// metricsServe handles GET /metrics of the API server.
+func (b *Bridge) metricsServe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	b.metricsWrite(w)
+}
+
+// statsLines returns the average latencies of each direction of the relay, for the !stats command.
+func (b *Bridge) statsLines() []string {
+	b.latencyLock.Lock()
+	defer b.latencyLock.Unlock()
+	var lines []string
+	for _, direction := range []string{"discord_to_irc", "irc_to_discord"} {
+		line := ""
+		for _, s := range latencyStages {
+			h, ok := b.latencies[s.span]
+			if s.direction != direction || !ok || h.count == 0 {
+				continue
+			}
+			avg := (h.sum / time.Duration(h.count)).Round(time.Microsecond)
+			if s.stage == "total" {
+				line = fmt.Sprintf("%s: %d messages, average %v", direction, h.count, avg)
+			} else if line != "" {
+				line += fmt.Sprintf(", %s %v", s.stage, avg)
+			}
+		}
+		if line == "" {
+			line = direction + ": no messages"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// statsReply answers the !stats command of nick with the latencies of the relay.
+func (b *Bridge) statsReply(c ircConn, nick string) {
+	for _, line := range b.statsLines() {
+		c.WriteMessage(&irc.Message{
+			Command: "NOTICE",
+			Params:  []string{nick, line},
+		})
+	}
+}
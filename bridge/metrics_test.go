@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLatencyMetrics(t *testing.T) {
+	h := newHarness(t, "admins: [\"root\"]\n")
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "hello", nil)
+	h.echo("e")
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :hi")
+
+	var b bytes.Buffer
+	h.b.metricsWrite(&b)
+	for _, want := range []string{
+		`discord_ircv3_relay_latency_seconds_count{direction="discord_to_irc",stage="total"} 1`,
+		`discord_ircv3_relay_latency_seconds_count{direction="discord_to_irc",stage="send"} 1`,
+		`discord_ircv3_relay_latency_seconds_count{direction="discord_to_irc",stage="echo"} 1`,
+		`discord_ircv3_relay_latency_seconds_count{direction="irc_to_discord",stage="total"} 1`,
+		`discord_ircv3_relay_latency_seconds_bucket{direction="irc_to_discord",stage="send",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %q, got:\n%s", want, b.String())
+		}
+	}
+
+	h.irc.take()
+	h.fromIRC(":carol!c@host PRIVMSG bridge :!stats")
+	if sent := h.irc.take(); len(sent) != 1 || sent[0].Params[1] != "permission denied" {
+		t.Fatalf("got irc messages %v, want permission denied", sent)
+	}
+	h.fromIRC("@account=root :carol!c@host PRIVMSG bridge :!stats")
+	sent := h.irc.take()
+	if len(sent) != 2 {
+		t.Fatalf("got irc messages %v, want 2 stats notices", sent)
+	}
+	for i, prefix := range []string{"discord_to_irc: 1 messages, average ", "irc_to_discord: 1 messages, average "} {
+		if sent[i].Command != "NOTICE" || sent[i].Params[0] != "carol" || !strings.HasPrefix(sent[i].Params[1], prefix) {
+			t.Errorf("got stats notice %v, want prefix %q", sent[i], prefix)
+		}
+	}
+}
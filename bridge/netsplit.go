@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// patternNetsplit matches the QUIT reasons of netsplits: the names of the two servers that split.
+var patternNetsplit = regexp.MustCompile(`^([\w-]+(?:\.[\w-]+)+) ([\w-]+(?:\.[\w-]+)+)$`)
+
+// netsplitDelay is how long quits with a netsplit reason are held back, waiting for more quits with the same reason.
+var netsplitDelay = 2 * time.Second
+
+// netsplitMaxDelay is how long quits are held back at most during a long netsplit.
+const netsplitMaxDelay = 10 * time.Second
+
+// netsplitMin is the count of quits with the same reason that are summarized as a netsplit.
+const netsplitMin = 3
+
+// netsplitMaxNicks is the count of nicks listed in netsplit summaries.
+const netsplitMaxNicks = 20
+
+// netsplit is a burst of quits with the same netsplit reason.
+type netsplit struct {
+	start  time.Time
+	timer  *time.Timer
+	quits  []netsplitQuit
+	reason string
+}
+
+type netsplitQuit struct {
+	nick  string
+	msgID string
+}
+
+// netsplitHold holds back the quit of nick if it looks like part of a netsplit, returning whether it did.
+// Held back quits are relayed as a single summary if there are enough of them, individually otherwise.
+func (b *Bridge) netsplitHold(nick string, reason string, msgID string) bool {
+	if !patternNetsplit.MatchString(reason) {
+		return false
+	}
+	b.netsplitLock.Lock()
+	defer b.netsplitLock.Unlock()
+	n, ok := b.netsplits[reason]
+	if !ok {
+		n = &netsplit{
+			start:  time.Now(),
+			reason: reason,
+		}
+		n.timer = time.AfterFunc(netsplitDelay, func() {
+			b.netsplitFlush(n)
+		})
+		b.netsplits[reason] = n
+	} else if time.Since(n.start) < netsplitMaxDelay {
+		n.timer.Reset(netsplitDelay)
+	}
+	n.quits = append(n.quits, netsplitQuit{
+		nick:  nick,
+		msgID: msgID,
+	})
+	return true
+}
+
+// netsplitFlush relays the quits held back for n.
+func (b *Bridge) netsplitFlush(n *netsplit) {
+	b.netsplitLock.Lock()
+	if b.netsplits[n.reason] == n {
+		delete(b.netsplits, n.reason)
+	}
+	quits := n.quits
+	b.netsplitLock.Unlock()
+
+	if len(quits) < netsplitMin {
+		// not a flood: relay the quits as usual
+		for _, q := range quits {
+			b.ircQuit(q.nick, []string{n.reason}, q.msgID)
+		}
+		return
+	}
+	nicks := make([]string, 0, netsplitMaxNicks)
+	for i, q := range quits {
+		if i == netsplitMaxNicks {
+			nicks = append(nicks, "…")
+			break
+		}
+		nicks = append(nicks, q.nick)
+	}
+	servers := patternNetsplit.FindStringSubmatch(n.reason)
+	text := b.localize("netsplit", len(quits), servers[1], servers[2], strings.Join(nicks, ", "))
+	for dc, chs := range b.mappings() {
+		if !relayToDiscord(chs) {
+			continue
+		}
+		b.discordSendFrom("", dc, fmt.Sprintf("%c%s%c", fItalics, text, fReset), "", false)
+	}
+}
@@ -0,0 +1,36 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetsplit(t *testing.T) {
+	h := newHarness(t, "")
+	defer func(delay time.Duration) {
+		netsplitDelay = delay
+	}(netsplitDelay)
+	netsplitDelay = 10 * time.Millisecond
+
+	h.fromIRC(":carol!c@host QUIT :Quit: bye")
+	for _, nick := range []string{"a", "b", "c", "d"} {
+		h.fromIRC(":" + nick + "!u@host QUIT :hub.example.net leaf.example.net")
+	}
+	h.fromIRC(":e!u@host QUIT :other.example.net leaf.example.net")
+	time.Sleep(100 * time.Millisecond)
+
+	sent := h.discord.take()
+	want := map[string]bool{
+		"\u200b*carol*\u200b has quit: Quit: bye":                                                          true,
+		"\u200b*4 users have quit in a netsplit between hub.example.net and leaf.example.net: a, b, c, d*": true,
+		"\u200b*e*\u200b has quit: other.example.net leaf.example.net":                                     true,
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d discord messages, want %d", len(sent), len(want))
+	}
+	for _, m := range sent {
+		if !want[m.Content] {
+			t.Errorf("unexpected discord message %q", m.Content)
+		}
+	}
+}
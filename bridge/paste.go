@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"strings"
+)
+
+// ircBatch is a batch being received: a draft/multiline message sent as several lines,
+// or the chathistory of a channel being searched for relayed messages.
+type ircBatch struct {
+	start    *irc.Message
+	messages []*irc.Message
+}
+
+// ircBatchHold buffers the messages of multiline and requested chathistory batches, returning whether it did.
+// Once a multiline batch ends, its lines are handled as a single message, with the tags of the batch.
+func (b *Bridge) ircBatchHold(c ircConn, m *irc.Message) bool {
+	if m.Command == "BATCH" && len(m.Params) > 0 && len(m.Params[0]) > 1 {
+		ref := m.Params[0][1:]
+		switch m.Params[0][0] {
+		case '+':
+			if len(m.Params) < 3 {
+				return false
+			}
+			switch m.Params[1] {
+			case "draft/multiline":
+			case "chathistory":
+				if !b.historyPending(m.Params[2]) {
+					return false
+				}
+			default:
+				return false
+			}
+			b.ircBatches[ref] = &ircBatch{start: m}
+			return true
+		case '-':
+			batch, ok := b.ircBatches[ref]
+			if !ok {
+				return false
+			}
+			delete(b.ircBatches, ref)
+			if batch.start.Params[1] == "chathistory" {
+				b.historyBatch(c, batch)
+			} else if len(batch.messages) > 0 {
+				b.ircHandler(c, batch.message())
+			}
+			return true
+		}
+		return false
+	}
+	ref, ok := m.Tags["batch"]
+	if !ok {
+		return false
+	}
+	batch, ok := b.ircBatches[string(ref)]
+	if !ok {
+		return false
+	}
+	batch.messages = append(batch.messages, m)
+	return true
+}
+
+// message returns the message sent as the lines of the batch.
+func (b *ircBatch) message() *irc.Message {
+	m := b.messages[0].Copy()
+	var sb strings.Builder
+	for i, line := range b.messages {
+		if i > 0 {
+			if _, ok := line.Tags["draft/multiline-concat"]; !ok {
+				sb.WriteByte('\n')
+			}
+		}
+		sb.WriteString(line.Trailing())
+	}
+	m.Params = []string{b.start.Params[2], sb.String()}
+	delete(m.Tags, "batch")
+	delete(m.Tags, "draft/multiline-concat")
+	for k, v := range b.start.Tags {
+		m.Tags[k] = v
+	}
+	return m
+}
+
+// pasteCode returns whether lines look like a paste of code, to be relayed as a code block
+// preserving their indentation.
+func pasteCode(lines []string) bool {
+	if len(lines) < 2 {
+		return false
+	}
+	code := 0
+	for _, line := range lines {
+		line = stripFormatting(line)
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case line[0] == ' ' || line[0] == '\t':
+			code++
+		case strings.IndexByte("{}[];", trimmed[len(trimmed)-1]) >= 0:
+			code++
+		case strings.HasPrefix(trimmed, "//"):
+			code++
+		}
+	}
+	return code*2 >= len(lines)
+}
+
+// pasteRelay relays the lines of code pasted by name to Discord channel dc as a code block,
+// returning the message in which each line was posted.
+func (b *Bridge) pasteRelay(dc string, name string, ids []string, lines []string) []*discordgo.Message {
+	header := discordFormat(fmt.Sprintf("%c<%s>%c", fBold, name, fReset))
+	code := make([]string, len(lines))
+	for i, line := range lines {
+		// do not end the code block
+		code[i] = strings.ReplaceAll(stripFormatting(line), "```", "`\u200b``")
+	}
+	return b.discordPostCode(dc, header, "", ids, code)
+}
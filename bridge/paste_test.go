@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestRelayMultilineBatch(t *testing.T) {
+	h := newHarness(t, "")
+	h.fromIRC("@msgid=b1 :carol!c@host BATCH +ref draft/multiline #test")
+	h.fromIRC("@batch=ref :carol!c@host PRIVMSG #test :hello")
+	h.fromIRC("@batch=ref :carol!c@host PRIVMSG #test :wor")
+	h.fromIRC("@batch=ref;draft/multiline-concat :carol!c@host PRIVMSG #test :ld")
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Fatalf("got %d discord messages before the end of the batch, want 0", len(sent))
+	}
+	h.fromIRC(":carol!c@host BATCH -ref")
+
+	h.fromIRC("@msgid=b2 :carol!c@host BATCH +code draft/multiline #test")
+	h.fromIRC("@batch=code :carol!c@host PRIVMSG #test :func main() {")
+	h.fromIRC("@batch=code :carol!c@host PRIVMSG #test :\tfmt.Println(\"a_b```\")")
+	h.fromIRC("@batch=code :carol!c@host PRIVMSG #test :}")
+	h.fromIRC(":carol!c@host BATCH -code")
+
+	sent := h.discord.take()
+	want := []string{
+		"\u200b**<carol>**\u200b hello\nworld",
+		"\u200b**<carol>**\n```\nfunc main() {\n\tfmt.Println(\"a_b`\u200b``\")\n}\n```",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d discord messages, want %d", len(sent), len(want))
+	}
+	for i, m := range sent {
+		if m.Content != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, m.Content, want[i])
+		}
+	}
+	if ids := h.b.discordIDs("b2"); len(ids) != 1 || ids[0] != sent[1].ID {
+		t.Errorf("got discord IDs %v for b2, want [%v]", ids, sent[1].ID)
+	}
+}
+
+func TestRelayCoalescedPaste(t *testing.T) {
+	h := newHarness(t, "coalesce: 1h\n")
+	h.fromIRC("@msgid=p1 :carol!c@host PRIVMSG #test :def f(x):")
+	h.fromIRC("@msgid=p2 :carol!c@host PRIVMSG #test :    return x")
+	h.b.coalesceFlush("discord " + testChannel)
+
+	sent := h.discord.take()
+	want := "\u200b**<carol>**\n```\ndef f(x):\n    return x\n```"
+	if len(sent) != 1 || sent[0].Content != want {
+		t.Fatalf("got discord messages %v, want [%q]", sent, want)
+	}
+	if ids := h.b.discordIDs("p2"); len(ids) != 1 || ids[0] != sent[0].ID {
+		t.Errorf("got discord IDs %v for p2, want [%v]", ids, sent[0].ID)
+	}
+}
+
+func TestMultilineRequiresBatch(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.ircRegistered = true
+	h.fromIRC(":server CAP bridge ACK :draft/multiline")
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].String() != "CAP REQ -draft/multiline" {
+		t.Errorf("got irc messages %v, want draft/multiline disabled without batch", sent)
+	}
+	h.fromIRC(":server CAP bridge ACK :batch")
+	h.fromIRC(":server CAP bridge ACK :draft/multiline")
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v, want draft/multiline kept with batch", sent)
+	}
+}
@@ -0,0 +1,24 @@
+package bridge
+
+import (
+	"gopkg.in/irc.v3"
+)
+
+// ircSenderAccount returns the services account the sender of m is identified to, or an empty string.
+// With account-tag, the messages of users not logged in have no account tag; otherwise the account
+// tracked from extended-join and account-notify is used.
+func (b *Bridge) ircSenderAccount(m *irc.Message) string {
+	if account, ok := m.Tags["account"]; ok || b.ircCapsEnabled("account-tag") {
+		if account == "*" {
+			return ""
+		}
+		return string(account)
+	}
+	return b.ircAccount(m.Name)
+}
+
+// ircPrivileged returns whether the sender of m may mention roles and delete Discord messages: unless
+// cfg.RequireAccount is false, only if identified to services, as nicks can be used by anyone.
+func (b *Bridge) ircPrivileged(m *irc.Message) bool {
+	return (b.cfg.RequireAccount != nil && !*b.cfg.RequireAccount) || b.ircSenderAccount(m) != ""
+}
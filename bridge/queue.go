@@ -0,0 +1,294 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/bwmarrin/discordgo"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queueMaxAge is the age after which messages not relayed before a restart are dropped rather than replayed.
+const queueMaxAge = 24 * time.Hour
+
+// queueEntry is a message being relayed, or the acknowledgement that entry ID was relayed.
+type queueEntry struct {
+	ID   uint64 `json:"id"`
+	Done bool   `json:"done,omitempty"`
+	Time int64  `json:"time,omitempty"` // Unix time at which the entry was queued
+	// messages received, until their relays are queued
+	Message  *discordgo.Message `json:"message,omitempty"`  // from Discord
+	Received string             `json:"received,omitempty"` // from IRC
+	// relays to Discord
+	Channel string `json:"channel,omitempty"`
+	Content string `json:"content,omitempty"`
+	Origin  string `json:"origin,omitempty"` // ID of the relayed IRC message
+	ReplyID string `json:"replyID,omitempty"`
+	NoRoles bool   `json:"noRoles,omitempty"` // from an IRC user not allowed to mention roles
+	// relays to IRC
+	Line string `json:"line,omitempty"`
+}
+
+// outQueue persists the messages of one side of the bridge being relayed to a journal file: the messages
+// received from that side from when they are accepted for relay until their relays are queued, and the
+// relays to that side until they are sent. Messages received but not relayed yet when the bridge stops
+// are relayed after a restart, and relays failing while the side is down are sent again once it is up.
+type outQueue struct {
+	name string
+
+	lock     sync.Mutex
+	path     string
+	f        *os.File
+	w        *bufio.Writer
+	lastID   uint64
+	pending  map[uint64]*queueEntry
+	accepted map[string]*queueRef // source message ID to its received entry
+	records  int                  // entries in the journal, for compaction
+	replay   []queueEntry         // entries of the previous run and failed relays, to relay again
+}
+
+// queueRef is a received message still being relayed, retained by the stages delaying its relays.
+type queueRef struct {
+	id   uint64
+	refs int
+}
+
+// queueOpen opens the relay queues in dir.
+func (b *Bridge) queueOpen(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := b.discordQueue.open(dir); err != nil {
+		return err
+	}
+	return b.ircQueue.open(dir)
+}
+
+// open loads the entries of the previous run not relayed yet, and starts a new journal.
+func (q *outQueue) open(dir string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	path := filepath.Join(dir, q.name+".jsonl")
+	q.path = path
+	pending := make(map[uint64]queueEntry)
+	f, err := os.Open(path)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var e queueEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// a partial line written when stopping
+				continue
+			}
+			if e.Done {
+				delete(pending, e.ID)
+			} else {
+				pending[e.ID] = e
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, e := range pending {
+		if time.Since(time.Unix(e.Time, 0)) < queueMaxAge {
+			q.replay = append(q.replay, e)
+		}
+	}
+	sort.Slice(q.replay, func(i, j int) bool {
+		return q.replay[i].ID < q.replay[j].ID
+	})
+	if len(q.replay) > 0 {
+		logErr.Printf("relaying %d %v messages queued before restart", len(q.replay), q.name)
+	}
+
+	// the new journal starts with the entries to replay, until they are relayed
+	q.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	q.w = bufio.NewWriter(q.f)
+	q.pending = make(map[uint64]*queueEntry)
+	q.accepted = make(map[string]*queueRef)
+	for i := range q.replay {
+		e := &q.replay[i]
+		q.pending[e.ID] = e
+		q.lastID = e.ID
+		q.write(e)
+	}
+	return nil
+}
+
+// write appends e to the journal. q.lock must be held.
+func (q *outQueue) write(e *queueEntry) {
+	b, err := json.Marshal(e)
+	if err == nil {
+		q.w.Write(b)
+		q.w.WriteByte('\n')
+		// the entry only needs to survive a crash of the bridge, not of the system: don't sync
+		err = q.w.Flush()
+	}
+	if err != nil {
+		logErr.Printf("writing %v relay queue: %v", q.name, err)
+	}
+	q.records++
+}
+
+// add queues e before relaying it, returning its queue ID, or 0 if the queue is disabled.
+func (q *outQueue) add(e queueEntry) uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.f == nil {
+		return 0
+	}
+	return q.addLocked(e)
+}
+
+// addLocked is add with q.lock held and the queue enabled.
+func (q *outQueue) addLocked(e queueEntry) uint64 {
+	q.lastID++
+	e.ID = q.lastID
+	e.Time = time.Now().Unix()
+	q.pending[e.ID] = &e
+	q.write(&e)
+	return e.ID
+}
+
+// accept queues e, a message received with ID source, from when it is accepted for relay. It returns
+// the function to call once its handling is done: the entry is removed once its handling is done and the
+// stages delaying its relays have released it, as its relays are then queued.
+func (q *outQueue) accept(source string, e queueEntry) (release func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.f == nil {
+		return func() {}
+	}
+	if source == "" {
+		// relays of messages without an ID cannot be tracked through the delaying stages
+		id := q.addLocked(e)
+		return func() {
+			q.done(id)
+		}
+	}
+	if ref, ok := q.accepted[source]; ok {
+		// received again, e.g. played back
+		ref.refs++
+	} else {
+		q.accepted[source] = &queueRef{id: q.addLocked(e), refs: 1}
+	}
+	return func() {
+		q.release(source)
+	}
+}
+
+// retain keeps the received message source queued while a stage delays its relay.
+func (q *outQueue) retain(source string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if ref, ok := q.accepted[source]; ok {
+		ref.refs++
+	}
+}
+
+// release ends a retain of the received message source, removing it from the queue once
+// nothing retains it anymore.
+func (q *outQueue) release(source string) {
+	q.lock.Lock()
+	ref, ok := q.accepted[source]
+	if !ok {
+		q.lock.Unlock()
+		return
+	}
+	ref.refs--
+	if ref.refs > 0 {
+		q.lock.Unlock()
+		return
+	}
+	delete(q.accepted, source)
+	q.lock.Unlock()
+	q.done(ref.id)
+}
+
+// finish removes entry id from the queue once delivered, or keeps it to be relayed again
+// on the next connection if it failed.
+func (q *outQueue) finish(id uint64, delivered bool) {
+	if delivered {
+		q.done(id)
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	e, ok := q.pending[id]
+	if !ok {
+		return
+	}
+	q.replay = append(q.replay, *e)
+	sort.Slice(q.replay, func(i, j int) bool {
+		return q.replay[i].ID < q.replay[j].ID
+	})
+}
+
+// done removes entry id from the queue, once relayed or dropped.
+func (q *outQueue) done(id uint64) {
+	if id == 0 {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.pending[id]; !ok {
+		return
+	}
+	delete(q.pending, id)
+	q.write(&queueEntry{
+		ID:   id,
+		Done: true,
+	})
+	if q.records > 1024 && q.records > 4*len(q.pending) {
+		q.compact()
+	}
+}
+
+// compact rewrites the journal with only the pending entries. q.lock must be held.
+func (q *outQueue) compact() {
+	f, err := os.OpenFile(q.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		logErr.Printf("compacting %v relay queue: %v", q.name, err)
+		return
+	}
+	ids := make([]uint64, 0, len(q.pending))
+	for id := range q.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	old := q.f
+	q.f = f
+	q.w = bufio.NewWriter(f)
+	q.records = 0
+	for _, id := range ids {
+		q.write(q.pending[id])
+	}
+	if err := os.Rename(q.path+".tmp", q.path); err != nil {
+		logErr.Printf("compacting %v relay queue: %v", q.name, err)
+	}
+	old.Close()
+}
+
+// takeReplay returns the entries of the previous run and the failed relays to relay again, once.
+// Each entry must be marked done once relayed.
+func (q *outQueue) takeReplay() []queueEntry {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	replay := q.replay
+	q.replay = nil
+	return replay
+}
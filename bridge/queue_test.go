@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	q := &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	relayed := q.add(queueEntry{Line: "PRIVMSG #test :relayed"})
+	q.add(queueEntry{Line: "PRIVMSG #test :pending 1"})
+	q.add(queueEntry{Line: "PRIVMSG #test :pending 2"})
+	q.done(relayed)
+	// stop without relaying the pending entries
+	q.f.Close()
+
+	q = &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	replay := q.takeReplay()
+	if len(replay) != 2 || replay[0].Line != "PRIVMSG #test :pending 1" || replay[1].Line != "PRIVMSG #test :pending 2" {
+		t.Fatalf("got replay %v, want the 2 pending entries in order", replay)
+	}
+	if replay := q.takeReplay(); len(replay) != 0 {
+		t.Errorf("got replay %v again", replay)
+	}
+
+	// entries are replayed until relayed
+	q.done(replay[0].ID)
+	q.f.Close()
+	q = &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	replay = q.takeReplay()
+	if len(replay) != 1 || replay[0].Line != "PRIVMSG #test :pending 2" {
+		t.Fatalf("got replay %v, want the entry not relayed", replay)
+	}
+	q.done(replay[0].ID)
+	q.f.Close()
+	q = &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if replay := q.takeReplay(); len(replay) != 0 {
+		t.Errorf("got replay %v after another restart", replay)
+	}
+}
+
+func TestQueueCompact(t *testing.T) {
+	dir := t.TempDir()
+	q := &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	pending := q.add(queueEntry{Line: "PRIVMSG #test :pending"})
+	for i := 0; i < 2000; i++ {
+		q.done(q.add(queueEntry{Line: "PRIVMSG #test :relayed"}))
+	}
+	if q.records > 1100 {
+		t.Errorf("got %d records, want the journal compacted", q.records)
+	}
+	q.f.Close()
+
+	q = &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if replay := q.takeReplay(); len(replay) != 1 || replay[0].ID != pending {
+		t.Errorf("got replay %v, want the pending entry", replay)
+	}
+}
+
+func TestQueueAccept(t *testing.T) {
+	dir := t.TempDir()
+	q := &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	release := q.accept("1", queueEntry{Received: "PRIVMSG #test :held"})
+	q.retain("1") // e.g. while coalescing
+	release()
+	q.accept("2", queueEntry{Received: "PRIVMSG #test :relayed"})()
+	failed := q.add(queueEntry{Line: "PRIVMSG #test :failed"})
+	q.finish(failed, false)
+	if replay := q.takeReplay(); len(replay) != 1 || replay[0].ID != failed {
+		t.Fatalf("got replay %v, want the failed entry", replay)
+	}
+	// stop while the first message is retained, without relaying the failed entry
+	q.f.Close()
+
+	q = &outQueue{name: "test"}
+	if err := q.open(dir); err != nil {
+		t.Fatal(err)
+	}
+	replay := q.takeReplay()
+	if len(replay) != 2 || replay[0].Received != "PRIVMSG #test :held" || replay[1].Line != "PRIVMSG #test :failed" {
+		t.Fatalf("got replay %v, want the retained and failed entries", replay)
+	}
+}
@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"gopkg.in/irc.v3"
+	"time"
+)
+
+// readMarkerDelay is how long read markers are batched before being sent, to avoid sending one per message.
+const readMarkerDelay = 5 * time.Second
+
+// readMarkerSet marks the messages of IRC channel ic as read up to the server time serverTime,
+// for the other clients of a bouncer account shared with the bridge.
+func (b *Bridge) readMarkerSet(ic string, serverTime string) {
+	if serverTime == "" || !b.ircCapsEnabled("draft/read-marker") {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, serverTime)
+	if err != nil {
+		return
+	}
+	b.readMarkersLock.Lock()
+	defer b.readMarkersLock.Unlock()
+	if !t.After(b.readMarkers[ic]) {
+		return
+	}
+	// keep the latest time across flushes, so that older messages, e.g. played back, never move the marker back
+	b.readMarkers[ic] = t
+	b.readMarkersSet[ic] = true
+	if b.readMarkerTimer == nil {
+		b.readMarkerTimer = time.AfterFunc(readMarkerDelay, b.readMarkerFlush)
+	}
+}
+
+// readMarkerFlush sends the read markers set since the last flush.
+func (b *Bridge) readMarkerFlush() {
+	b.readMarkersLock.Lock()
+	markers := make(map[string]time.Time, len(b.readMarkersSet))
+	for ic := range b.readMarkersSet {
+		markers[ic] = b.readMarkers[ic]
+	}
+	b.readMarkersSet = make(map[string]bool)
+	if b.readMarkerTimer != nil {
+		b.readMarkerTimer.Stop()
+		b.readMarkerTimer = nil
+	}
+	b.readMarkersLock.Unlock()
+	for ic, t := range markers {
+		b.ircWrite(&irc.Message{
+			Command: "MARKREAD",
+			Params:  []string{ic, "timestamp=" + t.UTC().Format("2006-01-02T15:04:05.000Z")},
+		})
+	}
+}
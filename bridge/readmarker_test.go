@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadMarker(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.ircCaps["draft/read-marker"] = true
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	layout := "2006-01-02T15:04:05.000Z"
+	h.fromIRC("@time=" + now.Format(layout) + " :carol!c@host PRIVMSG #test :first")
+	h.fromIRC("@time=" + now.Add(5*time.Second).Format(layout) + " :carol!c@host PRIVMSG #test :second")
+	h.fromIRC("@time=" + now.Add(time.Second).Format(layout) + " :carol!c@host PRIVMSG #test :late")
+	h.irc.take()
+	h.b.readMarkerFlush()
+
+	sent := h.irc.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d irc messages, want 1", len(sent))
+	}
+	if got, want := sent[0].String(), "MARKREAD #test timestamp="+now.Add(5*time.Second).Format(layout); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	h.b.readMarkerFlush()
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got %d irc messages after flushing again, want 0", len(sent))
+	}
+
+	// older than the marker sent
+	h.fromIRC("@time=" + now.Add(2*time.Second).Format(layout) + " :carol!c@host PRIVMSG #test :played back")
+	h.irc.take()
+	h.b.readMarkerFlush()
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v moving the read marker back, want none", sent)
+	}
+}
@@ -0,0 +1,887 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayIRCToDiscord(t *testing.T) {
+	h := newHarness(t, "")
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :hello \x02world")
+	h.fromIRC("@msgid=i2 :carol!c@host PRIVMSG #test :snake_case *stars*")
+	h.fromIRC("@msgid=i3 :carol!c@host PRIVMSG #test :\x01ACTION waves\x01")
+
+	sent := h.discord.take()
+	want := []string{
+		"\u200b**<carol>**\u200b hello \u200b**world**",
+		"\u200b**<carol>**\u200b snake\\_case \\*stars\\*",
+		"\u200b**<carol>**\u200b \u200b*waves*",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d discord messages, want %d", len(sent), len(want))
+	}
+	for i, m := range sent {
+		if m.ChannelID != testChannel {
+			t.Errorf("message %d: got channel %v, want %v", i, m.ChannelID, testChannel)
+		}
+		if m.Content != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, m.Content, want[i])
+		}
+	}
+	if ids := h.b.discordIDs("i1"); len(ids) != 1 || ids[0] != sent[0].ID {
+		t.Errorf("got discord IDs %v for i1, want [%v]", ids, sent[0].ID)
+	}
+}
+
+func TestRelayDiscordToIRC(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\n")
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "hi **there**\nsecond line", nil)
+
+	sent := h.irc.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d irc messages, want 1", len(sent))
+	}
+	if sent[0].Command != "PRIVMSG" || sent[0].Params[0] != "#test" {
+		t.Errorf("got %v, want a PRIVMSG to #test", sent[0])
+	}
+	if want := "<a\u200blice> hi \x02there\x02 second line"; sent[0].Params[1] != want {
+		t.Errorf("got %q, want %q", sent[0].Params[1], want)
+	}
+	if id := string(sent[0].Tags["+discord"]); id != m.ID {
+		t.Errorf("got +discord tag %q, want %q", id, m.ID)
+	}
+}
+
+func TestRelayIRCReply(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "question", nil)
+	h.echo("e")
+
+	// the reply to the echoed message is relayed as a reply to the original Discord message
+	h.fromIRC("@msgid=i1;+draft/reply=e0 :carol!c@host PRIVMSG #test :bridge: answer")
+	sent := h.discord.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d discord messages, want 1", len(sent))
+	}
+	if sent[0].MessageReference == nil || sent[0].MessageReference.MessageID != m.ID {
+		t.Errorf("got reference %v, want a reply to %v", sent[0].MessageReference, m.ID)
+	}
+	if want := "\u200b**<carol>**\u200b answer"; sent[0].Content != want {
+		t.Errorf("got %q, want %q", sent[0].Content, want)
+	}
+}
+
+func TestRelayDiscordReply(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\n")
+	alice := h.addMember("500", "alice", "")
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :question")
+	parent := h.discord.take()[0]
+
+	h.fromDiscord(alice, "answer", parent)
+	sent := h.irc.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d irc messages, want 1", len(sent))
+	}
+	if reply := string(sent[0].Tags["+draft/reply"]); reply != "i1" {
+		t.Errorf("got +draft/reply tag %q, want %q", reply, "i1")
+	}
+}
+
+func TestRelayIRCRedaction(t *testing.T) {
+	h := newHarness(t, "")
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :oops")
+	m := h.discord.take()[0]
+
+	h.fromIRC("@account=carol :carol!c@host REDACT #test i1")
+	if len(h.discord.deleted) != 1 || h.discord.deleted[0] != m.ID {
+		t.Errorf("got deleted discord messages %v, want [%v]", h.discord.deleted, m.ID)
+	}
+}
+
+func TestRelayDiscordDeletion(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "oops", nil)
+	h.echo("e")
+
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: m})
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].Command != "REDACT" || sent[0].Params[0] != "#test" || sent[0].Params[1] != "e0" {
+		t.Errorf("got %v, want a REDACT of e0 in #test", sent)
+	}
+}
+
+func TestRelayOwnMessages(t *testing.T) {
+	h := newHarness(t, "")
+	own := &discordgo.Member{
+		GuildID: testGuild,
+		User:    h.discord.st.User,
+	}
+	h.fromDiscord(own, "relayed", nil)
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("relayed own discord message: %v", sent)
+	}
+
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "hello", nil)
+	h.echo("e")
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Errorf("relayed own irc message: %v", sent)
+	}
+}
+
+func TestRelayCTCP(t *testing.T) {
+	h := newHarness(t, "ctcp: [\"dice\"]\n")
+	h.fromIRC(":carol!c@host PRIVMSG #test :\x01DICE 1d6 4\x01")
+	h.fromIRC(":carol!c@host PRIVMSG #test :\x01VERSION\x01")
+	h.fromIRC(":carol!c@host PRIVMSG #test :\x01dice\x01")
+
+	sent := h.discord.take()
+	want := []string{
+		"\u200b**<carol>**\u200b [CTCP DICE] 1d6 4",
+		"\u200b**<carol>**\u200b [CTCP DICE]",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got %d discord messages, want %d", len(sent), len(want))
+	}
+	for i, m := range sent {
+		if m.Content != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, m.Content, want[i])
+		}
+	}
+}
+
+func TestRelayInvite(t *testing.T) {
+	h := newHarness(t, "")
+	h.fromIRC(":carol!c@host INVITE dave #test")
+	sent := h.discord.take()
+	if want := "\u200b*dave*\u200b was invited to the channel by carol"; len(sent) != 1 || sent[0].Content != want {
+		t.Fatalf("got discord messages %v, want [%q]", sent, want)
+	}
+
+	// invited to a mapped channel the bridge is not in
+	h.fromIRC(":carol!c@host INVITE bridge #test")
+	h.fromIRC(":carol!c@host INVITE bridge #other")
+	joins := 0
+	for _, m := range h.irc.take() {
+		if m.Command == "JOIN" {
+			joins++
+			if m.Params[0] != "#test" {
+				t.Errorf("joined %v, want #test", m.Params[0])
+			}
+		}
+	}
+	if joins != 1 {
+		t.Errorf("got %d joins, want 1", joins)
+	}
+	// casefolded by the server
+	h.fromIRC(":bridge!b@host JOIN #TEST")
+	h.fromIRC(":carol!c@host INVITE bridge #test")
+	for _, m := range h.irc.take() {
+		if m.Command == "JOIN" {
+			t.Errorf("joined %v again", m.Params[0])
+		}
+	}
+}
+
+func TestRejoinReconnect(t *testing.T) {
+	h := newHarness(t, "rejoinDelay: 20ms\n")
+	h.fromIRC(":bridge!b@host JOIN #test")
+	h.fromIRC(":carol!c@host KICK #Test bridge :bye")
+	time.Sleep(50 * time.Millisecond)
+	if sent := h.irc.take(); len(sent) != 1 || sent[0].String() != "JOIN #test" {
+		t.Fatalf("got irc messages %v after a kick, want a rejoin of #test", sent)
+	}
+
+	h.fromIRC(":carol!c@host KICK #test bridge :bye")
+	// reconnected before the rejoin
+	h.b.ircClientLock.Lock()
+	h.b.ircClient = &fakeIRC{nick: "bridge"}
+	h.b.ircClientLock.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if sent := h.b.ircClient.(*fakeIRC).take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v on the new connection, want none", sent)
+	}
+}
+
+func TestRedactForbidden(t *testing.T) {
+	h := newHarness(t, "chanserv:\n  op: \"OP {channel}\"\n")
+	defer func(delay time.Duration) {
+		redactRetryDelay = delay
+	}(redactRetryDelay)
+	redactRetryDelay = 10 * time.Millisecond
+
+	fail := ":irc.example.com FAIL REDACT REDACT_FORBIDDEN #test i1 :You are not authorised to delete this message"
+	h.fromIRC(fail)
+	time.Sleep(50 * time.Millisecond)
+	sent := h.irc.take()
+	if len(sent) != 2 || sent[0].String() != "PRIVMSG ChanServ :OP #test" || sent[1].String() != "REDACT #test i1" {
+		t.Fatalf("got irc messages %v, want an op request and a redaction", sent)
+	}
+	// retried only once
+	h.fromIRC(fail)
+	time.Sleep(50 * time.Millisecond)
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Errorf("got irc messages %v after failing again, want none", sent)
+	}
+}
+
+func TestOperLogin(t *testing.T) {
+	h := newHarness(t, "oper:\n  name: bridge\n  password: secret\n")
+	h.fromIRC(":irc.example.com 001 bridge :Welcome")
+	sent := h.irc.take()
+	if len(sent) == 0 || sent[0].String() != "OPER bridge secret" {
+		t.Errorf("got irc messages %v, want OPER first", sent)
+	}
+}
+
+func TestRelayDiscordDeletionHistory(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.ircCaps["batch"] = true
+	h.b.ircCaps["draft/chathistory"] = true
+	h.b.ircCaps["draft/message-redaction"] = true
+	h.fromIRC("@msgid=i1;+discord=900 :bridge!b@host PRIVMSG #test :<alice> deleted")
+	h.fromIRC("@msgid=i2;+discord=901 :bridge!b@host PRIVMSG #test :<bob> gone")
+	h.b.idLock.Lock()
+	h.b.idEvict("900")
+	h.b.idEvict("901")
+	h.b.idLock.Unlock()
+
+	// never relayed
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "902", ChannelID: testChannel}})
+	if sent := h.irc.take(); len(sent) != 0 {
+		t.Fatalf("got irc messages %v for a message never relayed, want none", sent)
+	}
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "900", ChannelID: testChannel}})
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "901", ChannelID: testChannel}})
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].String() != "CHATHISTORY LATEST #test * 100" {
+		t.Fatalf("got irc messages %v, want a history lookup", sent)
+	}
+
+	h.fromIRC(":irc.example.com FAIL CHATHISTORY INVALID_TARGET LATEST #other :no history")
+	if !h.b.historyPending("#test") {
+		t.Fatalf("cleared the lookup of #test on the failure of #other")
+	}
+	h.fromIRC(":irc.example.com BATCH +h chathistory #test")
+	h.fromIRC("@batch=h;msgid=h1;+discord=899 :bridge!b@host PRIVMSG #test :<alice> other")
+	h.fromIRC("@batch=h;msgid=h2;+discord=900 :bridge!b@host PRIVMSG #test :<alice> deleted")
+	h.fromIRC("@batch=h;msgid=h3;+discord=900 :mallory!m@host PRIVMSG #test :spoofed")
+	// a server not keeping the client tags
+	h.fromIRC("@batch=h;msgid=h4 :bridge!b@host PRIVMSG #test :<bob> gone")
+	h.fromIRC("@batch=h;msgid=h5 :bridge!b@host PRIVMSG #test :<bob> gone")
+	h.fromIRC(":irc.example.com BATCH -h")
+	sent = h.irc.take()
+	if len(sent) != 2 || sent[0].String() != "REDACT #test h2" || sent[1].String() != "REDACT #test h4" {
+		t.Errorf("got irc messages %v, want the redactions of h2 and h4", sent)
+	}
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Errorf("relayed %d history messages to discord", len(sent))
+	}
+}
+
+func TestIDEviction(t *testing.T) {
+	h := newHarness(t, "")
+	for i := 0; i <= idMaxCached; i++ {
+		h.b.correlate(fmt.Sprintf("i%d", i), fmt.Sprintf("d%d", i))
+	}
+	if ids := h.b.ircIDs("d0"); len(ids) != 0 {
+		t.Errorf("got irc ids %v for the oldest message, want it evicted", ids)
+	}
+	if ids := h.b.ircIDs(fmt.Sprintf("d%d", idMaxCached)); len(ids) != 1 {
+		t.Errorf("got irc ids %v for the newest message, want it kept", ids)
+	}
+}
+
+func TestRoleMentions(t *testing.T) {
+	h := newHarness(t, "")
+	for _, r := range []*discordgo.Role{
+		{ID: "30", Name: "mods", Mentionable: true},
+		{ID: "31", Name: "everybody", Mentionable: true},
+		{ID: "32", Name: "admins"},
+	} {
+		if err := h.discord.st.RoleAdd(testGuild, r); err != nil {
+			t.Fatalf("adding role: %v", err)
+		}
+	}
+	ch := h.b.cfg.Channels[testChannel][0]
+	for _, tc := range []struct {
+		policy string
+		roles  []string
+		want   string
+	}{
+		{roleMentionsAllow, nil, "<@&30> <@&31> @admins"},
+		{roleMentionsWhitelist, []string{"Mods", "32"}, "<@&30> @everybody @admins"},
+		{roleMentionsText, nil, "@mods @everybody @admins"},
+	} {
+		ch.RoleMentions = tc.policy
+		ch.MentionRoles = tc.roles
+		h.fromIRC(":carol!c@host PRIVMSG #test :@mods @everybody @admins")
+		sent := h.discord.take()
+		want := "\u200b**<carol>**\u200b " + tc.want
+		if len(sent) != 1 || sent[0].Content != want {
+			t.Errorf("%v: got discord messages %v, want %q", tc.policy, sent, want)
+		}
+	}
+}
+
+func TestAllowedMentions(t *testing.T) {
+	h := newHarness(t, "")
+	for _, r := range []*discordgo.Role{
+		{ID: "30", Name: "mods", Mentionable: true},
+		{ID: "31", Name: "admins"},
+	} {
+		if err := h.discord.st.RoleAdd(testGuild, r); err != nil {
+			t.Fatalf("adding role: %v", err)
+		}
+	}
+	h.fromIRC("@account=carol :carol!c@host PRIVMSG #test :`@everyone` <@&30> <@&31> @mods")
+	sent := h.discord.take()
+	if len(sent) != 1 {
+		t.Fatalf("got %d discord messages, want 1", len(sent))
+	}
+	allowed := h.discord.sends[sent[0].ID].AllowedMentions
+	if allowed == nil || len(allowed.Parse) != 1 || allowed.Parse[0] != discordgo.AllowedMentionTypeUsers {
+		t.Fatalf("got allowed mentions %+v, want only users parsed", allowed)
+	}
+	if len(allowed.Roles) != 1 || allowed.Roles[0] != "30" {
+		t.Errorf("got allowed roles %v, want [30]", allowed.Roles)
+	}
+
+	h.b.cfg.Channels[testChannel][0].RoleMentions = roleMentionsText
+	h.fromIRC("@account=carol :carol!c@host PRIVMSG #test :<@&30>")
+	sent = h.discord.take()
+	if allowed := h.discord.sends[sent[0].ID].AllowedMentions; len(allowed.Roles) != 0 {
+		t.Errorf("got allowed roles %v with role mentions as text, want none", allowed.Roles)
+	}
+}
+
+func TestRequireAccount(t *testing.T) {
+	h := newHarness(t, "")
+	if err := h.discord.st.RoleAdd(testGuild, &discordgo.Role{ID: "30", Name: "mods", Mentionable: true}); err != nil {
+		t.Fatalf("adding role: %v", err)
+	}
+	for _, tc := range []struct {
+		line  string
+		roles int
+	}{
+		{":carol!c@host PRIVMSG #test :@mods", 0},
+		{"@account=carol :carol!c@host PRIVMSG #test :@mods", 1},
+		// membership relays never mention roles
+		{"@account=carol :carol!c@host PART #test :@mods", 0},
+		{"@account=carol :carol!c@host QUIT :@mods", 0},
+	} {
+		h.fromIRC(tc.line)
+		sent := h.discord.take()
+		if len(sent) != 1 {
+			t.Fatalf("%q: got %d discord messages, want 1", tc.line, len(sent))
+		}
+		if allowed := h.discord.sends[sent[0].ID].AllowedMentions; len(allowed.Roles) != tc.roles {
+			t.Errorf("%q: got allowed roles %v, want %d", tc.line, allowed.Roles, tc.roles)
+		}
+	}
+
+	h.b.correlate("i1", "900")
+	h.b.correlateChannel("900", testChannel)
+	h.fromIRC("@account=* :carol!c@host REDACT #test i1")
+	if len(h.discord.deleted) != 0 {
+		t.Errorf("got deleted discord messages %v from an unidentified user, want none", h.discord.deleted)
+	}
+	h.fromIRC("@account=carol :carol!c@host REDACT #test i1")
+	if len(h.discord.deleted) != 1 || h.discord.deleted[0] != "900" {
+		t.Errorf("got deleted discord messages %v, want [900]", h.discord.deleted)
+	}
+	requireAccount := false
+	h.b.cfg.RequireAccount = &requireAccount
+	h.fromIRC(":carol!c@host PRIVMSG #test :@mods")
+	if sent := h.discord.take(); len(sent) != 1 || len(h.discord.sends[sent[0].ID].AllowedMentions.Roles) != 1 {
+		t.Errorf("got discord messages %v without requireAccount, want a role mention", sent)
+	}
+}
+
+func TestRelayServerNotices(t *testing.T) {
+	h := newHarness(t, "serverNotices: \""+testChannel+"\"\n")
+	if err := h.discord.st.RoleAdd(testGuild, &discordgo.Role{ID: "30", Name: "mods", Mentionable: true}); err != nil {
+		t.Fatalf("adding role: %v", err)
+	}
+	for _, line := range []string{
+		":irc.example.com NOTICE bridge :*** Client connecting: mallory (m@host) [@mods]",
+		":oper!o@host WALLOPS :@mods",
+	} {
+		h.fromIRC(line)
+		sent := h.discord.take()
+		if len(sent) != 1 {
+			t.Fatalf("%q: got %d discord messages, want 1", line, len(sent))
+		}
+		if allowed := h.discord.sends[sent[0].ID].AllowedMentions; allowed == nil || len(allowed.Roles) != 0 {
+			t.Errorf("%q: got allowed mentions %+v, want no roles", line, allowed)
+		}
+	}
+}
+
+func TestRelayChannelLinks(t *testing.T) {
+	h := newHarness(t, "")
+	err := h.discord.st.ChannelAdd(&discordgo.Channel{
+		ID:      "101",
+		GuildID: testGuild,
+		Name:    "random",
+		Type:    discordgo.ChannelTypeGuildText,
+	})
+	if err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	err = h.discord.st.ChannelAdd(&discordgo.Channel{
+		ID:      "102",
+		GuildID: testGuild,
+		Name:    "staff",
+		Type:    discordgo.ChannelTypeGuildText,
+		PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			{ID: testGuild, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+		},
+	})
+	if err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "see https://discord.com/channels/10/100/555, https://discord.com/channels/10/101 and https://discord.com/channels/99/98", nil)
+	sent := h.irc.take()
+	want := "<a\u200blice> see #test (https://discord.com/channels/10/100/555), #random and https://discord.com/channels/99/98"
+	if len(sent) != 1 || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+
+	// hidden from @everyone
+	h.fromDiscord(alice, "see https://discord.com/channels/10/102 and <#102>", nil)
+	sent = h.irc.take()
+	want = "<a\u200blice> see #private-channel and #private-channel"
+	if len(sent) != 1 || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+}
+
+func TestJoinKey(t *testing.T) {
+	h := newHarness(t, "")
+	ch := h.b.cfg.Channels[testChannel][0]
+	ch.IRC = "#test secret"
+	if err := ch.validate(testChannel); err != nil {
+		t.Fatalf("validating channel: %v", err)
+	}
+	if ch.IRC != "#test" || ch.Key != "secret" {
+		t.Errorf("got channel %q with key %q, want #test with key secret", ch.IRC, ch.Key)
+	}
+	h.fromIRC(":irc.example.com 001 bridge :Welcome")
+	found := false
+	for _, m := range h.irc.take() {
+		if m.Command == "JOIN" {
+			found = true
+			if m.String() != "JOIN #test secret" {
+				t.Errorf("got %v, want JOIN #test secret", m)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("got no JOIN")
+	}
+}
+
+func TestJoinNoticeChannels(t *testing.T) {
+	h := newHarness(t, "audit:\n  channel: \"#ops\"\nchannelKeys:\n  \"#ops\": secret\nhealth:\n  ircChannels: [\"#ops\", \"#status\"]\neventChannels:\n  \""+testGuild+"\": [\"#test\", \"#events\"]\n")
+	h.fromIRC(":irc.example.com 001 bridge :Welcome")
+	var joins []string
+	for _, m := range h.irc.take() {
+		if m.Command == "JOIN" {
+			joins = append(joins, m.String())
+		}
+	}
+	if len(joins) != 4 || joins[0] != "JOIN #test" || joins[1] != "JOIN #ops secret" || joins[2] != "JOIN #status" || joins[3] != "JOIN #events" {
+		t.Errorf("got joins %q, want #test, #ops with its key, #status and #events", joins)
+	}
+	if h.b.ircTracked("#ops") == "" {
+		t.Errorf("#ops is not tracked")
+	}
+}
+
+func TestRelayInlineAttachments(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].Attachments.Inline = true
+	alice := h.addMember("500", "alice", "")
+	long := "https://cdn.example.com/" + strings.Repeat("x", 330) + ".png"
+	h.b.discordMessage(h.discord, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        h.discord.nextID(),
+		ChannelID: testChannel,
+		GuildID:   testGuild,
+		Content:   "look",
+		Author:    alice.User,
+		Member:    alice,
+		Timestamp: time.Now(),
+		Attachments: []*discordgo.MessageAttachment{
+			{URL: "https://cdn.example.com/a.png"},
+			{URL: "https://cdn.example.com/b.png"},
+			{URL: long},
+		},
+	}})
+	sent := h.irc.take()
+	want := []string{
+		"<a\u200blice> look https://cdn.example.com/a.png https://cdn.example.com/b.png",
+		"<a\u200blice> " + long,
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if got := stripFormatting(m.Params[1]); got != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestRelayReplyUnbridged(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	bob := h.addMember("501", "bob", "")
+	// a message the bridge never saw, only known to the Discord API
+	parent := &discordgo.Message{
+		ID:        h.discord.nextID(),
+		ChannelID: testChannel,
+		GuildID:   testGuild,
+		Content:   "an old message",
+		Author:    bob.User,
+	}
+	h.discord.store(parent)
+	h.b.discordMessage(h.discord, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:               h.discord.nextID(),
+		ChannelID:        testChannel,
+		GuildID:          testGuild,
+		Content:          "I agree",
+		Author:           alice.User,
+		Member:           alice,
+		Timestamp:        time.Now(),
+		Type:             discordgo.MessageTypeReply,
+		MessageReference: parent.Reference(),
+	}})
+
+	sent := h.irc.take()
+	want := []string{"<a\u200blice> ↩ b\u200bob: an old message", "<a\u200blice> I agree"}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if got := stripFormatting(m.Params[1]); got != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, got, want[i])
+		}
+		if _, ok := m.Tags["+draft/reply"]; ok {
+			t.Errorf("message %d: got reply tag, want none", i)
+		}
+	}
+}
+
+func TestRelayBots(t *testing.T) {
+	h := newHarness(t, "")
+	ch := h.b.cfg.Channels[testChannel][0]
+	ch.AllowBots = []string{"602"}
+	alice := h.addMember("500", "alice", "")
+	rss := h.addMember("601", "rss", "")
+	rss.User.Bot = true
+	music := h.addMember("602", "music", "")
+	music.User.Bot = true
+	for _, tc := range []struct {
+		policy string
+		want   []string
+	}{
+		{botsRelay, []string{"<a\u200blice> hi", "<r\u200bss> news", "<m\u200busic> song"}},
+		{botsMark, []string{"<a\u200blice> hi", "[bot] <r\u200bss> news", "<m\u200busic> song"}},
+		{botsSkip, []string{"<a\u200blice> hi", "<m\u200busic> song"}},
+	} {
+		ch.Bots = tc.policy
+		h.fromDiscord(alice, "hi", nil)
+		h.fromDiscord(rss, "news", nil)
+		h.fromDiscord(music, "song", nil)
+		sent := h.irc.take()
+		if len(sent) != len(tc.want) {
+			t.Fatalf("%s: got irc messages %v, want %d", tc.policy, sent, len(tc.want))
+		}
+		for i, m := range sent {
+			if got := stripFormatting(m.Params[1]); got != tc.want[i] {
+				t.Errorf("%s: message %d: got %q, want %q", tc.policy, i, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestRelayRoles(t *testing.T) {
+	h := newHarness(t, "")
+	if err := h.discord.st.RoleAdd(testGuild, &discordgo.Role{ID: "40", Name: "Verified"}); err != nil {
+		t.Fatalf("adding role: %v", err)
+	}
+	ch := h.b.cfg.Channels[testChannel][0]
+	ch.RelayRoles = []string{"verified"}
+	alice := h.addMember("500", "alice", "")
+	alice.Roles = []string{"40"}
+	bob := h.addMember("501", "bob", "")
+	for _, tc := range []struct {
+		marker string
+		want   []string
+	}{
+		{"", []string{"<a\u200blice> hi"}},
+		{"[unverified]", []string{"<a\u200blice> hi", "[unverified] <b\u200bob> hello"}},
+	} {
+		ch.RoleMarker = tc.marker
+		h.fromDiscord(alice, "hi", nil)
+		h.fromDiscord(bob, "hello", nil)
+		sent := h.irc.take()
+		if len(sent) != len(tc.want) {
+			t.Fatalf("marker %q: got irc messages %v, want %d", tc.marker, sent, len(tc.want))
+		}
+		for i, m := range sent {
+			if got := stripFormatting(m.Params[1]); got != tc.want[i] {
+				t.Errorf("marker %q: message %d: got %q, want %q", tc.marker, i, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestRelayPlain(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].Plain = directionBoth
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "**bold** text\u200b", nil)
+	sent := h.irc.take()
+	if want := "<alice[d]> bold text"; len(sent) != 1 || sent[0].Params[1] != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :\x02bold\x02 \x0304red\x03 *stars*\u200b")
+	dsent := h.discord.take()
+	if want := "<carol> bold red \\*stars\\*"; len(dsent) != 1 || dsent[0].Content != want {
+		t.Errorf("got discord messages %v, want %q", dsent, want)
+	}
+}
+
+func TestRelayMediaLinks(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		line   string
+		want   []string
+	}{
+		{"", "https://example.com/cat.PNG", []string{"\u200b**<carol>**", "https://example.com/cat.PNG"}},
+		{"", "https://example.com/cat.avif", []string{"\u200b**<carol>**\u200b https://example.com/cat.avif"}},
+		{"media:\n  extensions: [avif]\n", "https://example.com/cat.avif", []string{"\u200b**<carol>**", "https://example.com/cat.avif"}},
+		{"media:\n  pattern: '^https://i\\.example\\.com/'\n", "https://i.example.com/cat", []string{"\u200b**<carol>**", "https://i.example.com/cat"}},
+		{"media:\n  disabled: true\n", "https://example.com/cat.png", []string{"\u200b**<carol>**\u200b https://example.com/cat.png"}},
+	} {
+		h := newHarness(t, tc.config)
+		h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :" + tc.line)
+		sent := h.discord.take()
+		if len(sent) != len(tc.want) {
+			t.Fatalf("%q: got discord messages %v, want %d", tc.config, sent, len(tc.want))
+		}
+		for i, m := range sent {
+			if m.Content != tc.want[i] {
+				t.Errorf("%q: message %d: got %q, want %q", tc.config, i, m.Content, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestRelayIdentities(t *testing.T) {
+	h := newHarness(t, `identities:
+  - discord: "600"
+    nicks: ["bob"]
+  - discord: "501"
+    accounts: ["dave"]
+    optOut: true
+`)
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "hi <@600>", nil)
+	sent := h.irc.take()
+	if want := "<a\u200blice> hi @bob"; len(sent) != 1 || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q", sent, want)
+	}
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :@Bob: hello")
+	dsent := h.discord.take()
+	if want := "\u200b**<carol>**\u200b <@600>: hello"; len(dsent) != 1 || dsent[0].Content != want {
+		t.Errorf("got discord messages %v, want %q", dsent, want)
+	}
+
+	// opted out, by account on IRC whatever the nick
+	dave := h.addMember("501", "dave", "")
+	m := h.fromDiscord(dave, "hello", nil)
+	edited := *m
+	edited.Content = "hello there"
+	now := time.Now()
+	edited.EditedTimestamp = &now
+	h.b.discordMessageUpdate(h.discord, &discordgo.MessageUpdate{Message: &edited, BeforeUpdate: m})
+	h.b.discordTyping(h.discord, &discordgo.TypingStart{UserID: "501", ChannelID: testChannel})
+	h.fromIRC(":dave_!d@host JOIN #test dave :Dave")
+	h.fromIRC("@msgid=i2;account=dave :dave_!d@host PRIVMSG #test :hello")
+	h.fromIRC(":dave_!d@host PART #test :bye")
+	if sent, dsent := h.irc.take(), h.discord.take(); len(sent) != 0 || len(dsent) != 0 {
+		t.Errorf("got irc messages %v and discord messages %v of an opted-out user, want none", sent, dsent)
+	}
+}
+
+func TestRelaySeveralIRCChannels(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\n")
+	h.b.mappingsUpdate(func(chs map[string]Channels) {
+		chs[testChannel] = append(Channels{chs[testChannel][0]}, &Channel{Discord: testChannel, IRC: "#other"})
+	})
+	alice := h.addMember("500", "alice", "")
+	m := h.fromDiscord(alice, "hi", nil)
+	sent := h.echo("e")
+	if len(sent) != 2 || sent[0].Params[0] != "#test" || sent[1].Params[0] != "#other" {
+		t.Fatalf("got irc messages %v, want one in #test and one in #other", sent)
+	}
+
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: m})
+	sent = h.irc.take()
+	if len(sent) != 2 || sent[0].Params[0] != "#test" || sent[0].Params[1] != "e0" || sent[1].Params[0] != "#other" || sent[1].Params[1] != "e1" {
+		t.Errorf("got %v, want REDACTs of e0 in #test and e1 in #other", sent)
+	}
+
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :question")
+	parent := h.discord.take()[0]
+	sent = h.echo("f")
+	if want := "<[#test] c\u200barol> question"; len(sent) != 1 || sent[0].Params[0] != "#other" || stripFormatting(sent[0].Params[1]) != want {
+		t.Errorf("got irc messages %v, want %q in #other", sent, want)
+	}
+	if dsent := h.discord.take(); len(dsent) != 0 {
+		t.Errorf("got discord messages %v for the echo of a mirrored message, want none", dsent)
+	}
+
+	h.fromDiscord(alice, "answer", parent)
+	sent = h.irc.take()
+	if len(sent) != 3 || string(sent[0].Tags["+draft/reply"]) != "i1" || sent[1].Tags["+draft/reply"] != "" || sent[2].Tags["+draft/reply"] != "" {
+		t.Errorf("got %v, want a reply to i1 in #test, and a quote of the message replied to in #other", sent)
+	}
+}
+
+func TestRelayAutoMod(t *testing.T) {
+	h := newHarness(t, "autoMod:\n  enabled: true\n")
+	h.b.mappingsUpdate(func(chs map[string]Channels) {
+		chs[testChannel] = append(Channels{chs[testChannel][0]},
+			&Channel{Discord: testChannel, IRC: "#other"},
+			&Channel{Discord: testChannel, IRC: "#oneway", Direction: directionIRCToDiscord})
+	})
+	h.addMember("500", "alice", "")
+	execution := func(content string) *discordgo.AutoModerationActionExecution {
+		return &discordgo.AutoModerationActionExecution{
+			GuildID:   testGuild,
+			ChannelID: testChannel,
+			UserID:    "500",
+			RuleID:    "70",
+			Content:   content,
+			Action:    discordgo.AutoModerationAction{Type: discordgo.AutoModerationRuleActionBlockMessage},
+		}
+	}
+	h.b.discordAutoMod(h.discord, execution("secret"))
+	sent := h.irc.take()
+	if len(sent) != 2 || sent[0].Params[0] != "#test" || sent[1].Params[0] != "#other" || !strings.Contains(sent[0].Params[1], "secret") {
+		t.Errorf("got irc messages %v, want notices in #test and #other only", sent)
+	}
+
+	h.b.cfg.AutoMod.Channel = "#ops"
+	h.b.discordAutoMod(h.discord, execution("other secret"))
+	if sent := h.irc.take(); len(sent) != 1 || sent[0].Params[0] != "#ops" {
+		t.Errorf("got irc messages %v, want a notice in #ops", sent)
+	}
+}
+
+func TestRelayDiscordForwardRoles(t *testing.T) {
+	h := newHarness(t, "")
+	err := h.discord.st.ChannelAdd(&discordgo.Channel{
+		ID:      "101",
+		GuildID: testGuild,
+		Name:    "other",
+		Type:    discordgo.ChannelTypeGuildText,
+	})
+	if err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	if err := h.discord.st.RoleAdd(testGuild, &discordgo.Role{ID: "30", Name: "mods", Mentionable: true}); err != nil {
+		t.Fatalf("adding role: %v", err)
+	}
+	h.b.mappingsUpdate(func(chs map[string]Channels) {
+		chs["101"] = Channels{&Channel{Discord: "101", IRC: "#test"}}
+	})
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "<@&30> @mods", nil)
+	sent := h.discord.take()
+	if len(sent) != 1 || sent[0].ChannelID != "101" {
+		t.Fatalf("got discord messages %v, want one forwarded to 101", sent)
+	}
+	if allowed := h.discord.sends[sent[0].ID].AllowedMentions; allowed == nil || len(allowed.Roles) != 0 {
+		t.Errorf("got allowed mentions %+v, want no roles", allowed)
+	}
+}
+
+func TestRelayDiscordCoalesced(t *testing.T) {
+	h := newHarness(t, "coalesce: 1h\n")
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "hello", nil)
+	m := h.fromDiscord(alice, "world", nil)
+	h.b.coalesceFlush("irc #test")
+	if sent := h.echo("e"); len(sent) != 1 {
+		t.Fatalf("got irc messages %v, want 1", sent)
+	}
+
+	h.b.discordDelete(h.discord, &discordgo.MessageDelete{Message: m})
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].Command != "REDACT" || sent[0].Params[1] != "e0" {
+		t.Errorf("got %v, want a REDACT of e0 for the second merged message", sent)
+	}
+}
+
+func TestRelayDiscordQuote(t *testing.T) {
+	h := newHarness(t, "colors:\n  disabled: true\n")
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "> quoted one\n> quoted two\nreply\non two lines", nil)
+	var got []string
+	for _, m := range h.irc.take() {
+		got = append(got, stripFormatting(m.Params[1]))
+	}
+	want := []string{
+		"<a\u200blice> > quoted one",
+		"<a\u200blice> > quoted two",
+		"<a\u200blice> reply on two lines",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got irc lines %q, want %q", got, want)
+	}
+}
+
+func TestNewConfigCopy(t *testing.T) {
+	c := &Config{
+		Server:   "irc.example.com:6697",
+		Channels: map[string]Channels{testChannel: {{IRC: "#test key"}}},
+		Colors:   ColorConfig{Extended: true, Users: map[string]string{"42": "#ff0000"}},
+	}
+	b, err := New(c)
+	if err != nil {
+		t.Fatalf("creating bridge: %v", err)
+	}
+	if err := b.AddMapping("123", &Channel{IRC: "#other"}); err != nil {
+		t.Fatalf("adding mapping: %v", err)
+	}
+	if ch := c.Channels[testChannel][0]; ch.IRC != "#test key" || ch.Key != "" {
+		t.Errorf("got caller mapping %+v, want it unchanged", ch)
+	}
+	if _, ok := c.Channels["123"]; ok {
+		t.Errorf("added a mapping to the caller channels")
+	}
+	if color := c.Colors.Users["42"]; color != "#ff0000" {
+		t.Errorf("got caller color %q, want it unchanged", color)
+	}
+	// the extended palette is not added twice
+	b, err = New(c)
+	if err != nil {
+		t.Fatalf("creating second bridge: %v", err)
+	}
+	if len(b.validColors) != 11+83 {
+		t.Errorf("got %d valid colors, want %d", len(b.validColors), 11+83)
+	}
+}
@@ -0,0 +1,257 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"gopkg.in/irc.v3"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	saslPlain       = "PLAIN"
+	saslSCRAMSHA256 = "SCRAM-SHA-256"
+)
+
+// saslChunk is the maximum length of an AUTHENTICATE payload.
+const saslChunk = 400
+
+// scramMaxIterations is the maximum SCRAM iteration count accepted from the server, so that a
+// hostile server cannot make the bridge spin deriving the key.
+const scramMaxIterations = 1 << 20
+
+// saslConn is a connection holding back the end of the capability negotiation written by the irc
+// library while authenticating, as registration would abort the authentication.
+type saslConn struct {
+	net.Conn
+
+	lock sync.Mutex
+	auth bool   // authenticating
+	held []byte // lines written while authenticating
+}
+
+func (c *saslConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.auth && bytes.HasPrefix(b, []byte("CAP END")) {
+		c.held = append(c.held, b...)
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *saslConn) authenticating() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.auth
+}
+
+// done ends the authentication, writing the lines held back.
+func (c *saslConn) done() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.auth = false
+	if len(c.held) > 0 {
+		c.Conn.Write(c.held)
+		c.held = nil
+	}
+}
+
+// saslMechanism is a client SASL mechanism.
+type saslMechanism interface {
+	// next returns the response to the challenge of the server, nil for the first message.
+	next(challenge []byte) ([]byte, error)
+}
+
+// saslHandle runs the SASL authentication, from the acknowledgement of the sasl cap to its result.
+func (b *Bridge) saslHandle(c ircConn, m *irc.Message) {
+	switch m.Command {
+	case "CAP":
+		if len(m.Params) < 3 || (m.Params[1] != "ACK" && m.Params[1] != "NAK") || b.ircSASL == nil || !b.ircSASL.authenticating() {
+			return
+		}
+		for _, name := range strings.Fields(m.Trailing()) {
+			if name != "sasl" {
+				continue
+			}
+			if m.Params[1] == "NAK" {
+				logErr.Printf("irc server does not support sasl, skipping authentication")
+				b.ircSASL.done()
+				return
+			}
+			switch b.cfg.SASL.Mechanism {
+			case saslSCRAMSHA256:
+				b.saslClient = &scramClient{username: b.cfg.SASL.Username, password: b.cfg.SASL.Password}
+			default:
+				b.saslClient = plainClient{username: b.cfg.SASL.Username, password: b.cfg.SASL.Password}
+			}
+			b.saslBuffer = nil
+			c.WriteMessage(&irc.Message{
+				Command: "AUTHENTICATE",
+				Params:  []string{b.cfg.SASL.Mechanism},
+			})
+		}
+	case "AUTHENTICATE":
+		if b.saslClient == nil || len(m.Params) < 1 {
+			return
+		}
+		if m.Params[0] != "+" {
+			b.saslBuffer = append(b.saslBuffer, m.Params[0]...)
+			if len(m.Params[0]) == saslChunk {
+				// more chunks follow
+				return
+			}
+		}
+		challenge, err := base64.StdEncoding.DecodeString(string(b.saslBuffer))
+		b.saslBuffer = nil
+		var response []byte
+		if err == nil {
+			if len(challenge) == 0 {
+				challenge = nil
+			}
+			response, err = b.saslClient.next(challenge)
+		}
+		if err != nil {
+			logErr.Printf("failed sasl authentication: %v", err)
+			c.WriteMessage(&irc.Message{
+				Command: "AUTHENTICATE",
+				Params:  []string{"*"},
+			})
+			return
+		}
+		saslWrite(c, response)
+	case "903", "904", "905", "906", "907": // success, failed, too long, aborted, already authenticated
+		if m.Command != "903" {
+			logErr.Printf("failed sasl authentication: %v", m.Trailing())
+		}
+		b.saslClient = nil
+		if b.ircSASL != nil {
+			b.ircSASL.done()
+		}
+	}
+}
+
+// saslWrite sends the AUTHENTICATE response, split into chunks.
+func saslWrite(c ircConn, response []byte) {
+	encoded := base64.StdEncoding.EncodeToString(response)
+	for {
+		chunk := encoded
+		if len(chunk) > saslChunk {
+			chunk = chunk[:saslChunk]
+		}
+		encoded = encoded[len(chunk):]
+		if chunk == "" {
+			chunk = "+"
+		}
+		c.WriteMessage(&irc.Message{
+			Command: "AUTHENTICATE",
+			Params:  []string{chunk},
+		})
+		if len(chunk) < saslChunk {
+			return
+		}
+	}
+}
+
+// plainClient is the PLAIN mechanism, RFC 4616.
+type plainClient struct {
+	username string
+	password string
+}
+
+func (c plainClient) next(challenge []byte) ([]byte, error) {
+	return []byte(c.username + "\x00" + c.username + "\x00" + c.password), nil
+}
+
+// scramClient is the SCRAM-SHA-256 mechanism, RFC 7677, without channel binding.
+type scramClient struct {
+	username string
+	password string
+
+	step        int
+	nonce       string
+	clientFirst string // client-first-message-bare
+	serverKey   []byte
+	authMessage string
+}
+
+func (c *scramClient) next(challenge []byte) ([]byte, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		if c.nonce == "" {
+			b := make([]byte, 18)
+			if _, err := rand.Read(b); err != nil {
+				return nil, err
+			}
+			c.nonce = base64.RawStdEncoding.EncodeToString(b)
+		}
+		name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.username)
+		c.clientFirst = "n=" + name + ",r=" + c.nonce
+		return []byte("n,," + c.clientFirst), nil
+	case 2:
+		attrs := scramAttributes(string(challenge))
+		nonce := attrs["r"]
+		salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scram salt: %v", err)
+		}
+		iterations, err := strconv.Atoi(attrs["i"])
+		if err != nil || iterations <= 0 {
+			return nil, fmt.Errorf("invalid scram iteration count: %q", attrs["i"])
+		}
+		if iterations > scramMaxIterations {
+			return nil, fmt.Errorf("scram iteration count %d above the maximum of %d", iterations, scramMaxIterations)
+		}
+		if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+			return nil, fmt.Errorf("invalid scram server nonce")
+		}
+		salted := pbkdf2.Key([]byte(c.password), salt, iterations, sha256.Size, sha256.New)
+		clientKey := hmacSHA256(salted, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+		final := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + nonce
+		c.authMessage = c.clientFirst + "," + string(challenge) + "," + final
+		proof := hmacSHA256(storedKey[:], c.authMessage)
+		for i := range proof {
+			proof[i] ^= clientKey[i]
+		}
+		c.serverKey = hmacSHA256(salted, "Server Key")
+		return []byte(final + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+	case 3:
+		attrs := scramAttributes(string(challenge))
+		if e, ok := attrs["e"]; ok {
+			return nil, fmt.Errorf("scram server error: %v", e)
+		}
+		signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+		if err != nil || !hmac.Equal(signature, hmacSHA256(c.serverKey, c.authMessage)) {
+			return nil, fmt.Errorf("invalid scram server signature")
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected scram challenge")
+	}
+}
+
+// scramAttributes parses the comma-separated attributes of a SCRAM message.
+func scramAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
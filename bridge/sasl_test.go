@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+)
+
+func TestSCRAM(t *testing.T) {
+	// test vector of RFC 7677
+	c := &scramClient{
+		username: "user",
+		password: "pencil",
+		nonce:    "rOprNGfwEbeRWgbNEkqO",
+	}
+	steps := []struct {
+		challenge string
+		response  string
+	}{
+		{"", "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"},
+		{"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", ""},
+	}
+	for i, step := range steps {
+		var challenge []byte
+		if step.challenge != "" {
+			challenge = []byte(step.challenge)
+		}
+		response, err := c.next(challenge)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if string(response) != step.response {
+			t.Fatalf("step %d: got %q, want %q", i, response, step.response)
+		}
+	}
+
+	c = &scramClient{username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	c.next(nil)
+	c.next([]byte(steps[1].challenge))
+	if _, err := c.next([]byte("v=AAAA")); err == nil {
+		t.Errorf("invalid server signature accepted")
+	}
+
+	c = &scramClient{username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	c.next(nil)
+	if _, err := c.next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=2000000000")); err == nil {
+		t.Errorf("excessive iteration count accepted")
+	}
+}
+
+func TestSASLPlain(t *testing.T) {
+	h := newHarness(t, "sasl:\n  username: bridge\n  password: secret\n")
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	h.b.ircSASL = &saslConn{Conn: local, auth: true}
+
+	// the end of the capability negotiation is held back while authenticating
+	if _, err := h.b.ircSASL.Write([]byte("CAP END\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	h.fromIRC(":irc.example.com CAP * ACK sasl")
+	h.fromIRC("AUTHENTICATE +")
+	sent := h.irc.take()
+	payload := base64.StdEncoding.EncodeToString([]byte("bridge\x00bridge\x00secret"))
+	if len(sent) != 2 || sent[0].String() != "AUTHENTICATE PLAIN" || sent[1].String() != "AUTHENTICATE "+payload {
+		t.Fatalf("got irc messages %v", sent)
+	}
+
+	read := make(chan string)
+	go func() {
+		b := make([]byte, 64)
+		n, _ := remote.Read(b)
+		read <- string(b[:n])
+	}()
+	h.fromIRC(":irc.example.com 903 bridge :SASL authentication successful")
+	if got := <-read; got != "CAP END\r\n" {
+		t.Errorf("got %q written after authenticating, want CAP END", got)
+	}
+}
@@ -0,0 +1,146 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type SentryConfig struct {
+	DSN         string `yaml:"dsn"` // empty to disable
+	Environment string `yaml:"environment"`
+}
+
+// sentryEvent is an event of the Sentry store API. It never contains message contents.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// sentryInit parses the Sentry DSN, of the form https://<key>@<host>[/<path>]/<project>.
+func (b *Bridge) sentryInit(dsn string) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return fmt.Errorf("missing public key")
+	}
+	i := strings.LastIndexByte(u.Path, '/')
+	if i < 0 || u.Path[i+1:] == "" {
+		return fmt.Errorf("missing project ID")
+	}
+	b.sentryEndpoint = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:])
+	b.sentryAuth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=discord-ircv3, sentry_key=%s", u.User.Username())
+	return nil
+}
+
+func (b *Bridge) sentrySend(e *sentryEvent) error {
+	if b.sentryEndpoint == "" {
+		return nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	e.EventID = hex.EncodeToString(id)
+	e.Timestamp = time.Now().UTC()
+	e.Platform = "go"
+	e.Logger = "discord-ircv3"
+	e.Environment = b.cfg.Sentry.Environment
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.sentryEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", b.sentryAuth)
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return nil
+}
+
+// sentryRecover reports a panic to Sentry, then panics again. It must be deferred.
+func (b *Bridge) sentryRecover() {
+	if b.sentryEndpoint == "" {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := make([]byte, 64*1024)
+	stack = stack[:runtime.Stack(stack, false)]
+	if err := b.sentrySend(&sentryEvent{
+		Level:   "fatal",
+		Message: fmt.Sprintf("panic: %v", r),
+		Extra: map[string]string{
+			"stack": string(stack),
+		},
+	}); err != nil {
+		logErr.Printf("reporting panic to sentry: %v", err)
+	}
+	panic(r)
+}
+
+// sentryErrorCount counts the recent failures of an operation.
+type sentryErrorCount struct {
+	since time.Time
+	count int
+}
+
+// sentryError reports an error of a relaying operation to Sentry once it failed 5 times in 10 minutes,
+// as single failures are expected. tags give the context of the error, e.g. the channel, never the message content.
+func (b *Bridge) sentryError(operation string, err error, tags map[string]string) {
+	if b.sentryEndpoint == "" {
+		return
+	}
+	b.sentryErrorsLock.Lock()
+	c := b.sentryErrors[operation]
+	if c == nil || time.Since(c.since) > 10*time.Minute {
+		c = &sentryErrorCount{since: time.Now()}
+		b.sentryErrors[operation] = c
+	}
+	c.count++
+	report := c.count == 5
+	b.sentryErrorsLock.Unlock()
+	if !report {
+		return
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags["operation"] = operation
+	go func() {
+		if err := b.sentrySend(&sentryEvent{
+			Level:   "error",
+			Message: fmt.Sprintf("%s failed repeatedly: %v", operation, err),
+			Tags:    tags,
+		}); err != nil {
+			logErr.Printf("reporting error to sentry: %v", err)
+		}
+	}()
+}
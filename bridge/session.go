@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+)
+
+// ircConn is the part of the IRC client used by the bridge, faked in tests.
+type ircConn interface {
+	CurrentNick() string
+	WriteMessage(m *irc.Message) error
+}
+
+// discordSession is the part of the Discord session used by the bridge, faked in tests.
+type discordSession interface {
+	state() *discordgo.State
+	RequestGuildMembers(guildID, query string, limit int, nonce string, presences bool) error
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessageCrosspost(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelTyping(channelID string, options ...discordgo.RequestOption) error
+	GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+	GuildMembersSearch(guildID, query string, limit int, options ...discordgo.RequestOption) ([]*discordgo.Member, error)
+	User(userID string, options ...discordgo.RequestOption) (*discordgo.User, error)
+	Invite(inviteID string, options ...discordgo.RequestOption) (*discordgo.Invite, error)
+	AutoModerationRule(guildID, ruleID string, options ...discordgo.RequestOption) (*discordgo.AutoModerationRule, error)
+	UserGuilds(limit int, beforeID, afterID string, withCounts bool, options ...discordgo.RequestOption) ([]*discordgo.UserGuild, error)
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ThreadJoin(id string, options ...discordgo.RequestOption) error
+	ApplicationEmojis(appID string, options ...discordgo.RequestOption) ([]*discordgo.Emoji, error)
+	ApplicationEmojiCreate(appID string, data *discordgo.EmojiParams, options ...discordgo.RequestOption) (*discordgo.Emoji, error)
+}
+
+// discordgoSession is a discordSession backed by a discordgo session.
+type discordgoSession struct {
+	*discordgo.Session
+}
+
+func (s discordgoSession) state() *discordgo.State {
+	return s.State
+}
+
+// discordHandler adapts a bridge event handler to a discordgo event handler.
+func discordHandler[T any](f func(s discordSession, m T)) func(s *discordgo.Session, m T) {
+	return func(s *discordgo.Session, m T) {
+		f(discordgoSession{s}, m)
+	}
+}
@@ -0,0 +1,123 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"strings"
+)
+
+// stageAnnounce announces text on the IRC channels of mapped stage channel dc.
+func (b *Bridge) stageAnnounce(dc string, text string) {
+	b.channelAnnounce(dc, fmt.Sprintf("%c%s%c", fItalics, replacerNewline.Replace(text), fReset))
+}
+
+// stageSpeakers returns the names of the speakers of stage channel dc.
+func (b *Bridge) stageSpeakers(s discordSession, guildID string, dc string) []string {
+	g, err := s.state().Guild(guildID)
+	if err != nil {
+		return nil
+	}
+	s.state().RLock()
+	var states []*discordgo.VoiceState
+	for _, v := range g.VoiceStates {
+		if v.ChannelID == dc && !v.Suppress {
+			states = append(states, v)
+		}
+	}
+	s.state().RUnlock()
+	var names []string
+	for _, v := range states {
+		names = append(names, b.stageName(s, v))
+	}
+	return names
+}
+
+// stageName returns the name on IRC of the user of voice state v.
+func (b *Bridge) stageName(s discordSession, v *discordgo.VoiceState) string {
+	member := v.Member
+	if member == nil || member.User == nil {
+		var err error
+		if member, err = s.state().Member(v.GuildID, v.UserID); err != nil || member.User == nil {
+			return b.antiPing(v.UserID)
+		}
+	}
+	return b.antiPing(b.displayName(member, member.User))
+}
+
+func (b *Bridge) discordStageStart(s discordSession, m *discordgo.StageInstanceEventCreate) {
+	if !b.cfg.Voice.Stages || len(b.mappings()[m.ChannelID]) == 0 {
+		return
+	}
+	b.stageLock.Lock()
+	b.stageTopics[m.ChannelID] = m.Topic
+	b.stageLock.Unlock()
+	if speakers := b.stageSpeakers(s, m.GuildID, m.ChannelID); len(speakers) > 0 {
+		b.stageAnnounce(m.ChannelID, b.localize("stageSpeakers", m.Topic, strings.Join(speakers, ", ")))
+	} else {
+		b.stageAnnounce(m.ChannelID, b.localize("stageStarted", m.Topic))
+	}
+}
+
+func (b *Bridge) discordStageUpdate(s discordSession, m *discordgo.StageInstanceEventUpdate) {
+	b.stageLock.Lock()
+	defer b.stageLock.Unlock()
+	if _, ok := b.stageTopics[m.ChannelID]; ok {
+		b.stageTopics[m.ChannelID] = m.Topic
+	}
+}
+
+func (b *Bridge) discordStageEnd(s discordSession, m *discordgo.StageInstanceEventDelete) {
+	b.stageLock.Lock()
+	_, live := b.stageTopics[m.ChannelID]
+	delete(b.stageTopics, m.ChannelID)
+	b.stageLock.Unlock()
+	if !live {
+		return
+	}
+	b.stageAnnounce(m.ChannelID, b.localize("stageEnded", m.Topic))
+}
+
+// stageGuild records the live stages of a guild, e.g. after a reconnection.
+func (b *Bridge) stageGuild(g *discordgo.Guild) {
+	if !b.cfg.Voice.Stages {
+		return
+	}
+	b.stageLock.Lock()
+	defer b.stageLock.Unlock()
+	for _, stage := range g.StageInstances {
+		if len(b.mappings()[stage.ChannelID]) > 0 {
+			b.stageTopics[stage.ChannelID] = stage.Topic
+		}
+	}
+}
+
+// discordStageSpeaker announces the users becoming or ceasing to be speakers of live stages.
+func (b *Bridge) discordStageSpeaker(s discordSession, m *discordgo.VoiceStateUpdate) {
+	if !b.cfg.Voice.Stages {
+		return
+	}
+	speaking := func(v *discordgo.VoiceState, dc string) bool {
+		return v != nil && v.ChannelID == dc && !v.Suppress
+	}
+	var channels []string
+	if m.ChannelID != "" {
+		channels = append(channels, m.ChannelID)
+	}
+	if m.BeforeUpdate != nil && m.BeforeUpdate.ChannelID != "" && m.BeforeUpdate.ChannelID != m.ChannelID {
+		channels = append(channels, m.BeforeUpdate.ChannelID)
+	}
+	for _, dc := range channels {
+		b.stageLock.Lock()
+		topic, live := b.stageTopics[dc]
+		b.stageLock.Unlock()
+		before, after := speaking(m.BeforeUpdate, dc), speaking(m.VoiceState, dc)
+		if !live || before == after {
+			continue
+		}
+		key := "stageSpeaker"
+		if !after {
+			key = "stageAudience"
+		}
+		b.stageAnnounce(dc, fmt.Sprintf("%s %s", b.stageName(s, m.VoiceState), b.localize(key, topic)))
+	}
+}
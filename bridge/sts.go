@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ircInsecurePrefix marks IRC servers to connect to without TLS, unless upgraded by STS
+const ircInsecurePrefix = "irc+insecure://"
+
+// stsPolicy is an IRCv3 strict transport security policy of a server.
+type stsPolicy struct {
+	Port   int       `json:"port"`
+	Expiry time.Time `json:"expiry"` // zero for upgrades not confirmed by a secure connection yet
+}
+
+// stsLoad loads the STS policies persisted at path.
+func (b *Bridge) stsLoad(path string) {
+	b.stsPath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		logErr.Printf("loading sts policies: %v", err)
+		return
+	}
+	b.stsLock.Lock()
+	defer b.stsLock.Unlock()
+	if err := json.Unmarshal(data, &b.stsPolicies); err != nil {
+		logErr.Printf("loading sts policies: %v", err)
+	}
+}
+
+// stsSave persists the STS policies confirmed by a secure connection. stsLock must be held.
+func (b *Bridge) stsSave() {
+	policies := make(map[string]stsPolicy)
+	for host, p := range b.stsPolicies {
+		if !p.Expiry.IsZero() {
+			policies[host] = p
+		}
+	}
+	data, err := json.MarshalIndent(policies, "", "\t")
+	if err == nil {
+		err = os.WriteFile(b.stsPath, data, 0600)
+	}
+	if err != nil {
+		logErr.Printf("saving sts policies: %v", err)
+	}
+}
+
+// stsTarget returns the address to connect to for an IRC server, and whether to use TLS.
+// Insecure servers with a valid STS policy are upgraded, refusing any downgrade.
+func (b *Bridge) stsTarget(server string) (string, bool) {
+	if isWebSocket(server) {
+		return server, strings.HasPrefix(server, "wss://")
+	}
+	addr := strings.TrimPrefix(server, ircInsecurePrefix)
+	if addr == server {
+		return addr, true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, false
+	}
+	b.stsLock.Lock()
+	defer b.stsLock.Unlock()
+	p, ok := b.stsPolicies[host]
+	if !ok {
+		return addr, false
+	}
+	if !p.Expiry.IsZero() && p.Expiry.Before(time.Now()) {
+		delete(b.stsPolicies, host)
+		b.stsSave()
+		return addr, false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p.Port)), true
+}
+
+// ircSTS handles the value of the sts capability advertised by the current IRC server.
+// It returns whether the connection must be upgraded to TLS.
+func (b *Bridge) ircSTS(value string) bool {
+	host, port, err := net.SplitHostPort(b.ircAddr)
+	if err != nil {
+		return false
+	}
+	params := make(map[string]string)
+	for _, kv := range strings.Split(value, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		params[k] = v
+	}
+	b.stsLock.Lock()
+	defer b.stsLock.Unlock()
+	if !b.ircSecure {
+		p, err := strconv.Atoi(params["port"])
+		if err != nil || p <= 0 || p > 65535 {
+			return false
+		}
+		b.stsPolicies[host] = stsPolicy{Port: p}
+		return true
+	}
+	// the policy is only valid when received over a secure connection
+	d, err := strconv.Atoi(params["duration"])
+	if err != nil || d < 0 {
+		return false
+	}
+	if d == 0 {
+		delete(b.stsPolicies, host)
+	} else {
+		p, _ := strconv.Atoi(port)
+		b.stsPolicies[host] = stsPolicy{
+			Port:   p,
+			Expiry: time.Now().Add(time.Duration(d) * time.Second),
+		}
+	}
+	b.stsSave()
+	return false
+}
@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+)
+
+// threadRelayed returns whether c is a public thread of a channel relaying its threads to IRC.
+func (b *Bridge) threadRelayed(c *discordgo.Channel) bool {
+	if c.Type != discordgo.ChannelTypeGuildPublicThread {
+		return false
+	}
+	for _, ch := range b.mappings()[c.ParentID] {
+		if ch.Threads {
+			return true
+		}
+	}
+	return false
+}
+
+// discordMappings returns the mappings of Discord channel dc: its own mappings or, for public threads
+// of a channel relaying its threads, the mappings of the parent channel, labeled with the thread name.
+func (b *Bridge) discordMappings(s discordSession, dc string) Channels {
+	if chs, ok := b.mappings()[dc]; ok {
+		return chs
+	}
+	c, err := s.state().Channel(dc)
+	if err != nil || !b.threadRelayed(c) {
+		return nil
+	}
+	var chs Channels
+	for _, ch := range b.mappings()[c.ParentID] {
+		if !ch.Threads {
+			continue
+		}
+		t := *ch
+		t.thread = c.Name
+		chs = append(chs, &t)
+	}
+	return chs
+}
+
+// threadJoin joins thread c if its messages are relayed, as Discord only sends the messages
+// of some threads to their members.
+func (b *Bridge) threadJoin(s discordSession, c *discordgo.Channel) {
+	if !b.threadRelayed(c) || c.Member != nil {
+		return
+	}
+	if err := s.ThreadJoin(c.ID); err != nil {
+		logErr.Printf("joining discord thread %v: %v", c.ID, err)
+	}
+}
+
+// threadPublic returns whether c is a thread visible to all the members of its parent channel.
+func threadPublic(c *discordgo.Channel) bool {
+	return c.Type == discordgo.ChannelTypeGuildPublicThread || c.Type == discordgo.ChannelTypeGuildNewsThread
+}
+
+// threadAnnounce notifies the IRC channels of the parent of thread c of its creation or archival,
+// whether its messages are relayed or not, so that IRC users know about the conversation.
+func (b *Bridge) threadAnnounce(c *discordgo.Channel, text string) {
+	if !threadPublic(c) {
+		return
+	}
+	for _, ch := range b.mappings()[c.ParentID] {
+		if !ch.relayToIRC() {
+			continue
+		}
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ch.IRC, replacerNewline.Replace(text)},
+		})
+	}
+}
+
+func (b *Bridge) discordThreadCreate(s discordSession, m *discordgo.ThreadCreate) {
+	if !m.NewlyCreated {
+		return
+	}
+	b.threadJoin(s, m.Channel)
+	var member *discordgo.Member
+	if mb, err := s.state().Member(m.GuildID, m.OwnerID); err == nil {
+		member = mb
+	} else if mb, err := s.GuildMember(m.GuildID, m.OwnerID); err == nil {
+		member = mb
+	}
+	if member == nil || member.User == nil {
+		b.threadAnnounce(m.Channel, fmt.Sprintf("%c%s%c", fItalics, b.localize("threadCreated", m.Name), fReset))
+		return
+	}
+	nick := b.antiPing(b.displayName(member, member.User))
+	b.threadAnnounce(m.Channel, fmt.Sprintf("%c%s%c %s", fItalics, nick, fReset, b.localize("threadStarted", m.Name)))
+}
+
+func (b *Bridge) discordThreadUpdate(s discordSession, m *discordgo.ThreadUpdate) {
+	if m.ThreadMetadata == nil || !m.ThreadMetadata.Archived {
+		return
+	}
+	if m.BeforeUpdate != nil && m.BeforeUpdate.ThreadMetadata != nil && m.BeforeUpdate.ThreadMetadata.Archived {
+		return
+	}
+	b.threadAnnounce(m.Channel, fmt.Sprintf("%c%s%c", fItalics, b.localize("threadArchived", m.Name), fReset))
+}
+
+// discordGuildCreate joins the active threads of a guild, which may have been created while the bridge was stopped.
+func (b *Bridge) discordGuildCreate(s discordSession, m *discordgo.GuildCreate) {
+	b.stageGuild(m.Guild)
+	for _, c := range m.Threads {
+		b.threadJoin(s, c)
+	}
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+func TestThreads(t *testing.T) {
+	h := newHarness(t, "")
+	h.b.cfg.Channels[testChannel][0].Threads = true
+	thread := &discordgo.Channel{
+		ID:       "200",
+		GuildID:  testGuild,
+		ParentID: testChannel,
+		Name:     "ideas",
+		Type:     discordgo.ChannelTypeGuildPublicThread,
+	}
+	if err := h.discord.st.ChannelAdd(thread); err != nil {
+		t.Fatalf("adding thread: %v", err)
+	}
+	h.b.discordThreadCreate(h.discord, &discordgo.ThreadCreate{Channel: thread, NewlyCreated: true})
+	if len(h.discord.threads) != 1 || h.discord.threads[0] != thread.ID {
+		t.Errorf("got joined threads %v, want [%v]", h.discord.threads, thread.ID)
+	}
+	h.irc.take()
+
+	alice := h.addMember("500", "alice", "")
+	h.b.discordMessage(h.discord, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        h.discord.nextID(),
+		ChannelID: thread.ID,
+		GuildID:   testGuild,
+		Content:   "what about this",
+		Author:    alice.User,
+		Member:    alice,
+		Timestamp: time.Now(),
+	}})
+	sent := h.irc.take()
+	if len(sent) != 1 || sent[0].Params[0] != "#test" || stripFormatting(sent[0].Params[1]) != "[ideas] <a\u200blice> what about this" {
+		t.Errorf("got irc messages %v, want the thread message relayed to #test", sent)
+	}
+	if sent := h.discord.take(); len(sent) != 0 {
+		t.Errorf("got discord messages %v, want none forwarded to the parent channel", sent)
+	}
+}
+
+func TestThreadNotices(t *testing.T) {
+	h := newHarness(t, "")
+	alice := h.addMember("500", "alice", "")
+	thread := &discordgo.Channel{
+		ID:             "200",
+		GuildID:        testGuild,
+		ParentID:       testChannel,
+		OwnerID:        alice.User.ID,
+		Name:           "ideas",
+		Type:           discordgo.ChannelTypeGuildPublicThread,
+		ThreadMetadata: &discordgo.ThreadMetadata{},
+	}
+	h.b.discordThreadCreate(h.discord, &discordgo.ThreadCreate{Channel: thread, NewlyCreated: true})
+	if len(h.discord.threads) != 0 {
+		t.Errorf("got joined threads %v, want none without thread relaying", h.discord.threads)
+	}
+	archived := *thread
+	archived.ThreadMetadata = &discordgo.ThreadMetadata{Archived: true}
+	h.b.discordThreadUpdate(h.discord, &discordgo.ThreadUpdate{Channel: &archived, BeforeUpdate: thread})
+	h.b.discordThreadUpdate(h.discord, &discordgo.ThreadUpdate{Channel: &archived, BeforeUpdate: &archived})
+
+	sent := h.irc.take()
+	want := []string{
+		"a\u200blice started thread: ideas",
+		"Thread archived: ideas",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if m.Params[0] != "#test" || stripFormatting(m.Params[1]) != want[i] {
+			t.Errorf("message %d: got %v, want %q", i, m, want[i])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tokenPollDelay is the delay between reads of the token source after the token was rejected.
+var tokenPollDelay = time.Minute
+
+// gatewayAuthenticationFailed is the gateway close code of sessions identified with an invalid token.
+const gatewayAuthenticationFailed = 4004
+
+var errTokenRejected = errors.New("discord rejected the bot token")
+
+// tokenRead returns the Discord bot token, read from cfg.TokenFile or from the output of
+// cfg.TokenCommand if set.
+func (b *Bridge) tokenRead() (string, error) {
+	var token string
+	switch {
+	case b.cfg.TokenFile != "":
+		data, err := os.ReadFile(b.cfg.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading discord token file: %v", err)
+		}
+		token = string(data)
+	case b.cfg.TokenCommand != "":
+		data, err := exec.Command("sh", "-c", b.cfg.TokenCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("running discord token command: %v", err)
+		}
+		token = string(data)
+	default:
+		token = b.cfg.DiscordToken
+	}
+	token = strings.TrimPrefix(strings.TrimSpace(token), "Bot ")
+	if token == "" {
+		return "", fmt.Errorf("empty discord token")
+	}
+	return token, nil
+}
+
+// tokenTransport authenticates the Discord REST requests with the current token, as discordgo reads
+// its token without locking.
+type tokenTransport struct {
+	b    *Bridge
+	base http.RoundTripper
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.b.tokenLock.Lock()
+	token := t.b.tokenCurrent
+	t.b.tokenLock.Unlock()
+	req = req.Clone(req.Context())
+	req.Header.Set("authorization", "Bot "+token)
+	return t.base.RoundTrip(req)
+}
+
+// tokenUse sets up session to authenticate with token, and with the tokens swapped in later.
+func (b *Bridge) tokenUse(s *discordgo.Session, token string) {
+	b.tokenLock.Lock()
+	b.tokenCurrent = token
+	b.tokenLock.Unlock()
+	client := *s.Client
+	if client.Transport == nil {
+		client.Transport = http.DefaultTransport
+	}
+	client.Transport = tokenTransport{b: b, base: client.Transport}
+	s.Client = &client
+}
+
+// tokenUnauthorized returns whether err is Discord rejecting the token, from the REST API or the gateway.
+func tokenUnauthorized(err error) bool {
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr) && closeErr.Code == gatewayAuthenticationFailed
+}
+
+// tokenCheck checks the token after a disconnection from the gateway: a session closed for an invalid
+// token is otherwise reopened by discordgo in a loop, as opening it only fails after identifying.
+func (b *Bridge) tokenCheck(s discordSession) {
+	b.tokenLock.Lock()
+	invalid := b.tokenInvalid
+	token := b.tokenCurrent
+	b.tokenLock.Unlock()
+	if invalid {
+		return
+	}
+	if _, err := s.User("@me"); !tokenUnauthorized(err) {
+		return
+	}
+	b.tokenLock.Lock()
+	defer b.tokenLock.Unlock()
+	if b.tokenInvalid || b.tokenCurrent != token {
+		// already rejected, or swapped meanwhile
+		return
+	}
+	select {
+	case b.tokenRejected <- struct{}{}:
+	default:
+	}
+}
+
+// tokenInvalidate marks the current token as rejected, so that it is not checked again.
+func (b *Bridge) tokenInvalidate() {
+	b.tokenLock.Lock()
+	b.tokenInvalid = true
+	b.tokenLock.Unlock()
+}
+
+// tokenFailed reports the rejection of the token and stops the gateway reconnections.
+func (b *Bridge) tokenFailed(s *discordgo.Session, err error) {
+	b.tokenInvalidate()
+	s.Lock()
+	s.ShouldReconnectOnError = false
+	s.Unlock()
+
+	text := "the discord bot token was rejected, e.g. because it was reset: "
+	if b.cfg.TokenFile != "" || b.cfg.TokenCommand != "" {
+		text += "update it and the bridge will reconnect with it"
+	} else {
+		text += "update discordToken and restart the bridge"
+	}
+	logErr.Printf("discord fatal error: %v: %s", err, text)
+	b.sentryError("discord token", err, nil)
+	b.discordHealth.notify(text)
+}
+
+// tokenSwap waits for the token source to provide a different token, and sets it on the session.
+// It returns false if ctx is done first.
+func (b *Bridge) tokenSwap(ctx context.Context, s *discordgo.Session) bool {
+	if b.cfg.TokenFile == "" && b.cfg.TokenCommand == "" {
+		<-ctx.Done()
+		return false
+	}
+	b.tokenLock.Lock()
+	rejected := b.tokenCurrent
+	b.tokenLock.Unlock()
+	for {
+		if !sleepContext(ctx, tokenPollDelay) {
+			return false
+		}
+		token, err := b.tokenRead()
+		if err != nil {
+			logErr.Printf("failed reading the discord token: %v", err)
+			continue
+		}
+		if token == rejected {
+			continue
+		}
+		logErr.Printf("read a new discord token, reconnecting")
+		b.tokenLock.Lock()
+		b.tokenCurrent = token
+		b.tokenInvalid = false
+		// drop the rejections of the previous token, which would close the new session
+		select {
+		case <-b.tokenRejected:
+		default:
+		}
+		b.tokenLock.Unlock()
+		// the session is closed: only Open, which locks it, reads the identify token
+		s.Lock()
+		s.Identify.Token = "Bot " + token
+		s.ShouldReconnectOnError = b.cfg.Gateway.Reconnect == nil || *b.cfg.Gateway.Reconnect
+		s.Unlock()
+		return true
+	}
+}
+
+// discordOpen opens the Discord session, retrying on errors, until ctx is done. When Discord rejects
+// the token, e.g. after it was reset, the gateway reconnections are stopped and the session is reopened
+// once a new token is read from cfg.TokenFile or cfg.TokenCommand.
+func (b *Bridge) discordOpen(ctx context.Context, s *discordgo.Session) {
+	for {
+		err := s.Open()
+		if err == nil {
+			select {
+			case <-b.tokenRejected:
+			case <-ctx.Done():
+				return
+			}
+			err = errTokenRejected
+			// before closing, so that the disconnection does not check the token again
+			b.tokenInvalidate()
+			s.Lock()
+			s.ShouldReconnectOnError = false
+			s.Unlock()
+			s.Close()
+		} else if !tokenUnauthorized(err) {
+			logErr.Printf("failed opening discord: %v", err)
+			if !sleepContext(ctx, 15*time.Second) {
+				return
+			}
+			continue
+		}
+		b.tokenFailed(s, err)
+		if !b.tokenSwap(ctx, s) {
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenRead(t *testing.T) {
+	h := newHarness(t, "")
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("Bot secret\n"), 0600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	for _, tc := range []struct {
+		file    string
+		command string
+		want    string
+	}{
+		{"", "", "token"},
+		{path, "", "secret"},
+		{"", "echo ' other '", "other"},
+	} {
+		h.b.cfg.TokenFile, h.b.cfg.TokenCommand = tc.file, tc.command
+		if token, err := h.b.tokenRead(); err != nil || token != tc.want {
+			t.Errorf("file %q, command %q: got token %q (%v), want %q", tc.file, tc.command, token, err, tc.want)
+		}
+	}
+	h.b.cfg.TokenFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := h.b.tokenRead(); err == nil {
+		t.Errorf("got no error reading a missing token file")
+	}
+}
+
+func TestTokenUnauthorized(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, true},
+		{&discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusBadGateway}}, false},
+		{&websocket.CloseError{Code: gatewayAuthenticationFailed, Text: "Authentication failed."}, true},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway}, false},
+		{fmt.Errorf("dial tcp: connection refused"), false},
+	} {
+		if got := tokenUnauthorized(tc.err); got != tc.want {
+			t.Errorf("%v: got unauthorized %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestTokenTransport(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("authorization"))
+	}))
+	defer server.Close()
+	s, err := discordgo.New("Bot first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bridge{}
+	b.tokenUse(s, "first")
+	s.Request("GET", server.URL, nil)
+	b.tokenLock.Lock()
+	b.tokenCurrent = "second"
+	b.tokenLock.Unlock()
+	s.Request("GET", server.URL, nil)
+	if want := []string{"Bot first", "Bot second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got authorizations %q, want %q", got, want)
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	h := newHarness(t, "")
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	h.b.cfg.TokenFile = path
+	tokenPollDelay = 10 * time.Millisecond
+	defer func() {
+		tokenPollDelay = time.Minute
+	}()
+	s, err := discordgo.New("Bot old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.b.tokenUse(s, "old")
+	rejected := func() bool {
+		select {
+		case <-h.b.tokenRejected:
+			return true
+		default:
+			return false
+		}
+	}
+
+	h.discord.revoked = true
+	h.b.tokenCheck(h.discord)
+	if !rejected() {
+		t.Fatalf("got no rejection of a revoked token")
+	}
+	h.b.tokenInvalidate()
+	// the disconnection of the closed session
+	h.b.tokenCheck(h.discord)
+	if rejected() {
+		t.Fatalf("got a rejection of a token already rejected")
+	}
+
+	// a check of the old token racing with the swap
+	h.b.tokenLock.Lock()
+	h.b.tokenRejected <- struct{}{}
+	h.b.tokenLock.Unlock()
+	if err := os.WriteFile(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !h.b.tokenSwap(ctx, s) {
+		t.Fatalf("got no new token")
+	}
+	if s.Identify.Token != "Bot new" {
+		t.Errorf("got identify token %q, want Bot new", s.Identify.Token)
+	}
+	if rejected() {
+		t.Errorf("got a stale rejection after the swap")
+	}
+	h.discord.revoked = false
+	h.b.tokenCheck(h.discord)
+	if rejected() {
+		t.Errorf("got a rejection of the new token")
+	}
+}
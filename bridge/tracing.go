@@ -0,0 +1,272 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TracingConfig struct {
+	Endpoint string            `yaml:"endpoint"` // OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces, empty to disable
+	Service  string            `yaml:"service"`  // service name of the spans, defaults to discord-ircv3
+	Headers  map[string]string `yaml:"headers"`  // HTTP headers of export requests, e.g. for authentication
+}
+
+// traceExportInterval is how often finished spans are exported.
+const traceExportInterval = 5 * time.Second
+
+// traceMaxBuffered is the count of finished spans exported at once, above which spans are dropped.
+const traceMaxBuffered = 2048
+
+// span is a timed operation of the relay pipeline.
+type span struct {
+	b          *Bridge
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+}
+
+// traceStart starts a span, in a new trace if parent is nil.
+func (b *Bridge) traceStart(name string, parent *span) *span {
+	s := &span{
+		b:     b,
+		name:  name,
+		start: time.Now(),
+	}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// child starts a span as a child of s, or returns nil if s is nil.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	return s.b.traceStart(name, s)
+}
+
+// set sets an attribute of s.
+func (s *span) set(key string, value string) {
+	if s == nil {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// finish ends s, records its latency, and queues it for export if configured.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.b.latencyObserve(s.name, s.end.Sub(s.start))
+	if s.b.cfg.Tracing.Endpoint == "" {
+		return
+	}
+	s.b.traceLock.Lock()
+	if len(s.b.traceBuffer) < traceMaxBuffered {
+		s.b.traceBuffer = append(s.b.traceBuffer, s)
+	}
+	s.b.traceLock.Unlock()
+}
+
+// traceRelayStart starts the root span of the relay of the message id, found from id along the pipeline.
+func (b *Bridge) traceRelayStart(id string, name string) *span {
+	s := b.traceStart(name, nil)
+	if id == "" {
+		return s
+	}
+	s.set("message.id", id)
+	b.traceLock.Lock()
+	b.traceRelays[id] = s
+	b.traceLock.Unlock()
+	return s
+}
+
+// traceRelay returns the root span of the relay of the message id, or nil.
+func (b *Bridge) traceRelay(id string) *span {
+	if id == "" {
+		return nil
+	}
+	b.traceLock.Lock()
+	defer b.traceLock.Unlock()
+	return b.traceRelays[id]
+}
+
+// traceRelayFinish ends the root span of the relay of the message id.
+func (b *Bridge) traceRelayFinish(id string) {
+	if id == "" {
+		return
+	}
+	b.traceLock.Lock()
+	s := b.traceRelays[id]
+	delete(b.traceRelays, id)
+	b.traceLock.Unlock()
+	s.finish()
+}
+
+// traceEchoStart starts waiting for the echo of a message relayed from the Discord message id.
+func (b *Bridge) traceEchoStart(id string) {
+	s := b.traceRelay(id).child("irc.echo")
+	if s == nil {
+		return
+	}
+	b.traceLock.Lock()
+	defer b.traceLock.Unlock()
+	if len(b.traceEchoes) >= traceMaxBuffered {
+		// echoes never received, e.g. without echo-message
+		b.traceEchoes = make(map[string]*span)
+	}
+	if _, ok := b.traceEchoes[id]; !ok {
+		b.traceEchoes[id] = s
+	}
+}
+
+// traceEchoFinish ends waiting for the echo of a message relayed from the Discord message id.
+func (b *Bridge) traceEchoFinish(id string) {
+	if id == "" {
+		return
+	}
+	b.traceLock.Lock()
+	s := b.traceEchoes[id]
+	delete(b.traceEchoes, id)
+	b.traceLock.Unlock()
+	s.finish()
+}
+
+// traceExport exports the finished spans periodically, until ctx is done.
+func (b *Bridge) traceExport(ctx context.Context) {
+	t := time.NewTicker(traceExportInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			b.traceFlush()
+			return
+		}
+		b.traceFlush()
+	}
+}
+
+// traceFlush exports the finished spans.
+func (b *Bridge) traceFlush() {
+	b.traceLock.Lock()
+	spans := b.traceBuffer
+	b.traceBuffer = nil
+	b.traceLock.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := b.traceSend(spans); err != nil {
+		logErr.Printf("exporting %d traces: %v", len(spans), err)
+	}
+}
+
+// otlpValue and the following types are the parts of the OTLP/JSON encoding of spans used by the bridge.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func (b *Bridge) traceSend(spans []*span) error {
+	service := b.cfg.Tracing.Service
+	if service == "" {
+		service = "discord-ircv3"
+	}
+	var scope otlpScopeSpans
+	scope.Scope.Name = "github.com/delthas/discord-ircv3/bridge"
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for k, v := range s.attributes {
+			o.Attributes = append(o.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		scope.Spans = append(scope.Spans, o)
+	}
+	var resource otlpResourceSpans
+	resource.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: service}}}
+	resource.ScopeSpans = []otlpScopeSpans{scope}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{resource}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.cfg.Tracing.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range b.cfg.Tracing.Headers {
+		req.Header.Set(k, v)
+	}
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	exported := make(chan otlpTraces, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var traces otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			t.Errorf("decoding traces: %v", err)
+		}
+		exported <- traces
+	}))
+	defer srv.Close()
+
+	h := newHarness(t, "tracing:\n  endpoint: "+srv.URL+"\n")
+	alice := h.addMember("500", "alice", "")
+	h.fromDiscord(alice, "hello", nil)
+	h.echo("e")
+	h.fromIRC("@msgid=i1 :carol!c@host PRIVMSG #test :hi")
+	h.b.traceFlush()
+
+	traces := <-exported
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got traces %+v", traces)
+	}
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	ids := make(map[string]string) // span ID to trace ID
+	roots := make(map[string]string)
+	var names []string
+	for _, s := range spans {
+		ids[s.SpanID] = s.TraceID
+		if s.ParentSpanID == "" {
+			roots[s.TraceID] = s.Name
+		}
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	want := "discord.journal discord.receive discord.send discord.transform irc.echo irc.journal irc.receive irc.transform irc.write"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got spans %v, want %v", got, want)
+	}
+	if len(roots) != 2 {
+		t.Errorf("got %d traces, want 2", len(roots))
+	}
+	for _, s := range spans {
+		if s.ParentSpanID != "" && ids[s.ParentSpanID] != s.TraceID {
+			t.Errorf("span %v: parent not in the same trace", s.Name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"gopkg.in/irc.v3"
+	"time"
+)
+
+type VoiceConfig struct {
+	Channels map[string][]string `yaml:"channels"` // Discord guild ID to IRC channels announcing the occupancy of its voice channels
+	Delay    time.Duration       `yaml:"delay"`    // how long an occupancy must last to be announced, defaults to 1m
+	Stages   bool                `yaml:"stages"`   // announce the stages of mapped stage channels and their speakers
+}
+
+func (b *Bridge) discordVoiceStateUpdate(s discordSession, m *discordgo.VoiceStateUpdate) {
+	if len(b.cfg.Voice.Channels[m.GuildID]) == 0 {
+		return
+	}
+	if m.ChannelID != "" {
+		b.voiceSchedule(s, m.GuildID, m.ChannelID)
+	}
+	if m.BeforeUpdate != nil && m.BeforeUpdate.ChannelID != "" && m.BeforeUpdate.ChannelID != m.ChannelID {
+		b.voiceSchedule(s, m.GuildID, m.BeforeUpdate.ChannelID)
+	}
+}
+
+// voiceSchedule announces the occupancy of voice channel vc once it has not changed for the configured delay,
+// so that users joining and leaving quickly are not announced.
+func (b *Bridge) voiceSchedule(s discordSession, guildID string, vc string) {
+	delay := b.cfg.Voice.Delay
+	if delay <= 0 {
+		delay = time.Minute
+	}
+	b.voiceLock.Lock()
+	defer b.voiceLock.Unlock()
+	if t, ok := b.voiceTimers[vc]; ok {
+		t.Stop()
+	}
+	b.voiceTimers[vc] = time.AfterFunc(delay, func() {
+		b.voiceAnnounce(s, guildID, vc)
+	})
+}
+
+// voiceAnnounce announces the occupancy of voice channel vc if it changed since it was last announced.
+func (b *Bridge) voiceAnnounce(s discordSession, guildID string, vc string) {
+	c, err := s.state().Channel(vc)
+	if err != nil {
+		return
+	}
+	g, err := s.state().Guild(guildID)
+	if err != nil {
+		return
+	}
+	s.state().RLock()
+	n := 0
+	for _, v := range g.VoiceStates {
+		if v.ChannelID == vc {
+			n++
+		}
+	}
+	s.state().RUnlock()
+
+	b.voiceLock.Lock()
+	delete(b.voiceTimers, vc)
+	last := b.voiceAnnounced[vc]
+	b.voiceAnnounced[vc] = n
+	b.voiceLock.Unlock()
+	if n == last {
+		return
+	}
+	text := replacerNewline.Replace(fmt.Sprintf("🔊 %s", b.localize("voiceOccupancy", c.Name, n)))
+	for _, ic := range b.cfg.Voice.Channels[guildID] {
+		b.ircWrite(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{ic, text},
+		})
+	}
+}
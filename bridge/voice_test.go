@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"testing"
+	"time"
+)
+
+func TestVoiceOccupancy(t *testing.T) {
+	h := newHarness(t, "voice:\n  channels:\n    \"10\": [\"#test\"]\n  delay: 20ms\n")
+	vc := &discordgo.Channel{ID: "300", GuildID: testGuild, Name: "General", Type: discordgo.ChannelTypeGuildVoice}
+	if err := h.discord.st.ChannelAdd(vc); err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	g, _ := h.discord.st.Guild(testGuild)
+	update := func(user string, channel string) {
+		var before *discordgo.VoiceState
+		v := &discordgo.VoiceState{GuildID: testGuild, UserID: user, ChannelID: channel}
+		h.discord.st.Lock()
+		for i, o := range g.VoiceStates {
+			if o.UserID == user {
+				before = o
+				g.VoiceStates = append(g.VoiceStates[:i], g.VoiceStates[i+1:]...)
+				break
+			}
+		}
+		if channel != "" {
+			g.VoiceStates = append(g.VoiceStates, v)
+		}
+		h.discord.st.Unlock()
+		h.b.discordVoiceStateUpdate(h.discord, &discordgo.VoiceStateUpdate{VoiceState: v, BeforeUpdate: before})
+	}
+
+	update("500", vc.ID)
+	update("501", vc.ID)
+	time.Sleep(100 * time.Millisecond)
+	// joining and leaving within the delay is not announced
+	update("502", vc.ID)
+	update("502", "")
+	time.Sleep(100 * time.Millisecond)
+	update("501", "")
+	time.Sleep(100 * time.Millisecond)
+
+	sent := h.irc.take()
+	want := []string{"🔊 General: 2 connected", "🔊 General: 1 connected"}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if m.Params[0] != "#test" || m.Params[1] != want[i] {
+			t.Errorf("message %d: got %v, want %q", i, m, want[i])
+		}
+	}
+}
+
+func TestStages(t *testing.T) {
+	h := newHarness(t, "voice:\n  stages: true\n")
+	stage := &discordgo.Channel{ID: "310", GuildID: testGuild, Name: "Stage", Type: discordgo.ChannelTypeGuildStageVoice}
+	if err := h.discord.st.ChannelAdd(stage); err != nil {
+		t.Fatalf("adding channel: %v", err)
+	}
+	ch := &Channel{IRC: "#stage"}
+	if err := ch.validate(stage.ID); err != nil {
+		t.Fatalf("validating channel: %v", err)
+	}
+	h.b.cfg.Channels[stage.ID] = Channels{ch}
+	h.addMember("500", "alice", "")
+	h.addMember("501", "bob", "")
+	g, _ := h.discord.st.Guild(testGuild)
+	h.discord.st.Lock()
+	g.VoiceStates = append(g.VoiceStates, &discordgo.VoiceState{GuildID: testGuild, UserID: "500", ChannelID: stage.ID})
+	h.discord.st.Unlock()
+
+	instance := &discordgo.StageInstance{GuildID: testGuild, ChannelID: stage.ID, Topic: "AMA"}
+	h.b.discordStageStart(h.discord, &discordgo.StageInstanceEventCreate{StageInstance: instance})
+	audience := &discordgo.VoiceState{GuildID: testGuild, UserID: "501", ChannelID: stage.ID, Suppress: true}
+	h.b.discordStageSpeaker(h.discord, &discordgo.VoiceStateUpdate{VoiceState: audience})
+	speaker := &discordgo.VoiceState{GuildID: testGuild, UserID: "501", ChannelID: stage.ID}
+	h.b.discordStageSpeaker(h.discord, &discordgo.VoiceStateUpdate{VoiceState: speaker, BeforeUpdate: audience})
+	h.b.discordStageSpeaker(h.discord, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{GuildID: testGuild, UserID: "501"}, BeforeUpdate: speaker})
+	h.b.discordStageEnd(h.discord, &discordgo.StageInstanceEventDelete{StageInstance: instance})
+	// not live anymore
+	h.b.discordStageSpeaker(h.discord, &discordgo.VoiceStateUpdate{VoiceState: speaker})
+
+	sent := h.irc.take()
+	want := []string{
+		"Stage 'AMA' started, speakers: a\u200blice",
+		"b\u200bob is now a speaker of stage 'AMA'",
+		"b\u200bob is no longer a speaker of stage 'AMA'",
+		"Stage 'AMA' ended",
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("got irc messages %v, want %d", sent, len(want))
+	}
+	for i, m := range sent {
+		if m.Params[0] != "#stage" || stripFormatting(m.Params[1]) != want[i] {
+			t.Errorf("message %d: got %v, want %q", i, m, want[i])
+		}
+	}
+}
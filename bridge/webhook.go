@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Event is a bridge event, posted to the configured webhooks and passed to the hooks registered with OnEvent.
+type Event struct {
+	Event          string    `json:"event"` // message, delete, connect, disconnect
+	Time           time.Time `json:"time"`
+	Source         string    `json:"source"` // irc or discord
+	DiscordChannel string    `json:"discordChannel,omitempty"`
+	IRCChannel     string    `json:"ircChannel,omitempty"`
+	Author         string    `json:"author,omitempty"`
+	Account        string    `json:"account,omitempty"` // IRC services account of the author
+	Content        string    `json:"content,omitempty"`
+	DiscordID      string    `json:"discordID,omitempty"`
+	IRCID          string    `json:"ircID,omitempty"`
+}
+
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func (b *Bridge) webhookPost(e *Event) {
+	e.Time = time.Now().UTC()
+	for _, f := range b.eventHooks {
+		f(e)
+	}
+	if len(b.cfg.Webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		logErr.Printf("encoding webhook event: %v", err)
+		return
+	}
+	for _, url := range b.cfg.Webhooks {
+		go func(url string) {
+			if err := webhookSend(url, body); err != nil {
+				logErr.Printf("posting webhook event to %q: %v", url, err)
+				b.sentryError("webhook post", err, nil)
+			}
+		}(url)
+	}
+}
+
+func webhookSend(url string, body []byte) error {
+	res, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isWebSocket returns whether an IRC server address is a WebSocket URL.
+func isWebSocket(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// wsConn is an IRC connection over WebSocket, per the IRCv3 WebSocket binding:
+// each WebSocket message is a single IRC line without its trailing CRLF.
+type wsConn struct {
+	*websocket.Conn
+	messageType int
+	r           []byte // unread part of the current line
+
+	lock sync.Mutex
+	w    []byte // unsent partial line, protected by lock
+}
+
+// wsDial opens a WebSocket connection to an IRC server, with netDial opening the underlying TCP connections.
+func (b *Bridge) wsDial(url string, netDial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	dialer := websocket.Dialer{
+		NetDial:          netDial,
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: b.cfg.DialTimeout,
+		Subprotocols:     []string{"binary.ircv3.net", "text.ircv3.net"},
+	}
+	c, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	messageType := websocket.TextMessage
+	if c.Subprotocol() == "binary.ircv3.net" {
+		messageType = websocket.BinaryMessage
+	}
+	return &wsConn{
+		Conn:        c,
+		messageType: messageType,
+	}, nil
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for len(c.r) == 0 {
+		_, line, err := c.Conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.r = append(line, '\r', '\n')
+	}
+	n := copy(b, c.r)
+	c.r = c.r[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.w = append(c.w, b...)
+	for {
+		i := bytes.IndexByte(c.w, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(c.w[:i], []byte("\r"))
+		if err := c.Conn.WriteMessage(c.messageType, line); err != nil {
+			return 0, err
+		}
+		c.w = c.w[i+1:]
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
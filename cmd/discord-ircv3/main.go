@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/delthas/discord-ircv3/bridge"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	debug := flag.Bool("debug", false, "enable debug logging")
+	configPath := flag.String("config", "config.yaml", "config path")
+	flag.Parse()
+
+	cfg, err := bridge.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Debug = cfg.Debug || *debug
+	b, err := bridge.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := b.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
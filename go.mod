@@ -3,14 +3,12 @@ module github.com/delthas/discord-ircv3
 go 1.18
 
 require (
-	github.com/bwmarrin/discordgo v0.27.1
+	github.com/bwmarrin/discordgo v0.29.0
 	github.com/delthas/discord-formatting v0.0.0-20220730152124-232054d9d66b
+	github.com/gorilla/websocket v1.4.2
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	gopkg.in/irc.v3 v3.1.4
 	gopkg.in/yaml.v2 v2.2.8
 )
 
-require (
-	github.com/gorilla/websocket v1.4.2 // indirect
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
-	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
-)
+require golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect